@@ -0,0 +1,236 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/collector"
+	"github.com/guacsec/guac/pkg/ingestor/collector/file"
+	"github.com/guacsec/guac/pkg/ingestor/collector/git"
+	"github.com/guacsec/guac/pkg/ingestor/collector/mq"
+	"github.com/guacsec/guac/pkg/ingestor/collector/oci"
+	"github.com/guacsec/guac/pkg/ingestor/collector/s3"
+	"github.com/guacsec/guac/pkg/ingestor/collector/webhook"
+	"github.com/guacsec/guac/pkg/ingestor/processor/process"
+)
+
+var collectorFlags = struct {
+	filePath       string
+	fileWatch      bool
+	fileCheckpoint string
+
+	webhookAddr     string
+	webhookSecret   string
+	webhookHMACKey  string
+	webhookTLSCert  string
+	webhookTLSKey   string
+	webhookClientCA string
+
+	ociImages    []string
+	ociPlainHTTP bool
+	ociUsername  string
+	ociPassword  string
+	ociInterval  time.Duration
+
+	gitRepository string
+	gitRef        string
+	gitPaths      []string
+	gitCheckpoint string
+	gitInterval   time.Duration
+
+	s3Endpoint      string
+	s3Bucket        string
+	s3Prefix        string
+	s3Region        string
+	s3AccessKey     string
+	s3SecretKey     string
+	s3SessionToken  string
+	s3Checkpoint    string
+	s3Interval      time.Duration
+	s3Notifications bool
+
+	mqURL               string
+	mqToken             string
+	mqCAFile            string
+	mqSubject           string
+	mqGroup             string
+	mqDeadLetterSubject string
+}{}
+
+func init() {
+	f := rootCmd.Flags()
+	f.StringVar(&collectorFlags.filePath, "file-path", "", "file or directory to collect documents from")
+	f.BoolVar(&collectorFlags.fileWatch, "file-watch", false, "keep watching --file-path for new and modified files")
+	f.StringVar(&collectorFlags.fileCheckpoint, "file-checkpoint", "", "file recording the collected files across restarts")
+
+	f.StringVar(&collectorFlags.webhookAddr, "webhook-addr", "", "address to serve the webhook collector on")
+	f.StringVar(&collectorFlags.webhookSecret, "webhook-secret", "", "shared secret webhook requests must give as bearer token, defaults to $GUAC_WEBHOOK_SECRET")
+	f.StringVar(&collectorFlags.webhookHMACKey, "webhook-hmac-key", "", "key of the HMAC-SHA256 webhook request bodies must be signed with, defaults to $GUAC_WEBHOOK_HMAC_KEY")
+	f.StringVar(&collectorFlags.webhookTLSCert, "webhook-tls-cert", "", "TLS certificate to serve the webhook collector with")
+	f.StringVar(&collectorFlags.webhookTLSKey, "webhook-tls-key", "", "TLS key to serve the webhook collector with")
+	f.StringVar(&collectorFlags.webhookClientCA, "webhook-client-ca", "", "CA certificates webhook client certificates must be issued by")
+
+	f.StringSliceVar(&collectorFlags.ociImages, "oci-image", nil, "image, as registry/repository[:tag][@digest], to collect the attached attestations and SBOMs of")
+	f.BoolVar(&collectorFlags.ociPlainHTTP, "oci-plain-http", false, "access the registries of --oci-image over HTTP")
+	f.StringVar(&collectorFlags.ociUsername, "oci-username", os.Getenv("GUAC_OCI_USERNAME"), "registry username, defaults to $GUAC_OCI_USERNAME")
+	f.StringVar(&collectorFlags.ociPassword, "oci-password", "", "registry password, defaults to $GUAC_OCI_PASSWORD")
+	f.DurationVar(&collectorFlags.ociInterval, "oci-interval", 0, "poll --oci-image for new referrers at this interval, collect once if 0")
+
+	f.StringVar(&collectorFlags.gitRepository, "git-repo", "", "path or file:// URL of a local git repository to collect documents from")
+	f.StringVar(&collectorFlags.gitRef, "git-ref", "HEAD", "branch, tag or commit of --git-repo to collect")
+	f.StringSliceVar(&collectorFlags.gitPaths, "git-path", nil, "pattern of the paths of --git-repo to collect, all if not set")
+	f.StringVar(&collectorFlags.gitCheckpoint, "git-checkpoint", "", "file recording the last collected commit, to collect only the files changed since")
+	f.DurationVar(&collectorFlags.gitInterval, "git-interval", 0, "poll --git-ref for new commits at this interval, collect once if 0")
+
+	f.StringVar(&collectorFlags.s3Endpoint, "s3-endpoint", "", "URL of the S3-compatible service to collect documents from")
+	f.StringVar(&collectorFlags.s3Bucket, "s3-bucket", "", "bucket to collect documents from")
+	f.StringVar(&collectorFlags.s3Prefix, "s3-prefix", "", "prefix of the keys of the objects of --s3-bucket to collect")
+	f.StringVar(&collectorFlags.s3Region, "s3-region", s3.DefaultRegion, "region of --s3-bucket")
+	f.StringVar(&collectorFlags.s3AccessKey, "s3-access-key", "", "S3 access key, defaults to $AWS_ACCESS_KEY_ID")
+	f.StringVar(&collectorFlags.s3SecretKey, "s3-secret-key", "", "S3 secret key, defaults to $AWS_SECRET_ACCESS_KEY")
+	f.StringVar(&collectorFlags.s3SessionToken, "s3-session-token", "", "S3 session token, defaults to $AWS_SESSION_TOKEN")
+	f.StringVar(&collectorFlags.s3Checkpoint, "s3-checkpoint", "", "file recording the collected objects across restarts")
+	f.DurationVar(&collectorFlags.s3Interval, "s3-interval", 0, "list --s3-bucket for new objects at this interval, list once if 0")
+	f.BoolVar(&collectorFlags.s3Notifications, "s3-notifications", false, "listen to the MinIO notifications of --s3-bucket for new objects")
+
+	f.StringVar(&collectorFlags.mqURL, "mq-url", "", "nats:// or tls:// URL of the NATS JetStream server to consume documents from")
+	f.StringVar(&collectorFlags.mqToken, "mq-token", "", "authentication token of --mq-url, only sent over TLS, defaults to $GUAC_MQ_TOKEN")
+	f.StringVar(&collectorFlags.mqCAFile, "mq-ca-file", "", "PEM CAs verifying the TLS certificate of --mq-url, the system roots if not set")
+	f.StringVar(&collectorFlags.mqSubject, "mq-subject", "", "subject the documents are published to, captured by a stream of --mq-url")
+	f.StringVar(&collectorFlags.mqGroup, "mq-group", mq.DefaultGroup, "consumer group, the durable consumer of the stream of --mq-subject")
+	f.StringVar(&collectorFlags.mqDeadLetterSubject, "mq-dead-letter-subject", "", "subject the documents failing processing are published to, --mq-subject with "+mq.DeadLetterSuffix+" if not set")
+}
+
+// registerCollectors registers the collectors configured by the flags,
+// verifying log entries with the log verifier of the processing options
+func registerCollectors(opts process.Options) error {
+	if collectorFlags.filePath != "" {
+		c, err := file.NewFileCollector(file.Options{
+			Path:       collectorFlags.filePath,
+			Watch:      collectorFlags.fileWatch,
+			Checkpoint: collectorFlags.fileCheckpoint,
+		})
+		if err != nil {
+			return err
+		}
+		if err := collector.RegisterDocumentCollector(c, file.CollectorType); err != nil {
+			return err
+		}
+	}
+	if collectorFlags.webhookAddr != "" {
+		c, err := webhook.NewWebhookCollector(webhook.Options{
+			Addr:         collectorFlags.webhookAddr,
+			Secret:       flagOrEnv(collectorFlags.webhookSecret, "GUAC_WEBHOOK_SECRET"),
+			HMACKey:      []byte(flagOrEnv(collectorFlags.webhookHMACKey, "GUAC_WEBHOOK_HMAC_KEY")),
+			TLSCertFile:  collectorFlags.webhookTLSCert,
+			TLSKeyFile:   collectorFlags.webhookTLSKey,
+			ClientCAFile: collectorFlags.webhookClientCA,
+		})
+		if err != nil {
+			return err
+		}
+		if err := collector.RegisterDocumentCollector(c, webhook.CollectorType); err != nil {
+			return err
+		}
+	}
+	if len(collectorFlags.ociImages) > 0 {
+		c, err := oci.NewOCICollector(oci.Options{
+			Images:      collectorFlags.ociImages,
+			PlainHTTP:   collectorFlags.ociPlainHTTP,
+			Username:    collectorFlags.ociUsername,
+			Password:    flagOrEnv(collectorFlags.ociPassword, "GUAC_OCI_PASSWORD"),
+			Interval:    collectorFlags.ociInterval,
+			LogVerifier: opts.LogVerifier,
+		})
+		if err != nil {
+			return err
+		}
+		if err := collector.RegisterDocumentCollector(c, oci.CollectorType); err != nil {
+			return err
+		}
+	}
+	if collectorFlags.gitRepository != "" {
+		c, err := git.NewGitCollector(git.Options{
+			Repository: collectorFlags.gitRepository,
+			Ref:        collectorFlags.gitRef,
+			Paths:      collectorFlags.gitPaths,
+			Checkpoint: collectorFlags.gitCheckpoint,
+			Interval:   collectorFlags.gitInterval,
+		})
+		if err != nil {
+			return err
+		}
+		if err := collector.RegisterDocumentCollector(c, git.CollectorType); err != nil {
+			return err
+		}
+	}
+	if collectorFlags.s3Bucket != "" {
+		c, err := s3.NewS3Collector(s3.Options{
+			Endpoint:      collectorFlags.s3Endpoint,
+			Bucket:        collectorFlags.s3Bucket,
+			Prefix:        collectorFlags.s3Prefix,
+			Region:        collectorFlags.s3Region,
+			AccessKey:     flagOrEnv(collectorFlags.s3AccessKey, "AWS_ACCESS_KEY_ID"),
+			SecretKey:     flagOrEnv(collectorFlags.s3SecretKey, "AWS_SECRET_ACCESS_KEY"),
+			SessionToken:  flagOrEnv(collectorFlags.s3SessionToken, "AWS_SESSION_TOKEN"),
+			Checkpoint:    collectorFlags.s3Checkpoint,
+			Interval:      collectorFlags.s3Interval,
+			Notifications: collectorFlags.s3Notifications,
+		})
+		if err != nil {
+			return err
+		}
+		if err := collector.RegisterDocumentCollector(c, s3.CollectorType); err != nil {
+			return err
+		}
+	}
+	if collectorFlags.mqURL != "" {
+		b, err := mq.NewNATSBroker(mq.NATSOptions{
+			URL:    collectorFlags.mqURL,
+			Token:  flagOrEnv(collectorFlags.mqToken, "GUAC_MQ_TOKEN"),
+			CAFile: collectorFlags.mqCAFile,
+		})
+		if err != nil {
+			return err
+		}
+		c, err := mq.NewMQCollector(mq.Options{
+			Broker:            b,
+			Subject:           collectorFlags.mqSubject,
+			Group:             collectorFlags.mqGroup,
+			DeadLetterSubject: collectorFlags.mqDeadLetterSubject,
+		})
+		if err != nil {
+			return err
+		}
+		if err := collector.RegisterDocumentCollector(c, mq.CollectorType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagOrEnv returns the flag value, or the value of the environment
+// variable if the flag is not set. Secrets are read from the environment
+// once flags are parsed, so that they do not show as flag defaults in the
+// help.
+func flagOrEnv(value, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
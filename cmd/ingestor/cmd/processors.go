@@ -0,0 +1,63 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/spf13/cobra"
+)
+
+var processorsCmd = &cobra.Command{
+	Use:   "processors",
+	Short: "list the installed document processors",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := processOptions()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TYPE\tFORMATS\tVERSIONS\tCHILD TYPES")
+		for _, d := range opts.Registry.List() {
+			desc, ok := opts.Registry.Describe(d)
+			if !ok {
+				fmt.Fprintf(w, "%s\t-\t-\t-\n", d)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d, join(desc.Formats), join(desc.Versions), join(desc.ChildTypes))
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(processorsCmd)
+}
+
+func join[T processor.FormatType | processor.DocumentType | string](values []T) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = string(v)
+	}
+	return strings.Join(s, ",")
+}
@@ -16,29 +16,113 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/guacsec/guac/pkg/ingestor/collector"
 	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/dsse"
+	"github.com/guacsec/guac/pkg/ingestor/processor/process"
+	"github.com/guacsec/guac/pkg/ingestor/processor/sigstore"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+var flags = struct {
+	trustPolicy   string
+	tlogPublicKey string
+}{}
+
 var rootCmd = &cobra.Command{
 	Use:   "ingestor",
 	Short: "ingestor is a ingestor cmdline for GUAC",
-	Run: func(cmd *cobra.Command, args []string) {
-		// Do Stuff Here
-		var (
-			collector collector.Collector
-			processor processor.DocumentProcessor
-		)
-		fmt.Println("Artifact ff")
-		_ = collector
-		_ = processor
+	// errors are printed by Execute
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := processOptions()
+		if err != nil {
+			return err
+		}
+		if err := registerCollectors(opts); err != nil {
+			return err
+		}
+
+		// collectors stop gracefully on interrupt
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return collector.Collect(ctx, ingest(ctx, opts), func(err error) bool {
+			logrus.Errorf("collection error: %v", err)
+			return true
+		})
 	},
 }
 
+// ingest returns an emitter processing the collected documents. It fails
+// if the document or any document unpacked from it is not accepted, so
+// that its collector does not acknowledge it as handled.
+func ingest(ctx context.Context, opts process.Options) collector.Emitter {
+	return func(d *processor.Document) error {
+		res, err := process.ProcessContext(ctx, d, opts)
+		if err != nil {
+			return fmt.Errorf("unable to process %s from %s collector: %w", d.SourceInformation.Source, d.SourceInformation.Collector, err)
+		}
+		failed := res.Failed()
+		logrus.Infof("processed %s from %s collector: %d documents accepted, %d failed", d.SourceInformation.Source, d.SourceInformation.Collector, len(res.Documents), len(failed))
+		if len(failed) > 0 {
+			return fmt.Errorf("unable to process %s from %s collector: %d documents failed, first with %s: %w", d.SourceInformation.Source, d.SourceInformation.Collector, len(failed), failed[0].Outcome, failed[0].Err)
+		}
+		return nil
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flags.trustPolicy, "trust-policy", "", "path to a YAML or JSON trust policy file")
+	rootCmd.PersistentFlags().StringVar(&flags.tlogPublicKey, "tlog-public-key", "", "path to the PEM public key of the transparency log, to verify log entries offline")
+}
+
+// processOptions returns the document processing options from the flags.
+// Keys and certificate roots of the trust policy, and the transparency log
+// key dating keyless signatures, are used to verify DSSE envelopes and
+// Sigstore bundles.
+func processOptions() (process.Options, error) {
+	opts := process.Options{Registry: process.NewRegistry()}
+	if flags.tlogPublicKey != "" {
+		b, err := os.ReadFile(flags.tlogPublicKey)
+		if err != nil {
+			return opts, fmt.Errorf("unable to read transparency log public key: %w", err)
+		}
+		if opts.LogVerifier, err = tlog.NewVerifierFromPEM(b); err != nil {
+			return opts, fmt.Errorf("unable to load transparency log public key: %w", err)
+		}
+	}
+
+	// without trust policy, envelopes and bundles are recognized but have
+	// no verifiers, and so are rejected
+	dp := dsse.NewDSSEProcessor().WithLogVerifier(opts.LogVerifier)
+	if flags.trustPolicy != "" {
+		tp, err := process.LoadTrustPolicyFile(flags.trustPolicy)
+		if err != nil {
+			return opts, fmt.Errorf("unable to load trust policy: %w", err)
+		}
+		dp = dsse.NewDSSEProcessor(tp.Verifiers()...).
+			WithCertificateVerifier(tp.CertificateVerifier()).
+			WithLogVerifier(opts.LogVerifier)
+		opts.Policy = tp
+	}
+	if err := opts.Registry.Register(dp, processor.DocumentDSSE); err != nil {
+		return opts, err
+	}
+	if err := opts.Registry.Register(sigstore.NewBundleProcessor(dp), processor.DocumentSigstoreBundle); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
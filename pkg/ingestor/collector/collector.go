@@ -15,4 +15,250 @@
 
 package collector
 
-type Collector interface{}
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// Collector retrieves documents from a source and streams them to the
+// ingestor
+type Collector interface {
+	// RetrieveArtifacts sends the collected documents to docChannel until
+	// there is nothing left to collect or ctx is done. It must not close
+	// docChannel, and must stop sending when ctx is done.
+	//
+	// Cancelling ctx is a graceful shutdown: the collector releases its
+	// resources, saves any checkpoint, and returns nil.
+	RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error
+	// Type is the collector type, used as the SourceInformation.Collector
+	// of the documents that do not set it
+	Type() string
+}
+
+// Acknowledger is implemented by collectors that need to know whether
+// their documents were handled, such as message queue consumers with
+// at-least-once delivery. Collect calls Ack with the error returned by the
+// emitter for each document of the collector, nil if it was handled.
+// Documents not given to the emitter, once collection stops, are not
+// acknowledged.
+type Acknowledger interface {
+	Ack(d *processor.Document, err error)
+}
+
+// Emitter handles a collected document
+type Emitter func(*processor.Document) error
+
+// ErrHandler handles the errors of collectors and of the emitter. It
+// returns whether collection should go on.
+type ErrHandler func(error) bool
+
+var (
+	// ErrCollectorExists is returned when registering a collector under a
+	// name already in use
+	ErrCollectorExists = errors.New("collector already registered")
+	// ErrNoCollectors is returned when collecting without collectors
+	ErrNoCollectors = errors.New("no collectors registered")
+)
+
+// Registry holds named collectors to run together. It is safe for
+// concurrent use, and its zero value is an empty registry.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]Collector
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register registers the collector under the name
+func (r *Registry) Register(c Collector, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.collectors[name]; ok {
+		return fmt.Errorf("%w: %s", ErrCollectorExists, name)
+	}
+	if r.collectors == nil {
+		r.collectors = map[string]Collector{}
+	}
+	r.collectors[name] = c
+	return nil
+}
+
+// Unregister removes the collector registered under the name, and returns
+// whether there was one
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.collectors[name]
+	delete(r.collectors, name)
+	return ok
+}
+
+// Lookup returns the collector registered under the name
+func (r *Registry) Lookup(name string) (Collector, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.collectors[name]
+	return c, ok
+}
+
+// List returns the names of the registered collectors, sorted
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collect runs all the registered collectors concurrently and calls emit
+// with every collected document, one document at a time. Documents with no
+// SourceInformation.Collector get the type of their collector.
+//
+// The result of emit is given to collectors implementing Acknowledger.
+// Errors returned by the collectors or by emit are given to handleErr, and
+// collection stops if it returns false. Collection also stops when ctx is
+// done. In every case Collect waits for all the collectors to return.
+//
+// Collect returns nil once all collectors are done or after a graceful
+// shutdown, and the error that stopped collection otherwise.
+func (r *Registry) Collect(ctx context.Context, emit Emitter, handleErr ErrHandler) error {
+	r.mu.RLock()
+	collectors := make(map[string]Collector, len(r.collectors))
+	for name, c := range r.collectors {
+		collectors[name] = c
+	}
+	r.mu.RUnlock()
+	if len(collectors) == 0 {
+		return ErrNoCollectors
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	docs := make(chan collected)
+	errs := make(chan error, len(collectors))
+	var wg sync.WaitGroup
+	for name, c := range collectors {
+		wg.Add(1)
+		go func(name string, c Collector) {
+			defer wg.Done()
+			if err := run(ctx, c, docs); err != nil {
+				errs <- fmt.Errorf("collector %s: %w", name, err)
+			}
+		}(name, c)
+	}
+	go func() {
+		wg.Wait()
+		close(docs)
+	}()
+
+	var stopErr error
+	stop := func(err error) {
+		if stopErr == nil && !handleErr(err) {
+			stopErr = err
+			cancel()
+		}
+	}
+	for docs != nil {
+		select {
+		case cd, ok := <-docs:
+			if !ok {
+				docs = nil
+				break
+			}
+			if stopErr != nil {
+				continue
+			}
+			err := emit(cd.doc)
+			if a, ok := cd.collector.(Acknowledger); ok {
+				a.Ack(cd.doc, err)
+			}
+			if err != nil {
+				stop(err)
+			}
+		case err := <-errs:
+			stop(err)
+		}
+	}
+	// errors sent after the last document
+	for {
+		select {
+		case err := <-errs:
+			stop(err)
+		default:
+			return stopErr
+		}
+	}
+}
+
+// collected is a document with the collector it comes from
+type collected struct {
+	doc       *processor.Document
+	collector Collector
+}
+
+// run runs the collector and forwards its documents to docs until it
+// returns. Errors caused by ctx being done are not returned.
+func run(ctx context.Context, c Collector, docs chan<- collected) error {
+	ch := make(chan *processor.Document)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.RetrieveArtifacts(ctx, ch)
+		close(ch)
+	}()
+
+	for d := range ch {
+		if d.SourceInformation.Collector == "" {
+			d.SourceInformation.Collector = c.Type()
+		}
+		select {
+		case docs <- collected{doc: d, collector: c}:
+		case <-ctx.Done():
+		}
+	}
+
+	err := <-done
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return nil
+	}
+	return err
+}
+
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry returns the registry the package level functions use
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// RegisterDocumentCollector registers the collector under the name in the
+// default registry
+func RegisterDocumentCollector(c Collector, name string) error {
+	return defaultRegistry.Register(c, name)
+}
+
+// UnregisterDocumentCollector removes the collector registered under the
+// name from the default registry
+func UnregisterDocumentCollector(name string) {
+	defaultRegistry.Unregister(name)
+}
+
+// Collect runs the collectors of the default registry, see Registry.Collect
+func Collect(ctx context.Context, emit Emitter, handleErr ErrHandler) error {
+	return defaultRegistry.Collect(ctx, emit, handleErr)
+}
@@ -0,0 +1,244 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// mockCollector sends its documents, then waits for ctx to be done if
+// watch is set, and returns err
+type mockCollector struct {
+	docs  []*processor.Document
+	watch bool
+	err   error
+}
+
+func (c *mockCollector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	for _, d := range c.docs {
+		select {
+		case docChannel <- d:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if c.watch {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return c.err
+}
+
+func (c *mockCollector) Type() string {
+	return "mock"
+}
+
+func doc(blob string, collector string) *processor.Document {
+	return &processor.Document{
+		Blob:              []byte(blob),
+		SourceInformation: processor.SourceInformation{Collector: collector},
+	}
+}
+
+func Test_Collect(t *testing.T) {
+	errCollector := errors.New("collector error")
+	errEmit := errors.New("emit error")
+
+	testCases := []struct {
+		name          string
+		collectors    map[string]Collector
+		emitErr       error
+		continueOnErr bool
+		expectedBlobs []string
+		expectedErrs  int
+		expectErr     error
+	}{{
+		name: "documents of all collectors",
+		collectors: map[string]Collector{
+			"a": &mockCollector{docs: []*processor.Document{doc("a1", ""), doc("a2", "")}},
+			"b": &mockCollector{docs: []*processor.Document{doc("b1", "")}},
+		},
+		expectedBlobs: []string{"a1", "a2", "b1"},
+	}, {
+		name: "collector error stops collection",
+		collectors: map[string]Collector{
+			"a": &mockCollector{docs: []*processor.Document{doc("a1", "")}, err: errCollector},
+		},
+		expectedBlobs: []string{"a1"},
+		expectedErrs:  1,
+		expectErr:     errCollector,
+	}, {
+		name: "collector error handled",
+		collectors: map[string]Collector{
+			"a": &mockCollector{err: errCollector},
+			"b": &mockCollector{docs: []*processor.Document{doc("b1", "")}},
+		},
+		continueOnErr: true,
+		expectedBlobs: []string{"b1"},
+		expectedErrs:  1,
+	}, {
+		name: "collector error stops watching collectors",
+		collectors: map[string]Collector{
+			"a": &mockCollector{err: errCollector},
+			"b": &mockCollector{watch: true},
+		},
+		expectedErrs: 1,
+		expectErr:    errCollector,
+	}, {
+		name: "emit error stops collection",
+		collectors: map[string]Collector{
+			"a": &mockCollector{docs: []*processor.Document{doc("a1", ""), doc("a2", "")}, watch: true},
+		},
+		emitErr:       errEmit,
+		expectedBlobs: []string{"a1"},
+		expectedErrs:  1,
+		expectErr:     errEmit,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			for name, c := range tt.collectors {
+				if err := r.Register(c, name); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var blobs []string
+			emit := func(d *processor.Document) error {
+				if d.SourceInformation.Collector != "mock" {
+					t.Errorf("got collector %q, expected mock", d.SourceInformation.Collector)
+				}
+				blobs = append(blobs, string(d.Blob))
+				return tt.emitErr
+			}
+			errs := 0
+			handleErr := func(err error) bool {
+				errs++
+				return tt.continueOnErr
+			}
+
+			err := r.Collect(context.Background(), emit, handleErr)
+			if !errors.Is(err, tt.expectErr) {
+				t.Errorf("got error %v, expected %v", err, tt.expectErr)
+			}
+			sort.Strings(blobs)
+			if !reflect.DeepEqual(blobs, tt.expectedBlobs) {
+				t.Errorf("got documents %v, expected %v", blobs, tt.expectedBlobs)
+			}
+			if errs != tt.expectedErrs {
+				t.Errorf("got %v errors, expected %v", errs, tt.expectedErrs)
+			}
+		})
+	}
+}
+
+func Test_CollectShutdown(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&mockCollector{docs: []*processor.Document{doc("a1", "other")}, watch: true}, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.Collect(ctx, func(d *processor.Document) error {
+			if d.SourceInformation.Collector != "other" {
+				t.Errorf("collector set by the collector should be kept")
+			}
+			cancel()
+			return nil
+		}, func(err error) bool {
+			t.Errorf("unexpected error: %v", err)
+			return false
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected graceful shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collection did not stop")
+	}
+}
+
+// ackCollector records the acknowledgements of its documents
+type ackCollector struct {
+	mockCollector
+	acks map[string]error
+}
+
+func (c *ackCollector) Ack(d *processor.Document, err error) {
+	c.acks[string(d.Blob)] = err
+}
+
+func Test_CollectAck(t *testing.T) {
+	errEmit := errors.New("emit error")
+	c := &ackCollector{
+		mockCollector: mockCollector{docs: []*processor.Document{doc("ok", ""), doc("bad", ""), doc("ok2", "")}},
+		acks:          map[string]error{},
+	}
+	r := NewRegistry()
+	_ = r.Register(c, "a")
+	_ = r.Register(&mockCollector{docs: []*processor.Document{doc("other", "")}}, "b")
+
+	err := r.Collect(context.Background(), func(d *processor.Document) error {
+		if string(d.Blob) == "bad" {
+			return errEmit
+		}
+		return nil
+	}, func(err error) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]error{"ok": nil, "bad": errEmit, "ok2": nil}
+	if !reflect.DeepEqual(c.acks, expected) {
+		t.Errorf("got acks %v, expected %v", c.acks, expected)
+	}
+}
+
+func Test_Registry(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Collect(context.Background(), nil, nil); !errors.Is(err, ErrNoCollectors) {
+		t.Errorf("got error %v, expected %v", err, ErrNoCollectors)
+	}
+	c := &mockCollector{}
+	if err := r.Register(c, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(c, "a"); !errors.Is(err, ErrCollectorExists) {
+		t.Errorf("got error %v, expected %v", err, ErrCollectorExists)
+	}
+	_ = r.Register(c, "b")
+	if names := r.List(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("unexpected collectors %v", names)
+	}
+	if got, ok := r.Lookup("a"); !ok || got != c {
+		t.Errorf("unexpected collector %v", got)
+	}
+	if !r.Unregister("a") || r.Unregister("a") {
+		t.Errorf("expected a to be unregistered once")
+	}
+}
@@ -0,0 +1,89 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// checkpoint records the modification time and size of the collected
+// files. An empty name keeps it in memory only.
+type checkpoint struct {
+	name  string
+	dirty bool
+	Files map[string]fileState `json:"files"`
+}
+
+type fileState struct {
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+}
+
+func loadCheckpoint(name string) (*checkpoint, error) {
+	cp := &checkpoint{name: name, Files: map[string]fileState{}}
+	if name == "" {
+		return cp, nil
+	}
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	if cp.Files == nil {
+		cp.Files = map[string]fileState{}
+	}
+	return cp, nil
+}
+
+// changed returns whether the file was modified since it was recorded
+func (cp *checkpoint) changed(path string, info os.FileInfo) bool {
+	s, ok := cp.Files[path]
+	return !ok || !s.ModTime.Equal(info.ModTime()) || s.Size != info.Size()
+}
+
+func (cp *checkpoint) record(path string, info os.FileInfo) {
+	cp.Files[path] = fileState{ModTime: info.ModTime(), Size: info.Size()}
+	cp.dirty = true
+}
+
+// save writes the checkpoint if it changed. The checkpoint is written to
+// a temporary file renamed over the previous one, so that a crash never
+// leaves a partial checkpoint.
+func (cp *checkpoint) save() error {
+	if cp.name == "" || !cp.dirty {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, cp.name); err != nil {
+		return err
+	}
+	cp.dirty = false
+	return nil
+}
@@ -0,0 +1,305 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file implements a collector of the files of a directory
+package file
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorType is the type of the file collector
+const CollectorType = "file"
+
+// DefaultDebounce is the debounce delay used when Options.Debounce is not
+// set
+const DefaultDebounce = 500 * time.Millisecond
+
+// saveInterval is the minimum delay between checkpoint saves while files
+// are being acknowledged
+const saveInterval = time.Second
+
+// Options configures a FileCollector
+type Options struct {
+	// Path is the file or directory to collect, directories are walked
+	// recursively
+	Path string
+	// Watch keeps watching Path for new and modified files after the
+	// initial walk, until the context is done
+	Watch bool
+	// Debounce is how long a watched file must go unmodified before it
+	// is collected, DefaultDebounce if 0
+	Debounce time.Duration
+	// Checkpoint is the file recording the handled files, so that files
+	// left unmodified are not collected again after a restart. No
+	// checkpoint is kept if empty.
+	Checkpoint string
+}
+
+// FileCollector collects files as documents, with the file path as
+// SourceInformation.Source. Files are recorded in the checkpoint once
+// their document is acknowledged without error.
+type FileCollector struct {
+	opts Options
+
+	mu      sync.Mutex
+	cp      *checkpoint
+	pending map[*processor.Document]pendingFile
+	running bool
+	saved   time.Time
+}
+
+// pendingFile is a collected file waiting for its acknowledgement
+type pendingFile struct {
+	path string
+	info os.FileInfo
+}
+
+// NewFileCollector creates a file collector
+func NewFileCollector(opts Options) (*FileCollector, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("file collector path shouldn't be empty")
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = DefaultDebounce
+	}
+	return &FileCollector{opts: opts}, nil
+}
+
+func (c *FileCollector) Type() string {
+	return CollectorType
+}
+
+// RetrieveArtifacts walks the path, then watches it in watch mode. Entries
+// that cannot be read are logged and skipped.
+func (c *FileCollector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	if _, err := os.Stat(c.opts.Path); err != nil {
+		return err
+	}
+	cp, err := loadCheckpoint(c.opts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cp, c.pending, c.running, c.saved = cp, map[*processor.Document]pendingFile{}, true, time.Now()
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.running = false
+		if err := c.cp.save(); err != nil {
+			logrus.Errorf("unable to save file collector checkpoint: %v", err)
+		}
+	}()
+
+	var watcher *fsnotify.Watcher
+	if c.opts.Watch {
+		// the watcher is set up before the walk so that no file created
+		// during the walk is missed
+		if watcher, err = fsnotify.NewWatcher(); err != nil {
+			return err
+		}
+		defer watcher.Close()
+	}
+
+	if err := c.walk(ctx, c.opts.Path, watcher, docChannel); err != nil {
+		return err
+	}
+	if watcher == nil {
+		return nil
+	}
+	return c.watch(ctx, watcher, docChannel)
+}
+
+// Ack records the file of the document in the checkpoint if it was handled
+// without error. The checkpoint is saved at most every saveInterval, and
+// once the collector stopped and all its documents are acknowledged.
+func (c *FileCollector) Ack(d *processor.Document, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.pending[d]
+	if !ok {
+		return
+	}
+	delete(c.pending, d)
+	if err != nil {
+		return
+	}
+	c.cp.record(f.path, f.info)
+	if time.Since(c.saved) < saveInterval && (c.running || len(c.pending) > 0) {
+		return
+	}
+	if err := c.cp.save(); err != nil {
+		logrus.Errorf("unable to save file collector checkpoint: %v", err)
+	}
+	c.saved = time.Now()
+}
+
+// walk collects the files under root, adding the directories to the
+// watcher if not nil
+func (c *FileCollector) walk(ctx context.Context, root string, watcher *fsnotify.Watcher, docChannel chan<- *processor.Document) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logrus.Warnf("skipping %s: %v", path, err)
+			return nil
+		}
+		if watcher != nil && (d.IsDir() || path == root) {
+			if err := watcher.Add(path); err != nil {
+				logrus.Warnf("unable to watch %s: %v", path, err)
+			}
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return c.collect(ctx, path, docChannel)
+	})
+}
+
+// collect sends the file to docChannel if it is a regular file not handled
+// since its last modification. Files that cannot be read are logged and
+// skipped, only ctx errors are returned.
+func (c *FileCollector) collect(ctx context.Context, path string, docChannel chan<- *processor.Document) error {
+	if c.isCheckpoint(path) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Warnf("skipping %s: %v", path, err)
+		}
+		// otherwise removed since listed
+		return nil
+	}
+	c.mu.Lock()
+	changed := c.cp.changed(path, info)
+	c.mu.Unlock()
+	if !info.Mode().IsRegular() || !changed {
+		return nil
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		logrus.Warnf("skipping %s: %v", path, err)
+		return nil
+	}
+
+	doc := &processor.Document{
+		Blob:   blob,
+		Type:   processor.DocumentUnknown,
+		Format: processor.FormatUnknown,
+		SourceInformation: processor.SourceInformation{
+			Collector: CollectorType,
+			Source:    path,
+		},
+	}
+	// the document is pending before being sent, as it may be
+	// acknowledged as soon as it is received
+	c.mu.Lock()
+	c.pending[doc] = pendingFile{path: path, info: info}
+	c.mu.Unlock()
+	select {
+	case docChannel <- doc:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, doc)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *FileCollector) isCheckpoint(path string) bool {
+	if c.opts.Checkpoint == "" {
+		return false
+	}
+	a, err1 := filepath.Abs(path)
+	b, err2 := filepath.Abs(c.opts.Checkpoint)
+	return err1 == nil && err2 == nil && (a == b || a == b+".tmp")
+}
+
+// watch collects created and modified files once they have not been
+// modified for the debounce delay, until ctx is done
+func (c *FileCollector) watch(ctx context.Context, watcher *fsnotify.Watcher, docChannel chan<- *processor.Document) error {
+	interval := c.opts.Debounce / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := map[string]time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("file watcher error: %w", err)
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+				continue
+			}
+			info, err := os.Stat(ev.Name)
+			if err != nil {
+				continue
+			}
+			if info.IsDir() {
+				// new directories are walked as files may have been
+				// created in them before they were watched
+				if err := c.walk(ctx, ev.Name, watcher, docChannel); err != nil {
+					return ignoreDone(ctx, err)
+				}
+				continue
+			}
+			pending[ev.Name] = time.Now()
+		case now := <-ticker.C:
+			for path, t := range pending {
+				if now.Sub(t) < c.opts.Debounce {
+					continue
+				}
+				delete(pending, path)
+				if err := c.collect(ctx, path, docChannel); err != nil {
+					return ignoreDone(ctx, err)
+				}
+			}
+		}
+	}
+}
+
+// ignoreDone returns nil for errors caused by ctx being done
+func ignoreDone(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,258 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+func writeFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// collectAll runs the collector until it returns and returns the
+// collected documents by source, acknowledging them as handled
+func collectAll(t *testing.T, c *FileCollector) map[string]string {
+	return collectAllAck(t, c, nil)
+}
+
+// collectAllAck is collectAll acknowledging the documents with ackErr
+func collectAllAck(t *testing.T, c *FileCollector, ackErr error) map[string]string {
+	ch := make(chan *processor.Document)
+	done := make(chan error)
+	go func() {
+		done <- c.RetrieveArtifacts(context.Background(), ch)
+	}()
+	docs := map[string]string{}
+	for {
+		select {
+		case d := <-ch:
+			if d.SourceInformation.Collector != CollectorType {
+				t.Errorf("got collector %q, expected %q", d.SourceInformation.Collector, CollectorType)
+			}
+			docs[d.SourceInformation.Source] = string(d.Blob)
+			c.Ack(d, ackErr)
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return docs
+		}
+	}
+}
+
+func Test_FileCollector(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), "a")
+	writeFile(t, filepath.Join(dir, "sub", "b.json"), "b")
+	writeFile(t, filepath.Join(dir, "sub", "deeper", "c.json"), "c")
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected map[string]string
+	}{{
+		name: "directory",
+		path: dir,
+		expected: map[string]string{
+			filepath.Join(dir, "a.json"):                  "a",
+			filepath.Join(dir, "sub", "b.json"):           "b",
+			filepath.Join(dir, "sub", "deeper", "c.json"): "c",
+		},
+	}, {
+		name:     "single file",
+		path:     filepath.Join(dir, "a.json"),
+		expected: map[string]string{filepath.Join(dir, "a.json"): "a"},
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewFileCollector(Options{Path: tt.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if docs := collectAll(t, c); !reflect.DeepEqual(docs, tt.expected) {
+				t.Errorf("got documents %v, expected %v", docs, tt.expected)
+			}
+		})
+	}
+
+	if _, err := NewFileCollector(Options{}); err == nil {
+		t.Errorf("expected error for empty path")
+	}
+	c, _ := NewFileCollector(Options{Path: filepath.Join(dir, "missing")})
+	if err := c.RetrieveArtifacts(context.Background(), make(chan *processor.Document)); err == nil {
+		t.Errorf("expected error for missing path")
+	}
+}
+
+func Test_FileCollectorCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	docsDir := filepath.Join(dir, "docs")
+	writeFile(t, filepath.Join(docsDir, "a.json"), "a")
+	writeFile(t, filepath.Join(docsDir, "b.json"), "b")
+	opts := Options{Path: docsDir, Checkpoint: filepath.Join(dir, "checkpoint.json")}
+
+	c, _ := NewFileCollector(opts)
+	if docs := collectAll(t, c); len(docs) != 2 {
+		t.Fatalf("got %v documents, expected 2", len(docs))
+	}
+
+	// a restarted collector only collects new and modified files
+	writeFile(t, filepath.Join(docsDir, "b.json"), "b modified")
+	writeFile(t, filepath.Join(docsDir, "c.json"), "c")
+	c, _ = NewFileCollector(opts)
+	expected := map[string]string{
+		filepath.Join(docsDir, "b.json"): "b modified",
+		filepath.Join(docsDir, "c.json"): "c",
+	}
+	if docs := collectAll(t, c); !reflect.DeepEqual(docs, expected) {
+		t.Errorf("got documents %v, expected %v", docs, expected)
+	}
+
+	c, _ = NewFileCollector(opts)
+	if docs := collectAll(t, c); len(docs) != 0 {
+		t.Errorf("got documents %v, expected none", docs)
+	}
+
+	// files whose documents failed are collected again
+	writeFile(t, filepath.Join(docsDir, "d.json"), "d")
+	c, _ = NewFileCollector(opts)
+	if docs := collectAllAck(t, c, errors.New("failed")); len(docs) != 1 {
+		t.Errorf("got documents %v, expected the new document", docs)
+	}
+	c, _ = NewFileCollector(opts)
+	expected = map[string]string{filepath.Join(docsDir, "d.json"): "d"}
+	if docs := collectAll(t, c); !reflect.DeepEqual(docs, expected) {
+		t.Errorf("got documents %v, expected %v", docs, expected)
+	}
+
+	// the checkpoint itself is not collected when in the path
+	if err := os.Remove(opts.Checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	opts = Options{Path: dir, Checkpoint: filepath.Join(dir, "other-checkpoint.json")}
+	c, _ = NewFileCollector(opts)
+	if docs := collectAll(t, c); len(docs) != 4 {
+		t.Errorf("got documents %v, expected the 4 documents", docs)
+	}
+}
+
+func Test_FileCollectorSkipsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), "a")
+	writeFile(t, filepath.Join(dir, "locked", "b.json"), "b")
+	writeFile(t, filepath.Join(dir, "unreadable.json"), "c")
+	writeFile(t, filepath.Join(dir, "z.json"), "z")
+	if err := os.Chmod(filepath.Join(dir, "unreadable.json"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "locked"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(dir, "locked"), 0700)
+
+	c, _ := NewFileCollector(Options{Path: dir})
+	expected := map[string]string{
+		filepath.Join(dir, "a.json"): "a",
+		filepath.Join(dir, "z.json"): "z",
+	}
+	if docs := collectAll(t, c); !reflect.DeepEqual(docs, expected) {
+		t.Errorf("got documents %v, expected %v", docs, expected)
+	}
+}
+
+func Test_FileCollectorWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), "a")
+	c, _ := NewFileCollector(Options{Path: dir, Watch: true, Debounce: 100 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *processor.Document)
+	done := make(chan error)
+	go func() {
+		done <- c.RetrieveArtifacts(ctx, ch)
+	}()
+
+	next := func() *processor.Document {
+		select {
+		case d := <-ch:
+			return d
+		case err := <-done:
+			t.Fatalf("collector returned early: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for document")
+		}
+		return nil
+	}
+
+	if d := next(); string(d.Blob) != "a" {
+		t.Fatalf("got %s, expected initial file", d.Blob)
+	}
+
+	// a file written several times is collected once written
+	f := filepath.Join(dir, "b.json")
+	for _, content := range []string{"b1", "b2", "b3"} {
+		writeFile(t, f, content)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := next(); string(d.Blob) != "b3" || d.SourceInformation.Source != f {
+		t.Errorf("got %s from %s, expected the last content of %s", d.Blob, d.SourceInformation.Source, f)
+	}
+
+	// files of new directories are collected
+	writeFile(t, filepath.Join(dir, "sub", "c.json"), "c")
+	writeFile(t, filepath.Join(dir, "sub", "d.json"), "d")
+	blobs := []string{string(next().Blob), string(next().Blob)}
+	sort.Strings(blobs)
+	if !reflect.DeepEqual(blobs, []string{"c", "d"}) {
+		t.Errorf("got %v, expected files of new directory", blobs)
+	}
+
+	select {
+	case d := <-ch:
+		t.Errorf("unexpected document %s", d.SourceInformation.Source)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected graceful shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector did not stop")
+	}
+}
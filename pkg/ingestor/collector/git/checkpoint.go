@@ -0,0 +1,65 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// checkpoint records the last collected commit. An empty name keeps it in
+// memory only.
+type checkpoint struct {
+	name   string
+	Commit string `json:"commit"`
+}
+
+func loadCheckpoint(name string) (*checkpoint, error) {
+	cp := &checkpoint{name: name}
+	if name == "" {
+		return cp, nil
+	}
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// record records the commit and saves the checkpoint. The checkpoint is
+// written to a temporary file renamed over the previous one, so that a
+// crash never leaves a partial checkpoint.
+func (cp *checkpoint) record(commit string) error {
+	cp.Commit = commit
+	if cp.name == "" {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.name)
+}
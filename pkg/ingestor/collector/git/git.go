@@ -0,0 +1,412 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package git implements a collector of the files committed to a git
+// repository
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorType is the type of the git collector
+const CollectorType = "git"
+
+const (
+	modeSymlink   = "120000"
+	modeSubmodule = "160000"
+)
+
+// Options configures a GitCollector
+type Options struct {
+	// Repository is the path or file:// URL of a local repository, bare
+	// or not
+	Repository string
+	// Ref is the branch, tag or commit to collect, HEAD if empty
+	Ref string
+	// Paths are the path.Match patterns of the files to collect, relative
+	// to the root of the repository. Patterns without a slash match the
+	// file names in any directory. All files are collected if empty.
+	Paths []string
+	// Checkpoint is the file recording the last collected commit. Once
+	// recorded, only the files added or modified by the commits since
+	// are collected. No checkpoint is kept if empty.
+	Checkpoint string
+	// Interval polls Ref for new commits every Interval until the context
+	// is done, it is collected once if 0
+	Interval time.Duration
+	// Git is the git binary used, "git" if empty
+	Git string
+}
+
+// GitCollector collects the files of a repository as documents, with the
+// repository URL, commit SHA and path as SourceInformation.Source, of the
+// form file:///repo@<commit>:<path>
+type GitCollector struct {
+	opts Options
+	dir  string
+	url  string
+
+	mu      sync.Mutex
+	cp      *checkpoint
+	commits []*pendingCommit
+	docs    map[*processor.Document]*pendingCommit
+	blocked bool
+}
+
+// pendingCommit is a collected commit waiting for the acknowledgement of
+// its documents
+type pendingCommit struct {
+	commit  string
+	pending int
+	sent    bool
+	failed  bool
+}
+
+// file is a file of a commit
+type file struct {
+	mode   string
+	object string
+	path   string
+}
+
+// NewGitCollector creates a git collector
+func NewGitCollector(opts Options) (*GitCollector, error) {
+	if opts.Repository == "" {
+		return nil, fmt.Errorf("git collector repository shouldn't be empty")
+	}
+	for _, p := range opts.Paths {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+	}
+	dir := opts.Repository
+	if strings.Contains(dir, "://") {
+		u, err := url.Parse(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository URL %q: %w", dir, err)
+		}
+		if u.Scheme != "file" || u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("repository %q is not local", dir)
+		}
+		dir = u.Path
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Ref == "" {
+		opts.Ref = "HEAD"
+	}
+	if opts.Git == "" {
+		opts.Git = "git"
+	}
+	return &GitCollector{
+		opts: opts,
+		dir:  dir,
+		url:  (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String(),
+	}, nil
+}
+
+func (c *GitCollector) Type() string {
+	return CollectorType
+}
+
+// RetrieveArtifacts collects the files at Ref, or the files changed since
+// the checkpoint, then polls Ref every Options.Interval. The commits are
+// recorded in the checkpoint in order, once all their documents are
+// acknowledged.
+func (c *GitCollector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	cp, err := loadCheckpoint(c.opts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cp, c.commits, c.docs, c.blocked = cp, nil, map[*processor.Document]*pendingCommit{}, false
+	c.mu.Unlock()
+	// last is the last commit sent, ahead of the checkpoint until its
+	// documents are acknowledged
+	last := cp.Commit
+	for {
+		var err error
+		if last, err = c.collect(ctx, last, docChannel); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if c.opts.Interval <= 0 {
+				return err
+			}
+			logrus.Errorf("unable to collect %s: %v", c.url, err)
+		}
+		if c.opts.Interval <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.opts.Interval):
+		}
+	}
+}
+
+// collect sends the files of the commits of Ref since last to docChannel
+// and returns the last commit sent
+func (c *GitCollector) collect(ctx context.Context, last string, docChannel chan<- *processor.Document) (string, error) {
+	head, err := c.git(ctx, "rev-parse", "--verify", "--end-of-options", c.opts.Ref+"^{commit}")
+	if err != nil {
+		return last, err
+	}
+	commit := strings.TrimSpace(string(head))
+	if commit == last {
+		return last, nil
+	}
+
+	if last != "" {
+		commits, err := c.commitsSince(ctx, last, commit)
+		if err == nil {
+			for _, cs := range commits {
+				files, err := c.changedFiles(ctx, cs[0], cs[1])
+				if err != nil {
+					return last, err
+				}
+				if err := c.send(ctx, cs[0], files, docChannel); err != nil {
+					return last, err
+				}
+				last = cs[0]
+			}
+			return last, nil
+		}
+		if ctx.Err() != nil {
+			return last, err
+		}
+		logrus.Warnf("unable to list commits of %s since %s, collecting all files: %v", c.url, last, err)
+	}
+
+	files, err := c.tree(ctx, commit)
+	if err != nil {
+		return last, err
+	}
+	if err := c.send(ctx, commit, files, docChannel); err != nil {
+		return last, err
+	}
+	return commit, nil
+}
+
+// send sends the files of commit matching the path patterns to
+// docChannel. The commit is recorded once all its documents are
+// acknowledged, it is failed if they are not all sent.
+func (c *GitCollector) send(ctx context.Context, commit string, files []file, docChannel chan<- *processor.Document) (err error) {
+	pc := &pendingCommit{commit: commit}
+	c.mu.Lock()
+	c.commits = append(c.commits, pc)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		pc.sent = true
+		if err != nil {
+			pc.failed = true
+		}
+		c.advance()
+	}()
+
+	for _, f := range files {
+		if f.mode == modeSymlink || f.mode == modeSubmodule || !c.match(f.path) {
+			continue
+		}
+		blob, err := c.git(ctx, "cat-file", "blob", f.object)
+		if err != nil {
+			return err
+		}
+		doc := &processor.Document{
+			Blob:   blob,
+			Type:   processor.DocumentUnknown,
+			Format: processor.FormatUnknown,
+			SourceInformation: processor.SourceInformation{
+				Collector: CollectorType,
+				Source:    c.url + "@" + commit + ":" + f.path,
+			},
+		}
+		// the document is pending before being sent, as it may be
+		// acknowledged before the send returns
+		c.mu.Lock()
+		pc.pending++
+		c.docs[doc] = pc
+		c.mu.Unlock()
+		select {
+		case docChannel <- doc:
+		case <-ctx.Done():
+			c.mu.Lock()
+			pc.pending--
+			delete(c.docs, doc)
+			c.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Ack records the commit of the document in the checkpoint once all the
+// documents of the commit and of the commits before it are handled
+// without error
+func (c *GitCollector) Ack(d *processor.Document, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pc, ok := c.docs[d]
+	if !ok {
+		return
+	}
+	delete(c.docs, d)
+	pc.pending--
+	if err != nil {
+		pc.failed = true
+	}
+	c.advance()
+}
+
+// advance records the sent and acknowledged commits in the checkpoint, in
+// order. A failed commit blocks the checkpoint, so that it and the commits
+// after it are collected again on restart.
+func (c *GitCollector) advance() {
+	for !c.blocked && len(c.commits) > 0 {
+		pc := c.commits[0]
+		if !pc.sent || pc.pending > 0 {
+			return
+		}
+		if pc.failed {
+			logrus.Warnf("commit %s of %s not recorded in the checkpoint, it will be collected again on restart", pc.commit, c.url)
+			c.blocked = true
+			break
+		}
+		if err := c.cp.record(pc.commit); err != nil {
+			logrus.Errorf("unable to save the checkpoint of %s: %v", c.url, err)
+		}
+		c.commits = c.commits[1:]
+	}
+	if c.blocked {
+		c.commits = nil
+	}
+}
+
+// match returns whether the path matches the path patterns
+func (c *GitCollector) match(p string) bool {
+	if len(c.opts.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range c.opts.Paths {
+		name := p
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(p)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tree lists the files of commit
+func (c *GitCollector) tree(ctx context.Context, commit string) ([]file, error) {
+	out, err := c.git(ctx, "ls-tree", "-r", "-z", "--full-tree", commit)
+	if err != nil {
+		return nil, err
+	}
+	files := []file{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, p, ok := strings.Cut(entry, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		files = append(files, file{mode: fields[0], object: fields[2], path: p})
+	}
+	return files, nil
+}
+
+// commitsSince lists the first parent commits from since, excluded, to
+// commit, oldest first, with their first parent
+func (c *GitCollector) commitsSince(ctx context.Context, since, commit string) ([][2]string, error) {
+	out, err := c.git(ctx, "rev-list", "--first-parent", "--reverse", "--parents", since+".."+commit, "--")
+	if err != nil {
+		return nil, err
+	}
+	commits := [][2]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+		case 1:
+			commits = append(commits, [2]string{fields[0], ""})
+		default:
+			commits = append(commits, [2]string{fields[0], fields[1]})
+		}
+	}
+	return commits, nil
+}
+
+// changedFiles lists the files added or modified by commit relative to
+// its parent, all the files of a root commit
+func (c *GitCollector) changedFiles(ctx context.Context, commit, parent string) ([]file, error) {
+	args := []string{"diff-tree", "-r", "-z", "--no-commit-id", "--diff-filter=AMT"}
+	if parent == "" {
+		args = append(args, "--root", commit)
+	} else {
+		args = append(args, parent, commit)
+	}
+	out, err := c.git(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	// :<src mode> SP <dst mode> SP <src object> SP <dst object> SP <status> NUL <path> NUL
+	files := []file{}
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(entries); i += 2 {
+		fields := strings.Fields(strings.TrimPrefix(entries[i], ":"))
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected diff-tree output %q", entries[i])
+		}
+		files = append(files, file{mode: fields[1], object: fields[3], path: entries[i+1]})
+	}
+	return files, nil
+}
+
+// git runs a git command in the repository and returns its output
+func (c *GitCollector) git(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, c.opts.Git, append([]string{"-C", c.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,272 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// testRepo is a git repository in a temporary directory
+type testRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	r := &testRepo{t: t, dir: t.TempDir()}
+	r.git("init", "-q", "-b", "main")
+	return r
+}
+
+func (r *testRepo) git(args ...string) string {
+	out, err := exec.Command("git", append([]string{"-C", r.dir}, args...)...).CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the files, removing those with empty content, and commits
+// them
+func (r *testRepo) commit(files map[string]string) string {
+	for name, content := range files {
+		p := filepath.Join(r.dir, filepath.FromSlash(name))
+		if content == "" {
+			r.git("rm", "-q", name)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			r.t.Fatal(err)
+		}
+		r.git("add", name)
+	}
+	r.git("commit", "-q", "-m", "commit")
+	return r.git("rev-parse", "HEAD")
+}
+
+func collect(t *testing.T, c *GitCollector) ([]string, error) {
+	return collectAck(t, c, nil)
+}
+
+// collectAck collects the documents and acknowledges them with err
+func collectAck(t *testing.T, c *GitCollector, ackErr error) ([]string, error) {
+	ch := make(chan *processor.Document, 64)
+	err := c.RetrieveArtifacts(context.Background(), ch)
+	close(ch)
+	docs := []string{}
+	for d := range ch {
+		if d.SourceInformation.Collector != CollectorType {
+			t.Errorf("unexpected collector %q", d.SourceInformation.Collector)
+		}
+		docs = append(docs, d.SourceInformation.Source+"="+string(d.Blob))
+		c.Ack(d, ackErr)
+	}
+	sort.Strings(docs)
+	return docs, err
+}
+
+func Test_GitCollector(t *testing.T) {
+	r := newTestRepo(t)
+	first := r.commit(map[string]string{
+		"README.md":                 "readme",
+		"sbom.spdx.json":            "sbom",
+		"attestations/build.intoto": "build",
+		"attestations/old/a.intoto": "old",
+	})
+	r.git("tag", "v1")
+	second := r.commit(map[string]string{
+		"sbom.spdx.json":            "sbom v2",
+		"attestations/old/a.intoto": "",
+	})
+	url := "file://" + filepath.ToSlash(r.dir)
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected []string
+		wantErr  bool
+	}{{
+		name: "all files at HEAD",
+		opts: Options{Repository: r.dir},
+		expected: []string{
+			url + "@" + second + ":README.md=readme",
+			url + "@" + second + ":attestations/build.intoto=build",
+			url + "@" + second + ":sbom.spdx.json=sbom v2",
+		},
+	}, {
+		name: "path patterns at tag",
+		opts: Options{Repository: url, Ref: "v1", Paths: []string{"*.spdx.json", "attestations/*/*"}},
+		expected: []string{
+			url + "@" + first + ":attestations/old/a.intoto=old",
+			url + "@" + first + ":sbom.spdx.json=sbom",
+		},
+	}, {
+		name:     "no matching path",
+		opts:     Options{Repository: r.dir, Paths: []string{"*.cdx.xml"}},
+		expected: []string{},
+	}, {
+		name:     "unknown ref",
+		opts:     Options{Repository: r.dir, Ref: "v2"},
+		expected: []string{},
+		wantErr:  true,
+	}, {
+		name:     "not a repository",
+		opts:     Options{Repository: t.TempDir()},
+		expected: []string{},
+		wantErr:  true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewGitCollector(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs, err := collect(t, c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(docs, tt.expected) {
+				t.Errorf("got documents %v, expected %v", docs, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_GitCollectorCheckpoint(t *testing.T) {
+	r := newTestRepo(t)
+	first := r.commit(map[string]string{"a.json": "a", "b.json": "b"})
+	url := "file://" + filepath.ToSlash(r.dir)
+	cpFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	opts := Options{Repository: r.dir, Paths: []string{"*.json"}, Checkpoint: cpFile}
+
+	runAck := func(ackErr error, expected ...string) {
+		t.Helper()
+		c, err := NewGitCollector(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs, err := collectAck(t, c, ackErr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected == nil {
+			expected = []string{}
+		}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(docs, expected) {
+			t.Errorf("got documents %v, expected %v", docs, expected)
+		}
+	}
+	run := func(expected ...string) {
+		t.Helper()
+		runAck(nil, expected...)
+	}
+
+	// failed documents are collected again
+	runAck(errors.New("failed"), url+"@"+first+":a.json=a", url+"@"+first+":b.json=b")
+	run(url+"@"+first+":a.json=a", url+"@"+first+":b.json=b")
+	// nothing new
+	run()
+
+	second := r.commit(map[string]string{"a.json": "a2", "b.json": "", "c.txt": "c"})
+	third := r.commit(map[string]string{"d/e.json": "e"})
+	// changes of each new commit, without the removed file, until
+	// acknowledged
+	runAck(errors.New("failed"), url+"@"+second+":a.json=a2", url+"@"+third+":d/e.json=e")
+	run(url+"@"+second+":a.json=a2", url+"@"+third+":d/e.json=e")
+
+	// a merged branch is collected through the merge commit
+	r.git("checkout", "-q", "-b", "feature")
+	r.commit(map[string]string{"f.json": "f"})
+	r.git("checkout", "-q", "main")
+	r.git("merge", "-q", "--no-ff", "-m", "merge", "feature")
+	merge := r.git("rev-parse", "HEAD")
+	run(url + "@" + merge + ":f.json=f")
+
+	// an unknown checkpoint commit collects all files again
+	if err := os.WriteFile(cpFile, []byte(`{"commit": "0000000000000000000000000000000000000000"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	run(url+"@"+merge+":a.json=a2", url+"@"+merge+":d/e.json=e", url+"@"+merge+":f.json=f")
+}
+
+func Test_GitCollectorPoll(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit(map[string]string{"a.json": "a"})
+	c, err := NewGitCollector(Options{Repository: r.dir, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *processor.Document)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.RetrieveArtifacts(ctx, ch)
+	}()
+
+	d := <-ch
+	if string(d.Blob) != "a" {
+		t.Fatalf("unexpected document %q", d.Blob)
+	}
+	c.Ack(d, nil)
+	r.commit(map[string]string{"b.json": "b"})
+	if d = <-ch; string(d.Blob) != "b" {
+		t.Fatalf("unexpected document %q", d.Blob)
+	}
+	c.Ack(d, nil)
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("unexpected error on shutdown: %v", err)
+	}
+}
+
+func Test_NewGitCollector(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"no repository": {},
+		"remote":        {Repository: "https://github.com/guacsec/guac"},
+		"remote file":   {Repository: "file://host/repo"},
+		"invalid glob":  {Repository: ".", Paths: []string{"[a-"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewGitCollector(opts); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
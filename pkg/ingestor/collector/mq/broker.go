@@ -0,0 +1,60 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is returned when using a closed subscription
+var ErrClosed = errors.New("subscription closed")
+
+// Message is a message of a subject. Subjects are split in partitions,
+// and the offset of a message is its position in its partition.
+type Message struct {
+	Subject   string
+	Partition int
+	Offset    int64
+	Data      []byte
+	Headers   map[string]string
+	// Deliveries is the number of times the message was delivered,
+	// including this one
+	Deliveries int
+}
+
+// Broker is a message broker with at-least-once delivery, such as NATS
+// JetStream or Kafka
+type Broker interface {
+	// Publish publishes a message to the subject
+	Publish(ctx context.Context, subject string, data []byte, headers map[string]string) error
+	// Subscribe subscribes to the subject as a member of the consumer
+	// group. Each message of the subject is delivered to one member of
+	// each group.
+	Subscribe(ctx context.Context, subject, group string) (Subscription, error)
+}
+
+// Subscription delivers the messages of a subject. Messages not
+// acknowledged are delivered again.
+type Subscription interface {
+	// Next returns the next message, waiting for one until ctx is done
+	Next(ctx context.Context) (*Message, error)
+	// Ack acknowledges that the message was handled
+	Ack(m *Message) error
+	// Close closes the subscription, its messages not acknowledged are
+	// delivered again
+	Close() error
+}
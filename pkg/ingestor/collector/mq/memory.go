@@ -0,0 +1,309 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// DefaultAckWait is the delay before redelivering a message not
+// acknowledged, used when the ack wait of NewMemoryBroker is 0
+const DefaultAckWait = 30 * time.Second
+
+// HeaderKey is the header of the key messages are partitioned by. Messages
+// without a key are spread across the partitions.
+const HeaderKey = "key"
+
+// MemoryBroker is an in-process broker keeping the messages of its
+// subjects in memory. Consumer groups start from the first message of
+// each partition.
+type MemoryBroker struct {
+	partitions int
+	ackWait    time.Duration
+
+	mu     sync.Mutex
+	topics map[string]*topic
+	// notify is closed and replaced when messages become available
+	notify chan struct{}
+}
+
+type topic struct {
+	logs   [][]*Message
+	next   int
+	groups map[string]*group
+}
+
+// group is the delivery state of a consumer group
+type group struct {
+	// offsets are the offsets of the next messages to deliver
+	offsets []int64
+	// pending are the deadlines of the messages delivered and not
+	// acknowledged, by partition and offset
+	pending map[[2]int64]time.Time
+	// deliveries counts the deliveries of the pending messages
+	deliveries map[[2]int64]int
+}
+
+// NewMemoryBroker creates an in-process broker with the number of
+// partitions per subject, at least 1, redelivering messages not
+// acknowledged after ackWait, DefaultAckWait if 0
+func NewMemoryBroker(partitions int, ackWait time.Duration) *MemoryBroker {
+	if partitions < 1 {
+		partitions = 1
+	}
+	if ackWait <= 0 {
+		ackWait = DefaultAckWait
+	}
+	return &MemoryBroker{
+		partitions: partitions,
+		ackWait:    ackWait,
+		topics:     map[string]*topic{},
+		notify:     make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, subject string, data []byte, headers map[string]string) error {
+	if subject == "" {
+		return fmt.Errorf("subject shouldn't be empty")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(subject)
+	p := t.next
+	if key, ok := headers[HeaderKey]; ok {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		p = int(h.Sum32() % uint32(b.partitions))
+	} else {
+		t.next = (t.next + 1) % b.partitions
+	}
+	m := &Message{
+		Subject:   subject,
+		Partition: p,
+		Offset:    int64(len(t.logs[p])),
+		Data:      append([]byte{}, data...),
+		Headers:   map[string]string{},
+	}
+	for k, v := range headers {
+		m.Headers[k] = v
+	}
+	t.logs[p] = append(t.logs[p], m)
+	b.wake()
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, subject, group string) (Subscription, error) {
+	if subject == "" || group == "" {
+		return nil, fmt.Errorf("subject and group shouldn't be empty")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.group(b.topic(subject), group)
+	return &memorySubscription{broker: b, subject: subject, group: group}, nil
+}
+
+// Messages returns the messages published to the subject, by partition
+// then offset
+func (b *MemoryBroker) Messages(subject string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msgs := []*Message{}
+	if t, ok := b.topics[subject]; ok {
+		for _, log := range t.logs {
+			msgs = append(msgs, log...)
+		}
+	}
+	return msgs
+}
+
+func (b *MemoryBroker) topic(subject string) *topic {
+	t, ok := b.topics[subject]
+	if !ok {
+		t = &topic{logs: make([][]*Message, b.partitions), groups: map[string]*group{}}
+		b.topics[subject] = t
+	}
+	return t
+}
+
+func (b *MemoryBroker) group(t *topic, name string) *group {
+	g, ok := t.groups[name]
+	if !ok {
+		g = &group{
+			offsets:    make([]int64, b.partitions),
+			pending:    map[[2]int64]time.Time{},
+			deliveries: map[[2]int64]int{},
+		}
+		t.groups[name] = g
+	}
+	return g
+}
+
+// wake wakes up the subscriptions waiting for messages
+func (b *MemoryBroker) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// deliver returns the next message of the group, a message to redeliver
+// first, or nil and the time of the next redelivery if there is none
+func (b *MemoryBroker) deliver(subject, name string, now time.Time) (*Message, time.Time) {
+	t := b.topics[subject]
+	g := b.group(t, name)
+
+	var next time.Time
+	var redeliver *[2]int64
+	for id, deadline := range g.pending {
+		id := id
+		if !deadline.After(now) {
+			if redeliver == nil || id[0] < redeliver[0] || id[0] == redeliver[0] && id[1] < redeliver[1] {
+				redeliver = &id
+			}
+		} else if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	id := [2]int64{-1, -1}
+	if redeliver != nil {
+		id = *redeliver
+	} else {
+		for p, offset := range g.offsets {
+			if offset < int64(len(t.logs[p])) {
+				id = [2]int64{int64(p), offset}
+				g.offsets[p]++
+				break
+			}
+		}
+	}
+	if id[0] < 0 {
+		return nil, next
+	}
+	g.pending[id] = now.Add(b.ackWait)
+	g.deliveries[id]++
+	m := *t.logs[id[0]][id[1]]
+	m.Deliveries = g.deliveries[id]
+	return &m, time.Time{}
+}
+
+type memorySubscription struct {
+	broker  *MemoryBroker
+	subject string
+	group   string
+
+	mu     sync.Mutex
+	closed bool
+	// delivered are the delivery counts of the messages delivered to this
+	// subscription and not acknowledged
+	delivered map[[2]int64]int
+}
+
+func (s *memorySubscription) Next(ctx context.Context) (*Message, error) {
+	for {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+
+		s.broker.mu.Lock()
+		m, next := s.broker.deliver(s.subject, s.group, time.Now())
+		notify := s.broker.notify
+		s.broker.mu.Unlock()
+		if m != nil {
+			s.mu.Lock()
+			if s.delivered == nil {
+				s.delivered = map[[2]int64]int{}
+			}
+			s.delivered[[2]int64{int64(m.Partition), m.Offset}] = m.Deliveries
+			s.mu.Unlock()
+			return m, nil
+		}
+
+		var redelivery <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			redelivery = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-notify:
+		case <-redelivery:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Ack acknowledges the latest delivery of a message, acknowledging a
+// delivery made before it was delivered again fails
+func (s *memorySubscription) Ack(m *Message) error {
+	id := [2]int64{int64(m.Partition), m.Offset}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if deliveries, ok := s.delivered[id]; !ok || deliveries != m.Deliveries {
+		return fmt.Errorf("message %d/%d wasn't delivered to the subscription", m.Partition, m.Offset)
+	}
+	delete(s.delivered, id)
+
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	g := s.broker.topics[s.subject].groups[s.group]
+	if _, ok := g.pending[id]; !ok || g.deliveries[id] != m.Deliveries {
+		return fmt.Errorf("message %d/%d was delivered again", m.Partition, m.Offset)
+	}
+	delete(g.pending, id)
+	delete(g.deliveries, id)
+	return nil
+}
+
+// Close makes the messages delivered to the subscription and not
+// acknowledged available for redelivery right away
+func (s *memorySubscription) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	g := s.broker.topics[s.subject].groups[s.group]
+	now := time.Now()
+	for id := range s.delivered {
+		if _, ok := g.pending[id]; ok {
+			g.pending[id] = now
+		}
+	}
+	s.broker.wake()
+	return nil
+}
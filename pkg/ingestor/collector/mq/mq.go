@@ -0,0 +1,252 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mq implements a collector of the documents published to a
+// message broker subject
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorType is the type of the message queue collector
+const CollectorType = "mq"
+
+// DefaultGroup is the consumer group used when Options.Group is not set
+const DefaultGroup = "guac"
+
+// DeadLetterSuffix is appended to the subject to name the dead-letter
+// subject when Options.DeadLetterSubject is not set
+const DeadLetterSuffix = ".dead-letter"
+
+// DefaultShutdownTimeout is how long the collector waits for its documents
+// to be acknowledged on shutdown when Options.ShutdownTimeout is not set
+const DefaultShutdownTimeout = 5 * time.Second
+
+// Message headers
+const (
+	// HeaderDocumentType sets the processor.DocumentType of the document
+	HeaderDocumentType = "guac-document-type"
+	// HeaderDocumentFormat sets the processor.FormatType of the document
+	HeaderDocumentFormat = "guac-document-format"
+	// HeaderError is the error of a dead-lettered message
+	HeaderError = "guac-error"
+	// HeaderSource is the source of a dead-lettered message
+	HeaderSource = "guac-source"
+)
+
+// Options configures an MQCollector
+type Options struct {
+	// Broker is the broker to consume from
+	Broker Broker
+	// Subject is the subject the documents are published to
+	Subject string
+	// Group is the consumer group, DefaultGroup if empty
+	Group string
+	// DeadLetterSubject is the subject the messages of the documents
+	// failing to be handled are published to, the subject with
+	// DeadLetterSuffix if empty
+	DeadLetterSubject string
+	// ShutdownTimeout is how long the collector waits for the documents
+	// it sent to be acknowledged when ctx is done, before closing its
+	// subscription. DefaultShutdownTimeout if 0.
+	ShutdownTimeout time.Duration
+}
+
+// MQCollector collects the messages of a subject as documents, with
+// subject/partition/offset as SourceInformation.Source.
+//
+// Messages are acknowledged once their document is handled. The messages
+// of documents failing to be handled are published to the dead-letter
+// subject, with the error in HeaderError, then acknowledged.
+type MQCollector struct {
+	opts Options
+
+	mu      sync.Mutex
+	pending map[*processor.Document]pendingMessage
+	// acked is signaled when a document is acknowledged
+	acked chan struct{}
+}
+
+type pendingMessage struct {
+	msg *Message
+	sub Subscription
+}
+
+// NewMQCollector creates a message queue collector
+func NewMQCollector(opts Options) (*MQCollector, error) {
+	if opts.Broker == nil {
+		return nil, fmt.Errorf("mq collector broker shouldn't be empty")
+	}
+	if opts.Subject == "" {
+		return nil, fmt.Errorf("mq collector subject shouldn't be empty")
+	}
+	if opts.Group == "" {
+		opts.Group = DefaultGroup
+	}
+	if opts.DeadLetterSubject == "" {
+		opts.DeadLetterSubject = opts.Subject + DeadLetterSuffix
+	}
+	if opts.DeadLetterSubject == opts.Subject {
+		return nil, fmt.Errorf("mq collector dead-letter subject shouldn't be the subject")
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	return &MQCollector{
+		opts:    opts,
+		pending: map[*processor.Document]pendingMessage{},
+		acked:   make(chan struct{}, 1),
+	}, nil
+}
+
+func (c *MQCollector) Type() string {
+	return CollectorType
+}
+
+// RetrieveArtifacts sends the messages of the subject to docChannel until
+// ctx is done. The messages not acknowledged within the shutdown timeout
+// are delivered again by the broker.
+func (c *MQCollector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	sub, err := c.opts.Broker.Subscribe(ctx, c.opts.Subject, c.opts.Group)
+	if err != nil {
+		return err
+	}
+	defer c.release(sub)
+
+	for {
+		m, err := sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		doc := &processor.Document{
+			Blob:   m.Data,
+			Type:   processor.DocumentUnknown,
+			Format: processor.FormatUnknown,
+			SourceInformation: processor.SourceInformation{
+				Collector: CollectorType,
+				Source:    source(m),
+			},
+		}
+		if t := m.Headers[HeaderDocumentType]; t != "" {
+			doc.Type = processor.DocumentType(t)
+		}
+		if f := m.Headers[HeaderDocumentFormat]; f != "" {
+			doc.Format = processor.FormatType(f)
+		}
+
+		c.mu.Lock()
+		c.pending[doc] = pendingMessage{msg: m, sub: sub}
+		c.mu.Unlock()
+		select {
+		case docChannel <- doc:
+		case <-ctx.Done():
+			c.mu.Lock()
+			delete(c.pending, doc)
+			c.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// release closes the subscription once the documents sent from it are
+// acknowledged, or after the shutdown timeout
+func (c *MQCollector) release(sub Subscription) {
+	timer := time.NewTimer(c.opts.ShutdownTimeout)
+	defer timer.Stop()
+	for c.inFlight(sub) {
+		select {
+		case <-c.acked:
+		case <-timer.C:
+			c.mu.Lock()
+			for d, p := range c.pending {
+				if p.sub == sub {
+					delete(c.pending, d)
+				}
+			}
+			c.mu.Unlock()
+			logrus.Warnf("mq collector stopped with unacknowledged messages of %s", c.opts.Subject)
+			_ = sub.Close()
+			return
+		}
+	}
+	_ = sub.Close()
+}
+
+// inFlight returns whether documents sent from the subscription are not
+// acknowledged
+func (c *MQCollector) inFlight(sub Subscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.pending {
+		if p.sub == sub {
+			return true
+		}
+	}
+	return false
+}
+
+// Ack acknowledges the message of the document if it was handled, or
+// else dead-letters it. Messages of documents whose handling was
+// cancelled are left to be delivered again.
+func (c *MQCollector) Ack(d *processor.Document, err error) {
+	c.mu.Lock()
+	p, ok := c.pending[d]
+	delete(c.pending, d)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	defer func() {
+		select {
+		case c.acked <- struct{}{}:
+		default:
+		}
+	}()
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	if err != nil {
+		headers := map[string]string{}
+		for k, v := range p.msg.Headers {
+			headers[k] = v
+		}
+		headers[HeaderError] = err.Error()
+		headers[HeaderSource] = source(p.msg)
+		if pubErr := c.opts.Broker.Publish(context.Background(), c.opts.DeadLetterSubject, p.msg.Data, headers); pubErr != nil {
+			logrus.Errorf("unable to dead-letter message %s: %v", source(p.msg), pubErr)
+			return
+		}
+	}
+	if ackErr := p.sub.Ack(p.msg); ackErr != nil {
+		logrus.Errorf("unable to acknowledge message %s: %v", source(p.msg), ackErr)
+	}
+}
+
+// source returns the subject, partition and offset of the message
+func source(m *Message) string {
+	return fmt.Sprintf("%s/%d/%d", m.Subject, m.Partition, m.Offset)
+}
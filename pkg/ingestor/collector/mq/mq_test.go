@@ -0,0 +1,260 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/internal/testing/ingestor/simpledoc"
+	"github.com/guacsec/guac/pkg/ingestor/collector"
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/process"
+)
+
+const testSubject = "documents"
+
+var simpleDocHeaders = map[string]string{
+	HeaderDocumentType:   string(simpledoc.SimpleDocType),
+	HeaderDocumentFormat: string(processor.FormatJSON),
+}
+
+func publish(t *testing.T, b Broker, subject, data string, headers map[string]string) {
+	if err := b.Publish(context.Background(), subject, []byte(data), headers); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectNone checks that the group has no message to deliver
+func expectNone(t *testing.T, b Broker, subject, group string) {
+	t.Helper()
+	sub, err := b.Subscribe(context.Background(), subject, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if m, err := sub.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected no message, got %v, %v", m, err)
+	}
+}
+
+func Test_MQCollector(t *testing.T) {
+	b := NewMemoryBroker(2, 50*time.Millisecond)
+	publish(t, b, testSubject, `{"issuer": "google.com"}`, simpleDocHeaders)
+	publish(t, b, testSubject, `{"info": "no issuer"}`, simpleDocHeaders)
+	publish(t, b, testSubject, `{"issuer": "google.com", "nested": [{"issuer": "google.com"}]}`, simpleDocHeaders)
+
+	c, err := NewMQCollector(Options{Broker: b, Subject: testSubject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := collector.NewRegistry()
+	if err := r.Register(c, CollectorType); err != nil {
+		t.Fatal(err)
+	}
+	pr := process.NewRegistry()
+	_ = pr.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sources := []string{}
+	err = r.Collect(ctx, func(d *processor.Document) error {
+		sources = append(sources, d.SourceInformation.Source)
+		_, err := process.ProcessContext(ctx, d, process.Options{Registry: pr, Strict: true})
+		if len(sources) == 3 {
+			// the last document is acknowledged before the collector
+			// stops
+			defer cancel()
+		}
+		return err
+	}, func(err error) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msgs := b.Messages(testSubject)
+	expected := []string{}
+	for _, m := range msgs {
+		expected = append(expected, fmt.Sprintf("%s/%d/%d", testSubject, m.Partition, m.Offset))
+	}
+	sort.Strings(sources)
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("got sources %v, expected %v", sources, expected)
+	}
+
+	dead := b.Messages(testSubject + DeadLetterSuffix)
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, expected 1", len(dead))
+	}
+	if string(dead[0].Data) != `{"info": "no issuer"}` || dead[0].Headers[HeaderError] == "" ||
+		dead[0].Headers[HeaderDocumentType] != string(simpledoc.SimpleDocType) {
+		t.Errorf("unexpected dead letter %+v", dead[0])
+	}
+	for _, m := range msgs {
+		if string(m.Data) == string(dead[0].Data) && dead[0].Headers[HeaderSource] != fmt.Sprintf("%s/%d/%d", testSubject, m.Partition, m.Offset) {
+			t.Errorf("unexpected dead letter source %q", dead[0].Headers[HeaderSource])
+		}
+	}
+	// all messages were acknowledged, none is delivered again
+	expectNone(t, b, testSubject, DefaultGroup)
+}
+
+func Test_MQCollectorRedelivery(t *testing.T) {
+	b := NewMemoryBroker(1, time.Hour)
+	publish(t, b, testSubject, "a", nil)
+	publish(t, b, testSubject, "b", nil)
+	c, err := NewMQCollector(Options{Broker: b, Subject: testSubject, ShutdownTimeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// receive returns the next document, and a function stopping the
+	// collector
+	receive := func() (*processor.Document, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := make(chan *processor.Document)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- c.RetrieveArtifacts(ctx, ch)
+		}()
+		return <-ch, func() {
+			cancel()
+			if err := <-errCh; err != nil {
+				t.Fatalf("unexpected error on shutdown: %v", err)
+			}
+		}
+	}
+
+	// not acknowledged before shutdown
+	d, stop := receive()
+	stop()
+	if string(d.Blob) != "a" || d.SourceInformation.Source != testSubject+"/0/0" {
+		t.Fatalf("unexpected document %q from %s", d.Blob, d.SourceInformation.Source)
+	}
+	// handling cancelled by shutdown
+	d, stop = receive()
+	if string(d.Blob) != "a" {
+		t.Fatalf("expected redelivery, got %q", d.Blob)
+	}
+	c.Ack(d, fmt.Errorf("unable to process: %w", context.Canceled))
+	stop()
+	if len(b.Messages(testSubject+DeadLetterSuffix)) != 0 {
+		t.Errorf("cancelled document should not be dead-lettered")
+	}
+	// acknowledged
+	d, stop = receive()
+	if string(d.Blob) != "a" {
+		t.Fatalf("expected redelivery, got %q", d.Blob)
+	}
+	c.Ack(d, nil)
+	stop()
+	d, stop = receive()
+	stop()
+	if string(d.Blob) != "b" {
+		t.Fatalf("expected next message, got %q", d.Blob)
+	}
+}
+
+func Test_MemoryBroker(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBroker(4, 50*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		publish(t, b, testSubject, fmt.Sprint(i), map[string]string{HeaderKey: "image"})
+	}
+	msgs := b.Messages(testSubject)
+	for i, m := range msgs {
+		// messages with the same key are in the same partition, in order
+		if m.Partition != msgs[0].Partition || m.Offset != int64(i) || string(m.Data) != fmt.Sprint(i) {
+			t.Errorf("unexpected message %+v", m)
+		}
+	}
+
+	// each group gets all the messages, split across its members
+	subA, _ := b.Subscribe(ctx, testSubject, "a")
+	subB1, _ := b.Subscribe(ctx, testSubject, "b")
+	subB2, _ := b.Subscribe(ctx, testSubject, "b")
+	for i := 0; i < 4; i++ {
+		m, err := subA.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := subA.Ack(m); err != nil {
+			t.Fatal(err)
+		}
+		sub := subB1
+		if i%2 == 1 {
+			sub = subB2
+		}
+		m, err = sub.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m.Data) != fmt.Sprint(i) {
+			t.Errorf("got message %q, expected %d", m.Data, i)
+		}
+		if i > 0 {
+			if err := sub.Ack(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectNone(t, b, testSubject, "a")
+
+	// the first message of b is delivered again after the ack wait
+	m, err := subB2.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != "0" || m.Deliveries != 2 {
+		t.Errorf("unexpected redelivery %+v", m)
+	}
+	if err := subB1.Ack(m); err == nil {
+		t.Errorf("expected error acknowledging a message delivered to another subscription")
+	}
+	if err := subB2.Ack(m); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, b, testSubject, "b")
+
+	_ = subB1.Close()
+	if _, err := subB1.Next(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func Test_NewMQCollector(t *testing.T) {
+	b := NewMemoryBroker(1, 0)
+	for name, opts := range map[string]Options{
+		"no broker":              {Subject: testSubject},
+		"no subject":             {Broker: b},
+		"dead-letter to subject": {Broker: b, Subject: testSubject, DeadLetterSubject: testSubject},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewMQCollector(opts); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,791 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultNATSTimeout is the timeout of the requests to the NATS server,
+// used when NATSOptions.Timeout is not set
+const DefaultNATSTimeout = 5 * time.Second
+
+// natsPullWait is how long a pull request waits for a message
+const natsPullWait = 5 * time.Second
+
+// natsMaxPayload is the size limit of the messages read from the server
+const natsMaxPayload = 64 << 20
+
+// errNoResponders is the status of a request nothing subscribes to
+var errNoResponders = errors.New("no responders")
+
+// NATSOptions configures a NATSBroker
+type NATSOptions struct {
+	// URL is the URL of the server, of the form nats://host[:port] or
+	// tls://host[:port]. Connections to tls:// URLs, and to servers
+	// requiring it, use TLS. A user and password, or a token as user, may
+	// be given as user info.
+	URL string
+	// Token is the authentication token of the server, overriding the
+	// token of the URL. Credentials are only sent over TLS.
+	Token string
+	// CAFile is the PEM encoded CAs the server certificate is verified
+	// against, the system roots if empty
+	CAFile string
+	// Timeout is the timeout of the requests to the server,
+	// DefaultNATSTimeout if 0
+	Timeout time.Duration
+}
+
+// NATSBroker is a NATS JetStream broker. Messages are published to the
+// stream capturing their subject, and each consumer group is a durable
+// pull consumer of that stream. Streams are not partitioned: messages are
+// in partition 0, with their stream sequence as offset.
+//
+// The broker connects on first use and reconnects once the connection is
+// lost.
+type NATSBroker struct {
+	opts    NATSOptions
+	addr    string
+	connect []byte
+	tls     *tls.Config
+	// secure requires TLS even if the server doesn't
+	secure bool
+	// credentials are set in connect
+	credentials bool
+
+	mu   sync.Mutex
+	conn *natsConn
+}
+
+// NewNATSBroker creates a NATS JetStream broker
+func NewNATSBroker(opts NATSOptions) (*NATSBroker, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("nats url shouldn't be empty")
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats url %q: %w", opts.URL, err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" || u.Hostname() == "" {
+		return nil, fmt.Errorf("nats url %q should be of the form nats://host[:port] or tls://host[:port]", u.Redacted())
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultNATSTimeout
+	}
+
+	connect := struct {
+		Verbose      bool   `json:"verbose"`
+		Pedantic     bool   `json:"pedantic"`
+		Headers      bool   `json:"headers"`
+		NoResponders bool   `json:"no_responders"`
+		Name         string `json:"name"`
+		Lang         string `json:"lang"`
+		Protocol     int    `json:"protocol"`
+		User         string `json:"user,omitempty"`
+		Pass         string `json:"pass,omitempty"`
+		Token        string `json:"auth_token,omitempty"`
+	}{Headers: true, NoResponders: true, Name: "guac", Lang: "go", Protocol: 1}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			connect.User, connect.Pass = u.User.Username(), pass
+		} else {
+			connect.Token = u.User.Username()
+		}
+	}
+	if opts.Token != "" {
+		connect.Token = opts.Token
+	}
+	b, err := json.Marshal(connect)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in nats CA file %s", opts.CAFile)
+		}
+	}
+	return &NATSBroker{
+		opts:        opts,
+		addr:        addr,
+		connect:     b,
+		tls:         tlsConfig,
+		secure:      u.Scheme == "tls",
+		credentials: connect.User != "" || connect.Token != "",
+	}, nil
+}
+
+func (b *NATSBroker) Publish(ctx context.Context, subject string, data []byte, headers map[string]string) error {
+	if !validSubject(subject) {
+		return fmt.Errorf("invalid subject %q", subject)
+	}
+	m, err := b.request(ctx, b.opts.Timeout, subject, data, headers)
+	if errors.Is(err, errNoResponders) {
+		return fmt.Errorf("unable to publish to %s: no stream captures the subject", subject)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to publish to %s: %w", subject, err)
+	}
+	if err := apiError(m.data); err != nil {
+		return fmt.Errorf("unable to publish to %s: %w", subject, err)
+	}
+	return nil
+}
+
+func (b *NATSBroker) Subscribe(ctx context.Context, subject, group string) (Subscription, error) {
+	if !validSubject(subject) || group == "" || strings.ContainsAny(group, ".*> \t\r\n") {
+		return nil, fmt.Errorf("invalid subject %q or group %q", subject, group)
+	}
+	var names struct {
+		Streams []string `json:"streams"`
+	}
+	err := b.api(ctx, "$JS.API.STREAM.NAMES", struct {
+		Subject string `json:"subject"`
+	}{subject}, &names)
+	if err != nil {
+		return nil, err
+	}
+	if len(names.Streams) == 0 {
+		return nil, fmt.Errorf("no stream captures subject %s", subject)
+	}
+	stream := names.Streams[0]
+
+	type consumerConfig struct {
+		DurableName   string `json:"durable_name"`
+		DeliverPolicy string `json:"deliver_policy"`
+		AckPolicy     string `json:"ack_policy"`
+		FilterSubject string `json:"filter_subject"`
+	}
+	err = b.api(ctx, "$JS.API.CONSUMER.DURABLE.CREATE."+stream+"."+group, struct {
+		Stream string         `json:"stream_name"`
+		Config consumerConfig `json:"config"`
+	}{stream, consumerConfig{
+		DurableName:   group,
+		DeliverPolicy: "all",
+		AckPolicy:     "explicit",
+		FilterSubject: subject,
+	}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &natsSubscription{
+		broker:    b,
+		subject:   subject,
+		next:      "$JS.API.CONSUMER.MSG.NEXT." + stream + "." + group,
+		delivered: map[int64]natsDelivery{},
+	}, nil
+}
+
+// Close closes the connection to the server
+func (b *NATSBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.close(ErrClosed)
+		b.conn = nil
+	}
+	return nil
+}
+
+// connection returns the connection to the server, connecting if there is
+// none
+func (b *NATSBroker) connection(ctx context.Context) (*natsConn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil && b.conn.closed() == nil {
+		return b.conn, nil
+	}
+	c, err := b.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to nats server %s: %w", b.addr, err)
+	}
+	b.conn = c
+	return c, nil
+}
+
+// request sends a request to the subject and returns the reply, waiting
+// for it for timeout
+func (b *NATSBroker) request(ctx context.Context, timeout time.Duration, subject string, data []byte, headers map[string]string) (*natsMsg, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c, err := b.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := c.request(ctx, subject, data, headers)
+	if err != nil {
+		return nil, err
+	}
+	if m.status == 503 {
+		return nil, errNoResponders
+	}
+	return m, nil
+}
+
+// api sends a JetStream API request and decodes its response in resp
+func (b *NATSBroker) api(ctx context.Context, subject string, req, resp interface{}) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	m, err := b.request(ctx, b.opts.Timeout, subject, data, nil)
+	if errors.Is(err, errNoResponders) {
+		return fmt.Errorf("%s failed: JetStream is not enabled", subject)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", subject, err)
+	}
+	if err := apiError(m.data); err != nil {
+		return fmt.Errorf("%s failed: %w", subject, err)
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(m.data, resp); err != nil {
+		return fmt.Errorf("%s failed: invalid response: %w", subject, err)
+	}
+	return nil
+}
+
+// apiError returns the error of a JetStream API response
+func apiError(data []byte) error {
+	var resp struct {
+		Error *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s (%d)", resp.Error.Description, resp.Error.Code)
+	}
+	return nil
+}
+
+// validSubject returns whether the subject can be published to
+func validSubject(subject string) bool {
+	return subject != "" && !strings.ContainsAny(subject, "*> \t\r\n")
+}
+
+type natsSubscription struct {
+	broker  *NATSBroker
+	subject string
+	// next is the subject of the pull requests of the consumer
+	next string
+
+	mu     sync.Mutex
+	closed bool
+	// delivered are the deliveries of the messages delivered to this
+	// subscription and not acknowledged, by stream sequence
+	delivered map[int64]natsDelivery
+}
+
+type natsDelivery struct {
+	// reply is the subject the delivery is acknowledged on
+	reply      string
+	deliveries int
+}
+
+func (s *natsSubscription) Next(ctx context.Context) (*Message, error) {
+	req, err := json.Marshal(struct {
+		Batch   int   `json:"batch"`
+		Expires int64 `json:"expires"`
+	}{1, int64(natsPullWait)})
+	if err != nil {
+		return nil, err
+	}
+	for {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+
+		m, err := s.broker.request(ctx, natsPullWait+s.broker.opts.Timeout, s.next, req, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("unable to pull from %s: %w", s.subject, err)
+		}
+		switch m.status {
+		case 0:
+		case 404, 408:
+			// no message before the pull request expired
+			continue
+		default:
+			return nil, fmt.Errorf("unable to pull from %s: %d %s", s.subject, m.status, m.description)
+		}
+
+		deliveries, seq, err := parseAckSubject(m.reply)
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_, _ = s.broker.request(context.Background(), s.broker.opts.Timeout, m.reply, []byte("-NAK"), nil)
+			return nil, ErrClosed
+		}
+		s.delivered[seq] = natsDelivery{reply: m.reply, deliveries: deliveries}
+		s.mu.Unlock()
+		return &Message{
+			Subject:    m.subject,
+			Offset:     seq,
+			Data:       m.data,
+			Headers:    m.headers,
+			Deliveries: deliveries,
+		}, nil
+	}
+}
+
+// Ack acknowledges the latest delivery of a message to the subscription,
+// waiting for the server to confirm it
+func (s *natsSubscription) Ack(m *Message) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	d, ok := s.delivered[m.Offset]
+	if !ok || d.deliveries != m.Deliveries {
+		s.mu.Unlock()
+		return fmt.Errorf("message %d wasn't delivered to the subscription", m.Offset)
+	}
+	delete(s.delivered, m.Offset)
+	s.mu.Unlock()
+
+	if _, err := s.broker.request(context.Background(), s.broker.opts.Timeout, d.reply, []byte("+ACK"), nil); err != nil {
+		return fmt.Errorf("unable to acknowledge message %d: %w", m.Offset, err)
+	}
+	return nil
+}
+
+// Close negatively acknowledges the messages delivered to the
+// subscription and not acknowledged, so that they are delivered again
+// right away
+func (s *natsSubscription) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if len(s.delivered) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.broker.opts.Timeout)
+	defer cancel()
+	c, err := s.broker.connection(ctx)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, d := range s.delivered {
+		writePub(&buf, d.reply, "", []byte("-NAK"), nil)
+	}
+	s.delivered = nil
+	return c.write(buf.Bytes())
+}
+
+// parseAckSubject returns the delivery count and stream sequence of a
+// JetStream message from its reply subject, of the form
+// $JS.ACK[.<domain>.<account>].<stream>.<consumer>.<deliveries>.<stream seq>.<consumer seq>.<timestamp>.<pending>[.<token>]
+func parseAckSubject(reply string) (int, int64, error) {
+	tokens := strings.Split(reply, ".")
+	if len(tokens) < 9 || tokens[0] != "$JS" || tokens[1] != "ACK" {
+		return 0, 0, fmt.Errorf("unexpected JetStream reply subject %q", reply)
+	}
+	i := 4
+	if len(tokens) >= 12 {
+		i = 6
+	}
+	deliveries, err := strconv.Atoi(tokens[i])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected JetStream reply subject %q", reply)
+	}
+	seq, err := strconv.ParseInt(tokens[i+1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected JetStream reply subject %q", reply)
+	}
+	return deliveries, seq, nil
+}
+
+// natsConn is a connection to a NATS server
+type natsConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	inbox   string
+	timeout time.Duration
+
+	wmu sync.Mutex
+
+	mu   sync.Mutex
+	sid  int64
+	subs map[int64]chan *natsMsg
+	err  error
+	done chan struct{}
+}
+
+// natsMsg is a message received from the server
+type natsMsg struct {
+	subject     string
+	reply       string
+	status      int
+	description string
+	headers     map[string]string
+	data        []byte
+}
+
+// dial connects to the server, and reads its messages until the connection
+// is closed
+func (b *NATSBroker) dial(ctx context.Context) (*natsConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", b.addr)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &natsConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		inbox:   "_INBOX." + hex.EncodeToString(token),
+		timeout: b.opts.Timeout,
+		subs:    map[int64]chan *natsMsg{},
+		done:    make(chan struct{}),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := c.handshake(b); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	_ = c.conn.SetDeadline(time.Time{})
+	go c.read()
+	return c, nil
+}
+
+// handshake reads the server INFO, upgrades the connection to TLS if the
+// broker or the server requires it, then connects and waits for the PONG
+// of a PING, which follows the error of a rejected CONNECT
+func (c *natsConn) handshake(b *NATSBroker) error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	op, args, _ := strings.Cut(line, " ")
+	if !strings.EqualFold(op, "INFO") {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	var info struct {
+		Headers      bool `json:"headers"`
+		TLSRequired  bool `json:"tls_required"`
+		TLSAvailable bool `json:"tls_available"`
+	}
+	if err := json.Unmarshal([]byte(args), &info); err != nil {
+		return fmt.Errorf("invalid server info: %w", err)
+	}
+	if !info.Headers {
+		return fmt.Errorf("server doesn't support headers")
+	}
+	switch {
+	case info.TLSRequired || b.secure && info.TLSAvailable:
+		tc := tls.Client(c.conn, b.tls)
+		if err := tc.Handshake(); err != nil {
+			return fmt.Errorf("tls handshake failed: %w", err)
+		}
+		c.conn, c.r = tc, bufio.NewReader(tc)
+	case b.secure:
+		return fmt.Errorf("server doesn't support TLS")
+	case b.credentials:
+		return fmt.Errorf("refusing to send credentials without TLS, use a tls:// url")
+	}
+
+	if err := c.write([]byte("CONNECT " + string(b.connect) + "\r\nPING\r\n")); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "PONG":
+			return nil
+		case "+OK", "INFO":
+		case "-ERR":
+			return fmt.Errorf("server error: %s", args)
+		default:
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+	}
+}
+
+// read reads the messages of the server until the connection is closed
+func (c *natsConn) read() {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			c.close(err)
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch op = strings.ToUpper(op); op {
+		case "PING":
+			if err := c.write([]byte("PONG\r\n")); err != nil {
+				c.close(err)
+				return
+			}
+		case "MSG", "HMSG":
+			sid, m, err := c.readMsg(op == "HMSG", strings.Fields(args))
+			if err != nil {
+				c.close(err)
+				return
+			}
+			c.mu.Lock()
+			ch := c.subs[sid]
+			c.mu.Unlock()
+			if ch != nil {
+				// replies beyond the first are dropped, the
+				// messages of pull requests are delivered again
+				select {
+				case ch <- m:
+				default:
+				}
+			}
+		case "-ERR":
+			logrus.Warnf("nats server error: %s", args)
+		}
+	}
+}
+
+// readMsg reads the payload of a message, of the form
+// MSG <subject> <sid> [reply] <size> or
+// HMSG <subject> <sid> [reply] <header size> <size>
+func (c *natsConn) readMsg(hasHeaders bool, args []string) (int64, *natsMsg, error) {
+	n := 3
+	if hasHeaders {
+		n = 4
+	}
+	if len(args) != n && len(args) != n+1 {
+		return 0, nil, fmt.Errorf("invalid message arguments %q", args)
+	}
+	m := &natsMsg{subject: args[0], headers: map[string]string{}}
+	sid, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid message arguments %q", args)
+	}
+	if len(args) == n+1 {
+		m.reply = args[2]
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil || size < 0 || size > natsMaxPayload {
+		return 0, nil, fmt.Errorf("invalid message size %q", args[len(args)-1])
+	}
+	headerSize := 0
+	if hasHeaders {
+		headerSize, err = strconv.Atoi(args[len(args)-2])
+		if err != nil || headerSize < 0 || headerSize > size {
+			return 0, nil, fmt.Errorf("invalid message header size %q", args[len(args)-2])
+		}
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	if !bytes.HasSuffix(payload, []byte("\r\n")) {
+		return 0, nil, fmt.Errorf("message payload of %s not terminated", m.subject)
+	}
+	if hasHeaders {
+		if err := m.parseHeaders(string(payload[:headerSize])); err != nil {
+			return 0, nil, err
+		}
+	}
+	m.data = payload[headerSize:size]
+	return sid, m, nil
+}
+
+// parseHeaders parses the headers of a message, of the form
+// NATS/1.0[ <status>[ <description>]]\r\n(<key>: <value>\r\n)*\r\n
+func (m *natsMsg) parseHeaders(h string) error {
+	lines := strings.Split(h, "\r\n")
+	if !strings.HasPrefix(lines[0], "NATS/1.0") {
+		return fmt.Errorf("invalid message headers %q", lines[0])
+	}
+	if status := strings.TrimSpace(strings.TrimPrefix(lines[0], "NATS/1.0")); status != "" {
+		code, description, _ := strings.Cut(status, " ")
+		var err error
+		if m.status, err = strconv.Atoi(code); err != nil {
+			return fmt.Errorf("invalid message status %q", status)
+		}
+		m.description = description
+	}
+	for _, l := range lines[1:] {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		if _, ok := m.headers[k]; !ok {
+			m.headers[k] = strings.TrimSpace(v)
+		}
+	}
+	return nil
+}
+
+// request subscribes to a new inbox, publishes the request with the inbox
+// as reply subject and waits for the first reply
+func (c *natsConn) request(ctx context.Context, subject string, data []byte, headers map[string]string) (*natsMsg, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.sid++
+	sid := c.sid
+	ch := make(chan *natsMsg, 1)
+	c.subs[sid] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.subs, sid)
+		c.mu.Unlock()
+		_ = c.write([]byte(fmt.Sprintf("UNSUB %d\r\n", sid)))
+	}()
+
+	inbox := c.inbox + "." + strconv.FormatInt(sid, 10)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "SUB %s %d\r\n", inbox, sid)
+	writePub(&buf, subject, inbox, data, headers)
+	if err := c.write(buf.Bytes()); err != nil {
+		c.close(err)
+		return nil, err
+	}
+	select {
+	case m := <-ch:
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.closed()
+	}
+}
+
+// writePub writes a PUB, or HPUB if there are headers, to buf. Line
+// breaks in headers are replaced by spaces.
+func writePub(buf *bytes.Buffer, subject, reply string, data []byte, headers map[string]string) {
+	args := subject
+	if reply != "" {
+		args += " " + reply
+	}
+	if len(headers) == 0 {
+		fmt.Fprintf(buf, "PUB %s %d\r\n", args, len(data))
+	} else {
+		keys := make([]string, 0, len(headers))
+		for k := range headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		clean := strings.NewReplacer("\r", " ", "\n", " ")
+		var h bytes.Buffer
+		h.WriteString("NATS/1.0\r\n")
+		for _, k := range keys {
+			fmt.Fprintf(&h, "%s: %s\r\n", clean.Replace(k), clean.Replace(headers[k]))
+		}
+		h.WriteString("\r\n")
+		fmt.Fprintf(buf, "HPUB %s %d %d\r\n", args, h.Len(), h.Len()+len(data))
+		buf.Write(h.Bytes())
+	}
+	buf.Write(data)
+	buf.WriteString("\r\n")
+}
+
+func (c *natsConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *natsConn) write(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// close closes the connection with the error, once
+func (c *natsConn) close(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// closed returns the error the connection was closed with, nil if it is
+// open
+func (c *natsConn) closed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
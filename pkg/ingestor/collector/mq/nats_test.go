@@ -0,0 +1,587 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/internal/testing/ingestor/simpledoc"
+	"github.com/guacsec/guac/pkg/ingestor/collector"
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/process"
+)
+
+// natsServer is a NATS server with a minimal JetStream, whose streams
+// capture a single subject each
+type natsServer struct {
+	ln      net.Listener
+	token   string
+	ackWait time.Duration
+	// tls requires clients to upgrade their connection to TLS
+	tls *tls.Config
+	// caFile is the CA of the TLS certificate of the server
+	caFile string
+
+	mu      sync.Mutex
+	streams map[string]*natsStream
+	// subs are the subscriptions by subject
+	subs map[string]natsServerSub
+}
+
+type natsStream struct {
+	subject   string
+	msgs      []*natsMsg
+	consumers map[string]*natsConsumer
+}
+
+type natsConsumer struct {
+	// next is the sequence of the next message to deliver
+	next int64
+	// pending are the redelivery deadlines of the messages delivered and
+	// not acknowledged, by sequence
+	pending    map[int64]time.Time
+	deliveries map[int64]int
+}
+
+type natsServerSub struct {
+	conn *natsServerConn
+	sid  string
+}
+
+type natsServerConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (c *natsServerConn) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _ = io.WriteString(c.conn, s)
+}
+
+// newNATSServer starts a server with a stream per subject
+func newNATSServer(t *testing.T, token string, subjects ...string) *natsServer {
+	return startNATSServer(t, token, nil, "", subjects...)
+}
+
+// newTLSNATSServer starts a server requiring TLS with a stream per subject
+func newTLSNATSServer(t *testing.T, token string, subjects ...string) *natsServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return startNATSServer(t, token, config, caFile, subjects...)
+}
+
+func startNATSServer(t *testing.T, token string, config *tls.Config, caFile string, subjects ...string) *natsServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &natsServer{
+		ln:      ln,
+		token:   token,
+		ackWait: time.Hour,
+		tls:     config,
+		caFile:  caFile,
+		streams: map[string]*natsStream{},
+		subs:    map[string]natsServerSub{},
+	}
+	for i, subject := range subjects {
+		s.streams[fmt.Sprint("STREAM", i)] = &natsStream{subject: subject, consumers: map[string]*natsConsumer{}}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&natsServerConn{conn: conn})
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *natsServer) url() string {
+	if s.tls != nil {
+		return "tls://" + s.ln.Addr().String()
+	}
+	return "nats://" + s.ln.Addr().String()
+}
+
+// messages returns the messages of the stream capturing the subject
+func (s *natsServer) messages(subject string) []*natsMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.streams {
+		if st.subject == subject {
+			return append([]*natsMsg{}, st.msgs...)
+		}
+	}
+	return nil
+}
+
+func (s *natsServer) serve(c *natsServerConn) {
+	defer c.conn.Close()
+	if s.tls == nil {
+		c.write(`INFO {"server_id":"test","headers":true,"max_payload":1048576}` + "\r\n")
+	} else {
+		c.write(`INFO {"server_id":"test","headers":true,"max_payload":1048576,"tls_required":true}` + "\r\n")
+		tc := tls.Server(c.conn, s.tls)
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		c.conn = tc
+	}
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		f := strings.Fields(args)
+		switch op {
+		case "CONNECT":
+			var connect struct {
+				Token string `json:"auth_token"`
+			}
+			_ = json.Unmarshal([]byte(args), &connect)
+			if connect.Token != s.token {
+				c.write("-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			c.write("PONG\r\n")
+		case "SUB":
+			s.mu.Lock()
+			s.subs[f[0]] = natsServerSub{conn: c, sid: f[len(f)-1]}
+			s.mu.Unlock()
+		case "UNSUB":
+			s.mu.Lock()
+			for subject, sub := range s.subs {
+				if sub.conn == c && sub.sid == f[0] {
+					delete(s.subs, subject)
+				}
+			}
+			s.mu.Unlock()
+		case "PUB", "HPUB":
+			m := &natsMsg{subject: f[0], headers: map[string]string{}}
+			n := 2
+			if op == "HPUB" {
+				n = 3
+			}
+			if len(f) > n {
+				m.reply = f[1]
+			}
+			size, _ := strconv.Atoi(f[len(f)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			headerSize := 0
+			if op == "HPUB" {
+				headerSize, _ = strconv.Atoi(f[len(f)-2])
+				_ = m.parseHeaders(string(payload[:headerSize]))
+			}
+			m.data = payload[headerSize:size]
+			s.handle(m)
+		}
+	}
+}
+
+// handle handles a published message
+func (s *natsServer) handle(m *natsMsg) {
+	switch {
+	case m.subject == "$JS.API.STREAM.NAMES":
+		var req struct {
+			Subject string `json:"subject"`
+		}
+		_ = json.Unmarshal(m.data, &req)
+		s.mu.Lock()
+		names := []string{}
+		for name, st := range s.streams {
+			if st.subject == req.Subject {
+				names = append(names, name)
+			}
+		}
+		s.mu.Unlock()
+		b, _ := json.Marshal(map[string]interface{}{"streams": names})
+		s.send(m.reply, "", nil, b)
+	case strings.HasPrefix(m.subject, "$JS.API.CONSUMER.DURABLE.CREATE."):
+		tokens := strings.Split(m.subject, ".")
+		s.mu.Lock()
+		st, ok := s.streams[tokens[5]]
+		if ok && st.consumers[tokens[6]] == nil {
+			st.consumers[tokens[6]] = &natsConsumer{next: 1, pending: map[int64]time.Time{}, deliveries: map[int64]int{}}
+		}
+		s.mu.Unlock()
+		if !ok {
+			s.send(m.reply, "", nil, []byte(`{"error":{"code":404,"description":"stream not found"}}`))
+			return
+		}
+		s.send(m.reply, "", nil, []byte(`{"name":"`+tokens[6]+`"}`))
+	case strings.HasPrefix(m.subject, "$JS.API.CONSUMER.MSG.NEXT."):
+		tokens := strings.Split(m.subject, ".")
+		go s.pull(tokens[5], tokens[6], m.reply)
+	case strings.HasPrefix(m.subject, "$JS.ACK."):
+		tokens := strings.Split(m.subject, ".")
+		deliveries, _ := strconv.Atoi(tokens[4])
+		seq, _ := strconv.ParseInt(tokens[5], 10, 64)
+		s.mu.Lock()
+		cs := s.streams[tokens[2]].consumers[tokens[3]]
+		if cs.deliveries[seq] == deliveries {
+			switch string(m.data) {
+			case "+ACK":
+				delete(cs.pending, seq)
+				delete(cs.deliveries, seq)
+			case "-NAK":
+				cs.pending[seq] = time.Now()
+			}
+		}
+		s.mu.Unlock()
+		if m.reply != "" {
+			s.send(m.reply, "", nil, nil)
+		}
+	default:
+		s.mu.Lock()
+		var name string
+		var seq int
+		for n, st := range s.streams {
+			if st.subject == m.subject {
+				st.msgs = append(st.msgs, &natsMsg{subject: m.subject, headers: m.headers, data: m.data})
+				name, seq = n, len(st.msgs)
+			}
+		}
+		s.mu.Unlock()
+		if m.reply == "" {
+			return
+		}
+		if name == "" {
+			s.send(m.reply, "", []byte("NATS/1.0 503\r\n\r\n"), nil)
+			return
+		}
+		s.send(m.reply, "", nil, []byte(fmt.Sprintf(`{"stream":%q,"seq":%d}`, name, seq)))
+	}
+}
+
+// pull delivers the next message of the consumer to the reply subject, or
+// a request timeout status if there is none for a while
+func (s *natsServer) pull(stream, consumer, reply string) {
+	for i := 0; i < 4; i++ {
+		s.mu.Lock()
+		st := s.streams[stream]
+		cs := st.consumers[consumer]
+		seq := int64(0)
+		now := time.Now()
+		for p, deadline := range cs.pending {
+			if !deadline.After(now) && (seq == 0 || p < seq) {
+				seq = p
+			}
+		}
+		if seq == 0 && cs.next <= int64(len(st.msgs)) {
+			seq = cs.next
+			cs.next++
+		}
+		if seq != 0 {
+			cs.pending[seq] = now.Add(s.ackWait)
+			cs.deliveries[seq]++
+			m := st.msgs[seq-1]
+			ack := fmt.Sprintf("$JS.ACK.%s.%s.%d.%d.%d.%d.0", stream, consumer, cs.deliveries[seq], seq, seq, now.UnixNano())
+			s.mu.Unlock()
+			var h bytes.Buffer
+			h.WriteString("NATS/1.0\r\n")
+			for k, v := range m.headers {
+				fmt.Fprintf(&h, "%s: %s\r\n", k, v)
+			}
+			h.WriteString("\r\n")
+			s.deliver(reply, m.subject, ack, h.Bytes(), m.data)
+			return
+		}
+		s.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.send(reply, "", []byte("NATS/1.0 408 Request Timeout\r\n\r\n"), nil)
+}
+
+// send sends a message to the subscription of the subject, if any
+func (s *natsServer) send(subject, reply string, header, data []byte) {
+	s.deliver(subject, subject, reply, header, data)
+}
+
+// deliver sends a message of the subject to the subscription of the inbox,
+// as JetStream delivers the messages of pull requests
+func (s *natsServer) deliver(inbox, subject, reply string, header, data []byte) {
+	s.mu.Lock()
+	sub, ok := s.subs[inbox]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	args := subject + " " + sub.sid
+	if reply != "" {
+		args += " " + reply
+	}
+	if header == nil {
+		sub.conn.write(fmt.Sprintf("MSG %s %d\r\n%s\r\n", args, len(data), data))
+		return
+	}
+	sub.conn.write(fmt.Sprintf("HMSG %s %d %d\r\n%s%s\r\n", args, len(header), len(header)+len(data), header, data))
+}
+
+func newTestNATSBroker(t *testing.T, s *natsServer, token string) *NATSBroker {
+	b, err := NewNATSBroker(NATSOptions{URL: s.url(), Token: token, CAFile: s.caFile, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func Test_NATSBroker(t *testing.T) {
+	ctx := context.Background()
+	s := newTLSNATSServer(t, "secret", testSubject)
+	b := newTestNATSBroker(t, s, "secret")
+
+	publish(t, b, testSubject, "a", map[string]string{HeaderError: "multi\nline"})
+	publish(t, b, testSubject, "b", nil)
+	if err := b.Publish(ctx, "other", []byte("c"), nil); err == nil {
+		t.Errorf("expected error publishing to a subject without stream")
+	}
+	if _, err := b.Subscribe(ctx, "other", DefaultGroup); err == nil {
+		t.Errorf("expected error subscribing to a subject without stream")
+	}
+	if _, err := b.Subscribe(ctx, testSubject, "a.b"); err == nil {
+		t.Errorf("expected error subscribing with an invalid group")
+	}
+
+	sub1, err := b.Subscribe(ctx, testSubject, DefaultGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := sub1.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != "a" || m.Subject != testSubject || m.Partition != 0 || m.Offset != 1 ||
+		m.Deliveries != 1 || m.Headers[HeaderError] != "multi line" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if err := sub1.Ack(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// the message not acknowledged is delivered again once the
+	// subscription is closed
+	m, err = sub1.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.Data) != "b" || m.Offset != 2 {
+		t.Errorf("unexpected message %+v", m)
+	}
+	_ = sub1.Close()
+	if err := sub1.Ack(m); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if _, err := sub1.Next(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	sub2, err := b.Subscribe(ctx, testSubject, DefaultGroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub2.Close()
+	redelivered, err := sub2.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(redelivered.Data) != "b" || redelivered.Offset != 2 || redelivered.Deliveries != 2 {
+		t.Errorf("unexpected redelivery %+v", redelivered)
+	}
+	if err := sub2.Ack(m); err == nil {
+		t.Errorf("expected error acknowledging a previous delivery")
+	}
+	if err := sub2.Ack(redelivered); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, b, testSubject, DefaultGroup)
+
+	// the broker reconnects once its connection is lost
+	b.mu.Lock()
+	b.conn.conn.Close()
+	b.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	publish(t, b, testSubject, "c", nil)
+	if got := len(s.messages(testSubject)); got != 3 {
+		t.Errorf("got %d messages, expected 3", got)
+	}
+
+	if err := newTestNATSBroker(t, s, "wrong").Publish(ctx, testSubject, []byte("d"), nil); err == nil {
+		t.Errorf("expected error with a wrong token")
+	}
+}
+
+func Test_NATSBrokerTLS(t *testing.T) {
+	ctx := context.Background()
+	plain := newNATSServer(t, "secret", testSubject)
+	secure := newTLSNATSServer(t, "", testSubject)
+
+	testCases := []struct {
+		name    string
+		opts    NATSOptions
+		wantErr bool
+	}{{
+		name: "upgrade to TLS required by the server",
+		opts: NATSOptions{URL: strings.Replace(secure.url(), "tls://", "nats://", 1), CAFile: secure.caFile},
+	}, {
+		name:    "untrusted server certificate",
+		opts:    NATSOptions{URL: secure.url()},
+		wantErr: true,
+	}, {
+		name:    "server without TLS",
+		opts:    NATSOptions{URL: "tls://" + plain.ln.Addr().String(), Token: "secret"},
+		wantErr: true,
+	}, {
+		name:    "credentials without TLS",
+		opts:    NATSOptions{URL: plain.url(), Token: "secret"},
+		wantErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Timeout = time.Second
+			b, err := NewNATSBroker(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer b.Close()
+			err = b.Publish(ctx, testSubject, []byte("a"), nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if got := len(plain.messages(testSubject)); got != 0 {
+		t.Errorf("got %d messages sent without TLS, expected 0", got)
+	}
+}
+
+func Test_MQCollectorNATS(t *testing.T) {
+	s := newNATSServer(t, "", testSubject, testSubject+DeadLetterSuffix)
+	b := newTestNATSBroker(t, s, "")
+	publish(t, b, testSubject, `{"issuer": "google.com"}`, simpleDocHeaders)
+	publish(t, b, testSubject, `{"info": "no issuer"}`, simpleDocHeaders)
+
+	c, err := NewMQCollector(Options{Broker: b, Subject: testSubject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := collector.NewRegistry()
+	if err := r.Register(c, CollectorType); err != nil {
+		t.Fatal(err)
+	}
+	pr := process.NewRegistry()
+	_ = pr.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sources := []string{}
+	err = r.Collect(ctx, func(d *processor.Document) error {
+		sources = append(sources, d.SourceInformation.Source)
+		_, err := process.ProcessContext(ctx, d, process.Options{Registry: pr, Strict: true})
+		if len(sources) == 2 {
+			defer cancel()
+		}
+		return err
+	}, func(err error) bool {
+		return true
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{testSubject + "/0/1", testSubject + "/0/2"}
+	if fmt.Sprint(sources) != fmt.Sprint(expected) {
+		t.Errorf("got sources %v, expected %v", sources, expected)
+	}
+
+	dead := s.messages(testSubject + DeadLetterSuffix)
+	if len(dead) != 1 {
+		t.Fatalf("got %d dead letters, expected 1", len(dead))
+	}
+	if string(dead[0].data) != `{"info": "no issuer"}` || dead[0].headers[HeaderError] == "" ||
+		dead[0].headers[HeaderSource] != testSubject+"/0/2" {
+		t.Errorf("unexpected dead letter %+v", dead[0])
+	}
+	expectNone(t, b, testSubject, DefaultGroup)
+}
+
+func Test_NewNATSBroker(t *testing.T) {
+	for name, opts := range map[string]NATSOptions{
+		"no url":      {},
+		"not nats":    {URL: "https://localhost:4222"},
+		"no CA file":  {URL: "tls://localhost:4222", CAFile: "/nonexistent/ca.pem"},
+		"no host":     {URL: "nats:///path"},
+		"invalid url": {URL: "nats://[::1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewNATSBroker(opts); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// maxManifestSize is the size limit of manifests, as recommended by
+	// the OCI distribution spec
+	maxManifestSize = 4 << 20
+)
+
+var manifestMediaTypes = strings.Join([]string{
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+}, ", ")
+
+// errNotFound is returned when the registry answers 404
+var errNotFound = errors.New("not found")
+
+// descriptor is an OCI content descriptor
+type descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is an OCI image manifest or index
+type manifest struct {
+	MediaType    string       `json:"mediaType"`
+	ArtifactType string       `json:"artifactType,omitempty"`
+	Config       descriptor   `json:"config"`
+	Layers       []descriptor `json:"layers,omitempty"`
+	Manifests    []descriptor `json:"manifests,omitempty"`
+	Subject      *descriptor  `json:"subject,omitempty"`
+}
+
+// client is a minimal client of the OCI distribution API, supporting
+// anonymous, basic and bearer token authentication
+type client struct {
+	http        *http.Client
+	scheme      string
+	username    string
+	password    string
+	maxBlobSize int64
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// manifest fetches the manifest of the repository of ref with the given
+// tag or digest, and returns it with its digest
+func (c *client) manifest(ctx context.Context, ref *reference, tagOrDigest string) (*manifest, string, error) {
+	resp, err := c.get(ctx, ref, "/manifests/"+tagOrDigest, manifestMediaTypes)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := readLimited(resp.Body, maxManifestSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read manifest %s: %w", tagOrDigest, err)
+	}
+	digest := sha256Digest(b)
+	if digestRegexp.MatchString(tagOrDigest) && digest != tagOrDigest {
+		return nil, "", fmt.Errorf("manifest digest %s doesn't match %s", digest, tagOrDigest)
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, "", fmt.Errorf("unable to parse manifest %s: %w", tagOrDigest, err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+	return &m, digest, nil
+}
+
+// referrers lists the manifests referring to digest with the referrers
+// API, following pagination. errNotFound is returned if the registry
+// doesn't support the referrers API.
+func (c *client) referrers(ctx context.Context, ref *reference, digest string) ([]descriptor, error) {
+	descs := []descriptor{}
+	path := "/referrers/" + digest
+	for path != "" {
+		resp, err := c.get(ctx, ref, path, mediaTypeOCIIndex)
+		if err != nil {
+			return nil, err
+		}
+		b, err := readLimited(resp.Body, maxManifestSize)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read referrers of %s: %w", digest, err)
+		}
+		var index manifest
+		if err := json.Unmarshal(b, &index); err != nil {
+			return nil, fmt.Errorf("unable to parse referrers of %s: %w", digest, err)
+		}
+		descs = append(descs, index.Manifests...)
+		if path, err = c.nextPage(resp.Header.Get("Link"), ref, resp.Request.URL); err != nil {
+			return nil, err
+		}
+	}
+	return descs, nil
+}
+
+// blob fetches a blob and checks it against its descriptor
+func (c *client) blob(ctx context.Context, ref *reference, desc descriptor) ([]byte, error) {
+	if !digestRegexp.MatchString(desc.Digest) {
+		return nil, fmt.Errorf("unsupported blob digest %q", desc.Digest)
+	}
+	if desc.Size > c.maxBlobSize {
+		return nil, fmt.Errorf("blob %s is larger than %d bytes", desc.Digest, c.maxBlobSize)
+	}
+	resp, err := c.get(ctx, ref, "/blobs/"+desc.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := readLimited(resp.Body, c.maxBlobSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read blob %s: %w", desc.Digest, err)
+	}
+	if digest := sha256Digest(b); digest != desc.Digest {
+		return nil, fmt.Errorf("blob digest %s doesn't match %s", digest, desc.Digest)
+	}
+	return b, nil
+}
+
+// get sends a GET request for path under the repository of ref, which
+// may also be an absolute URL, authenticating if challenged
+func (c *client) get(ctx context.Context, ref *reference, path, accept string) (*http.Response, error) {
+	u := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		u = fmt.Sprintf("%s://%s/v2/%s%s", c.scheme, ref.registry, ref.repository, path)
+	}
+	resp, err := c.do(ctx, ref, u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(ctx, ref, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(ctx, ref, u, accept); err != nil {
+			return nil, err
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errNotFound
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %q from %s", resp.Status, u)
+	}
+}
+
+func (c *client) do(ctx context.Context, ref *reference, u, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	// credentials are only sent to the registry, net/http drops them on
+	// redirects to other hosts
+	if req.URL.Host != ref.registry {
+		return c.http.Do(req)
+	}
+	c.mu.Lock()
+	token := c.tokens[ref.name()]
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return c.http.Do(req)
+}
+
+// authenticate fetches a pull token for the repository of ref from the
+// token server of a bearer challenge
+func (c *client) authenticate(ctx context.Context, ref *reference, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return fmt.Errorf("unauthorized to access %s", ref.name())
+	}
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid token realm %q: %w", params["realm"], err)
+	}
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.repository + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %q from token server %s", resp.Status, u.Host)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+		return fmt.Errorf("unable to parse token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("token server %s returned no token", u.Host)
+	}
+	c.mu.Lock()
+	c.tokens[ref.name()] = token.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, such as
+// Bearer realm="https://auth.example.com/token",service="registry"
+func parseChallenge(h string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+		k, v, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(v, `"`) {
+			end := strings.Index(v[1:], `"`)
+			if end < 0 {
+				break
+			}
+			kv, rest = v[1:end+1], v[end+2:]
+		} else {
+			kv, rest, _ = strings.Cut(v, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(k))] = kv
+	}
+	return scheme, params
+}
+
+// nextPage returns the absolute URL of the rel="next" Link header of the
+// page at base, or "" if there is none. Pages on another host than the
+// registry of ref are rejected.
+func (c *client) nextPage(link string, ref *reference, base *url.URL) (string, error) {
+	if !strings.Contains(link, `rel="next"`) {
+		return "", nil
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return "", nil
+	}
+	u, err := base.Parse(link[start+1 : end])
+	if err != nil {
+		return "", fmt.Errorf("invalid next page %q: %w", link[start+1:end], err)
+	}
+	if u.Scheme != c.scheme || u.Host != ref.registry {
+		return "", fmt.Errorf("next page %s is not on registry %s", u.Redacted(), ref.registry)
+	}
+	return u.String(), nil
+}
+
+// readLimited reads r, failing if it is larger than max bytes
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("larger than %d bytes", max)
+	}
+	return b, nil
+}
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,334 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oci implements a collector of the attestations, signatures and
+// SBOMs attached to images in an OCI registry
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorType is the type of the OCI collector
+const CollectorType = "oci"
+
+// DefaultMaxBlobSize is the blob size limit used when Options.MaxBlobSize
+// is not set
+const DefaultMaxBlobSize = 64 << 20
+
+const (
+	// cosign annotations of attestation and SBOM layers
+	annotationCertificate = "dev.sigstore.cosign/certificate"
+	annotationChain       = "dev.sigstore.cosign/chain"
+	annotationBundle      = "dev.sigstore.cosign/bundle"
+
+	// mediaTypeSimpleSigning is the media type of cosign signature
+	// payloads, which are not documents
+	mediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// mediaTypeSigstoreBundle is the media type prefix of sigstore bundles
+	mediaTypeSigstoreBundle = "application/vnd.dev.sigstore.bundle"
+)
+
+// mediaTypes maps layer media types to the type and format of the document
+var mediaTypes = map[string]struct {
+	docType processor.DocumentType
+	format  processor.FormatType
+}{
+	"application/vnd.dsse.envelope.v1+json": {processor.DocumentDSSE, processor.FormatJSON},
+	"application/vnd.in-toto+json":          {processor.DocumentITE6, processor.FormatJSON},
+	"application/spdx+json":                 {processor.DocumentSPDX, processor.FormatJSON},
+	"text/spdx":                             {processor.DocumentSPDX, processor.FormatTagValue},
+	"application/vnd.cyclonedx+json":        {processor.DocumentCycloneDX, processor.FormatJSON},
+	"application/vnd.cyclonedx+xml":         {processor.DocumentCycloneDX, processor.FormatXML},
+}
+
+// Options configures an OCICollector
+type Options struct {
+	// Images are the references of the images to collect the referrers
+	// of, as registry/repository[:tag][@digest]
+	Images []string
+	// PlainHTTP accesses the registries over HTTP instead of HTTPS
+	PlainHTTP bool
+	// Username and Password authenticate to the registries and their
+	// token servers, anonymous if empty
+	Username string
+	Password string
+	// Client is the HTTP client used, http.DefaultClient if nil
+	Client *http.Client
+	// Interval polls the images for new referrers every Interval until
+	// the context is done, they are collected once if 0
+	Interval time.Duration
+	// MaxBlobSize is the size limit of collected layers,
+	// DefaultMaxBlobSize if 0
+	MaxBlobSize int64
+	// LogVerifier verifies the log entries of the cosign bundle
+	// annotations, which are ignored if nil
+	LogVerifier *tlog.Verifier
+}
+
+// OCICollector collects the layers of the referrers of images as
+// documents, with the image name and digest as SourceInformation.Source.
+//
+// The cosign annotations of the layers are untrusted hints: the signing
+// certificate chain is given to the processors to verify, and the log
+// entry of the bundle is only kept if verified against
+// Options.LogVerifier.
+type OCICollector struct {
+	opts   Options
+	refs   []*reference
+	client *client
+}
+
+// NewOCICollector creates an OCI collector
+func NewOCICollector(opts Options) (*OCICollector, error) {
+	if len(opts.Images) == 0 {
+		return nil, fmt.Errorf("oci collector images shouldn't be empty")
+	}
+	refs := make([]*reference, len(opts.Images))
+	for i, image := range opts.Images {
+		ref, err := parseReference(image)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = ref
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.MaxBlobSize <= 0 {
+		opts.MaxBlobSize = DefaultMaxBlobSize
+	}
+	scheme := "https"
+	if opts.PlainHTTP {
+		scheme = "http"
+	}
+	return &OCICollector{
+		opts: opts,
+		refs: refs,
+		client: &client{
+			http:        opts.Client,
+			scheme:      scheme,
+			username:    opts.Username,
+			password:    opts.Password,
+			maxBlobSize: opts.MaxBlobSize,
+			tokens:      map[string]string{},
+		},
+	}, nil
+}
+
+func (c *OCICollector) Type() string {
+	return CollectorType
+}
+
+// RetrieveArtifacts collects the referrers of the images, then polls them
+// every Options.Interval. Layers already sent to docChannel are not sent
+// again for the same image digest.
+func (c *OCICollector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	seen := map[string]bool{}
+	for {
+		failed := 0
+		for _, ref := range c.refs {
+			if err := c.collect(ctx, ref, seen, docChannel); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				logrus.Errorf("unable to collect referrers of %s: %v", ref, err)
+				failed++
+			}
+		}
+		if c.opts.Interval <= 0 {
+			if failed > 0 {
+				return fmt.Errorf("unable to collect referrers of %d of %d images", failed, len(c.refs))
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.opts.Interval):
+		}
+	}
+}
+
+// collect sends the layers of the referrers of ref to docChannel
+func (c *OCICollector) collect(ctx context.Context, ref *reference, seen map[string]bool, docChannel chan<- *processor.Document) error {
+	digest := ref.digest
+	if digest == "" {
+		_, d, err := c.client.manifest(ctx, ref, ref.tag)
+		if err != nil {
+			return err
+		}
+		digest = d
+	}
+	source := ref.name() + "@" + digest
+
+	manifests, err := c.referrers(ctx, ref, digest)
+	if err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		for _, layer := range m.Layers {
+			key := digest + " " + layer.Digest
+			if seen[key] || layer.MediaType == mediaTypeSimpleSigning {
+				continue
+			}
+			doc, err := c.document(ctx, ref, layer, source)
+			if err != nil {
+				return err
+			}
+			select {
+			case docChannel <- doc:
+				seen[key] = true
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// referrers returns the manifests referring to digest, from the referrers
+// API if the registry supports it, or else from the referrers tag schema
+// and the cosign .att and .sbom tags
+func (c *OCICollector) referrers(ctx context.Context, ref *reference, digest string) ([]*manifest, error) {
+	descs, err := c.client.referrers(ctx, ref, digest)
+	if errors.Is(err, errNotFound) {
+		return c.tagReferrers(ctx, ref, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifests := []*manifest{}
+	for _, desc := range descs {
+		m, _, err := c.client.manifest(ctx, ref, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// tagReferrers returns the manifests referring to digest found with tags
+// named after the digest
+func (c *OCICollector) tagReferrers(ctx context.Context, ref *reference, digest string) ([]*manifest, error) {
+	tag := strings.Replace(digest, ":", "-", 1)
+	manifests := []*manifest{}
+
+	index, _, err := c.client.manifest(ctx, ref, tag)
+	switch {
+	case errors.Is(err, errNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		for _, desc := range index.Manifests {
+			m, _, err := c.client.manifest(ctx, ref, desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, m)
+		}
+	}
+
+	for _, suffix := range []string{".att", ".sbom"} {
+		m, _, err := c.client.manifest(ctx, ref, tag+suffix)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+// document fetches a layer as a document, with the trust information of
+// its cosign annotations. Invalid bundle annotations are logged and
+// ignored.
+func (c *OCICollector) document(ctx context.Context, ref *reference, layer descriptor, source string) (*processor.Document, error) {
+	blob, err := c.client.blob(ctx, ref, layer)
+	if err != nil {
+		return nil, err
+	}
+	doc := &processor.Document{
+		Blob:   blob,
+		Type:   processor.DocumentUnknown,
+		Format: processor.FormatUnknown,
+		SourceInformation: processor.SourceInformation{
+			Collector: CollectorType,
+			Source:    source,
+		},
+	}
+	if m, ok := mediaTypes[layer.MediaType]; ok {
+		doc.Type, doc.Format = m.docType, m.format
+	} else if strings.HasPrefix(layer.MediaType, mediaTypeSigstoreBundle) {
+		doc.Type, doc.Format = processor.DocumentSigstoreBundle, processor.FormatJSON
+	}
+
+	if cert := layer.Annotations[annotationCertificate]; cert != "" {
+		doc.TrustInformation.Certificate = []byte(cert + layer.Annotations[annotationChain])
+	}
+	if bundle := layer.Annotations[annotationBundle]; bundle != "" && c.opts.LogVerifier != nil {
+		entry, err := parseCosignBundle(bundle)
+		if err == nil {
+			err = c.opts.LogVerifier.Verify(entry, blob)
+		}
+		if err != nil {
+			logrus.Warnf("ignoring bundle annotation of layer %s of %s: %v", layer.Digest, source, err)
+		} else {
+			doc.TrustInformation.LogEntry = entry
+		}
+	}
+	return doc, nil
+}
+
+// parseCosignBundle parses the log entry of a cosign bundle annotation
+func parseCosignBundle(s string) (*tlog.LogEntry, error) {
+	var bundle struct {
+		SignedEntryTimestamp string `json:"SignedEntryTimestamp"`
+		Payload              struct {
+			Body           string `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogIndex       int64  `json:"logIndex"`
+			LogID          string `json:"logID"`
+		} `json:"Payload"`
+	}
+	if err := json.Unmarshal([]byte(s), &bundle); err != nil {
+		return nil, err
+	}
+	if bundle.Payload.Body == "" {
+		return nil, fmt.Errorf("bundle body shouldn't be empty")
+	}
+	return &tlog.LogEntry{
+		Body:           bundle.Payload.Body,
+		IntegratedTime: bundle.Payload.IntegratedTime,
+		LogID:          bundle.Payload.LogID,
+		LogIndex:       bundle.Payload.LogIndex,
+		Verification: &tlog.Verification{
+			SignedEntryTimestamp: bundle.SignedEntryTimestamp,
+		},
+	}, nil
+}
@@ -0,0 +1,586 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+)
+
+const testToken = "t0ken"
+
+// testRegistry is an in-memory registry implementing the parts of the
+// OCI distribution API used by the collector
+type testRegistry struct {
+	// referrersAPI enables the referrers API
+	referrersAPI bool
+	// auth requires a bearer token from the /token endpoint
+	auth bool
+
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	// pushed lists the pushed manifest digests in order
+	pushed []string
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+}
+
+func (r *testRegistry) pushBlob(mediaType string, b []byte, annotations map[string]string) descriptor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := sha256Digest(b)
+	r.blobs[d] = b
+	return descriptor{MediaType: mediaType, Digest: d, Size: int64(len(b)), Annotations: annotations}
+}
+
+func (r *testRegistry) pushManifest(repo, tag string, m manifest) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, _ := json.Marshal(m)
+	d := sha256Digest(b)
+	r.manifests[repo+"@"+d] = b
+	r.pushed = append(r.pushed, repo+"@"+d)
+	if tag != "" {
+		r.manifests[repo+":"+tag] = b
+	}
+	return d
+}
+
+// pushReferrer pushes a manifest with the given layers referring to
+// subject
+func (r *testRegistry) pushReferrer(repo, tag, subject string, layers ...descriptor) string {
+	config := r.pushBlob("application/vnd.oci.empty.v1+json", []byte("{}"), nil)
+	return r.pushManifest(repo, tag, manifest{
+		MediaType: mediaTypeOCIManifest,
+		Config:    config,
+		Layers:    layers,
+		Subject:   &descriptor{MediaType: mediaTypeOCIManifest, Digest: subject},
+	})
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("scope") != "repository:team/app:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token": "` + testToken + `"}`))
+		return
+	}
+	if r.auth && req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	for _, endpoint := range []string{"/manifests/", "/blobs/", "/referrers/"} {
+		i := strings.Index(path, endpoint)
+		if i < 0 {
+			continue
+		}
+		repo, ref := path[:i], path[i+len(endpoint):]
+		switch endpoint {
+		case "/manifests/":
+			sep := ":"
+			if strings.HasPrefix(ref, "sha256:") {
+				sep = "@"
+			}
+			if b, ok := r.manifests[repo+sep+ref]; ok {
+				w.Header().Set("Content-Type", mediaTypeOCIManifest)
+				_, _ = w.Write(b)
+				return
+			}
+		case "/blobs/":
+			if b, ok := r.blobs[ref]; ok {
+				_, _ = w.Write(b)
+				return
+			}
+		case "/referrers/":
+			if r.referrersAPI {
+				r.serveReferrers(w, req, repo, ref)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// serveReferrers lists the referrers of subject one per page
+func (r *testRegistry) serveReferrers(w http.ResponseWriter, req *http.Request, repo, subject string) {
+	descs := []descriptor{}
+	for _, k := range r.pushed {
+		var m manifest
+		b := r.manifests[k]
+		if !strings.HasPrefix(k, repo+"@") || json.Unmarshal(b, &m) != nil {
+			continue
+		}
+		if m.Subject != nil && m.Subject.Digest == subject {
+			descs = append(descs, descriptor{MediaType: m.MediaType, Digest: sha256Digest(b), Size: int64(len(b))})
+		}
+	}
+	index := manifest{MediaType: mediaTypeOCIIndex, Manifests: []descriptor{}}
+	page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+	if page < len(descs) {
+		index.Manifests = descs[page : page+1]
+	}
+	if page+1 < len(descs) {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/referrers/%s?page=%d>; rel="next"`, repo, subject, page+1))
+	}
+	w.Header().Set("Content-Type", mediaTypeOCIIndex)
+	_ = json.NewEncoder(w).Encode(index)
+}
+
+// pushImage pushes an image tagged v1 and returns its digest
+func (r *testRegistry) pushImage(repo string) string {
+	config := r.pushBlob("application/vnd.oci.image.config.v1+json", []byte(`{"architecture": "amd64"}`), nil)
+	layer := r.pushBlob("application/vnd.oci.image.layer.v1.tar+gzip", []byte("layer"), nil)
+	return r.pushManifest(repo, "v1", manifest{
+		MediaType: mediaTypeOCIManifest,
+		Config:    config,
+		Layers:    []descriptor{layer},
+	})
+}
+
+type collected struct {
+	blob   string
+	typ    processor.DocumentType
+	format processor.FormatType
+	cert   string
+}
+
+func collect(t *testing.T, c *OCICollector) ([]collected, string, error) {
+	ch := make(chan *processor.Document, 16)
+	err := c.RetrieveArtifacts(context.Background(), ch)
+	close(ch)
+	docs := []collected{}
+	source := ""
+	for d := range ch {
+		if d.SourceInformation.Collector != CollectorType {
+			t.Errorf("unexpected collector %q", d.SourceInformation.Collector)
+		}
+		source = d.SourceInformation.Source
+		docs = append(docs, collected{string(d.Blob), d.Type, d.Format, string(d.TrustInformation.Certificate)})
+	}
+	return docs, source, err
+}
+
+func Test_OCICollector(t *testing.T) {
+	attestation := collected{blob: `{"payloadType": "application/vnd.in-toto+json"}`, typ: processor.DocumentDSSE, format: processor.FormatJSON, cert: "CERT"}
+	sbom := collected{blob: `{"spdxVersion": "SPDX-2.3"}`, typ: processor.DocumentSPDX, format: processor.FormatJSON}
+	bom := collected{blob: `<bom/>`, typ: processor.DocumentCycloneDX, format: processor.FormatXML}
+	bundle := collected{blob: `{"mediaType": "bundle"}`, typ: processor.DocumentSigstoreBundle, format: processor.FormatJSON}
+	other := collected{blob: `other`, typ: processor.DocumentUnknown, format: processor.FormatUnknown}
+
+	push := func(r *testRegistry, mediaType string, c collected) descriptor {
+		var annotations map[string]string
+		if c.cert != "" {
+			annotations = map[string]string{annotationCertificate: c.cert}
+		}
+		return r.pushBlob(mediaType, []byte(c.blob), annotations)
+	}
+
+	testCases := []struct {
+		name     string
+		setup    func(r *testRegistry, image string) string
+		expected []collected
+		wantErr  bool
+	}{{
+		name: "referrers API",
+		setup: func(r *testRegistry, image string) string {
+			r.referrersAPI = true
+			r.pushReferrer("team/app", "", image, push(r, "application/vnd.dsse.envelope.v1+json", attestation))
+			r.pushReferrer("team/app", "", image, push(r, "application/vnd.dev.sigstore.bundle.v0.3+json", bundle))
+			// referrers of other images are not collected
+			r.pushReferrer("team/app", "", sha256Digest([]byte("other")), push(r, "application/spdx+json", sbom))
+			return "team/app:v1"
+		},
+		expected: []collected{attestation, bundle},
+	}, {
+		name: "referrers tag schema",
+		setup: func(r *testRegistry, image string) string {
+			d := r.pushReferrer("team/app", "", image, push(r, "application/octet-stream", other))
+			r.pushManifest("team/app", strings.Replace(image, ":", "-", 1), manifest{
+				MediaType: mediaTypeOCIIndex,
+				Manifests: []descriptor{{MediaType: mediaTypeOCIManifest, Digest: d}},
+			})
+			return "team/app@" + image
+		},
+		expected: []collected{other},
+	}, {
+		name: "cosign tags",
+		setup: func(r *testRegistry, image string) string {
+			tag := strings.Replace(image, ":", "-", 1)
+			r.pushReferrer("team/app", tag+".att", image,
+				push(r, "application/vnd.dsse.envelope.v1+json", attestation))
+			r.pushReferrer("team/app", tag+".sbom", image,
+				push(r, "application/spdx+json", sbom), push(r, "application/vnd.cyclonedx+xml", bom))
+			// signatures are not documents
+			r.pushReferrer("team/app", tag+".sig", image,
+				r.pushBlob(mediaTypeSimpleSigning, []byte(`{"critical": {}}`), nil))
+			return "team/app:v1"
+		},
+		expected: []collected{attestation, sbom, bom},
+	}, {
+		name: "bearer token",
+		setup: func(r *testRegistry, image string) string {
+			r.auth = true
+			r.pushReferrer("team/app", strings.Replace(image, ":", "-", 1)+".sbom", image,
+				push(r, "application/spdx+json", sbom))
+			return "team/app:v1"
+		},
+		expected: []collected{sbom},
+	}, {
+		name: "no referrers",
+		setup: func(r *testRegistry, image string) string {
+			return "team/app:v1"
+		},
+		expected: []collected{},
+	}, {
+		name: "blob digest mismatch",
+		setup: func(r *testRegistry, image string) string {
+			desc := push(r, "application/spdx+json", sbom)
+			r.blobs[desc.Digest] = []byte("tampered")
+			r.pushReferrer("team/app", strings.Replace(image, ":", "-", 1)+".sbom", image, desc)
+			return "team/app:v1"
+		},
+		expected: []collected{},
+		wantErr:  true,
+	}, {
+		name: "unknown image",
+		setup: func(r *testRegistry, image string) string {
+			return "team/app:v2"
+		},
+		expected: []collected{},
+		wantErr:  true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry()
+			image := r.pushImage("team/app")
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+			host := strings.TrimPrefix(srv.URL, "http://")
+
+			c, err := NewOCICollector(Options{
+				Images:    []string{host + "/" + tt.setup(r, image)},
+				PlainHTTP: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs, source, err := collect(t, c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(docs, tt.expected) {
+				t.Errorf("got documents %v, expected %v", docs, tt.expected)
+			}
+			if len(docs) > 0 && source != host+"/team/app@"+image {
+				t.Errorf("unexpected source %q", source)
+			}
+		})
+	}
+}
+
+func Test_OCICollectorPoll(t *testing.T) {
+	r := newTestRegistry()
+	r.referrersAPI = true
+	image := r.pushImage("team/app")
+	r.pushReferrer("team/app", "", image, r.pushBlob("application/spdx+json", []byte("first"), nil))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	c, err := NewOCICollector(Options{
+		Images:    []string{strings.TrimPrefix(srv.URL, "http://") + "/team/app:v1"},
+		PlainHTTP: true,
+		Interval:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *processor.Document)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.RetrieveArtifacts(ctx, ch)
+	}()
+
+	if d := <-ch; string(d.Blob) != "first" {
+		t.Fatalf("unexpected document %q", d.Blob)
+	}
+	r.pushReferrer("team/app", "", image, r.pushBlob("application/spdx+json", []byte("second"), nil))
+	// the first document is not collected again
+	if d := <-ch; string(d.Blob) != "second" {
+		t.Fatalf("unexpected document %q", d.Blob)
+	}
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("unexpected error on shutdown: %v", err)
+	}
+}
+
+// cosignBundle returns a cosign bundle annotation with an intoto log entry
+// about the blob, signed by the log key. The entry claims to be integrated
+// at claimedTime, while the log signed integratedTime.
+func cosignBundle(t *testing.T, key *ecdsa.PrivateKey, blob []byte, integratedTime, claimedTime int64) string {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	digest := sha256.Sum256(blob)
+	body := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(
+		`{"kind":"intoto","apiVersion":"0.0.2","spec":{"content":{"hash":{"algorithm":"sha256","value":"%s"}}}}`, hex.EncodeToString(digest[:]))))
+	payload, err := cjson.EncodeCanonical(map[string]interface{}{
+		"body":           body,
+		"integratedTime": integratedTime,
+		"logID":          hex.EncodeToString(logID[:]),
+		"logIndex":       3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(payload)
+	sig, err := key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"SignedEntryTimestamp": base64.StdEncoding.EncodeToString(sig),
+		"Payload": map[string]interface{}{
+			"body":           body,
+			"integratedTime": claimedTime,
+			"logIndex":       3,
+			"logID":          hex.EncodeToString(logID[:]),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func Test_OCICollectorBundleAnnotation(t *testing.T) {
+	logKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logVerifier, err := tlog.NewVerifier(logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte(`{"payloadType": "application/vnd.in-toto+json"}`)
+
+	const integratedTime = 1660000000
+	testCases := []struct {
+		name        string
+		bundle      string
+		logVerifier *tlog.Verifier
+		expectEntry bool
+	}{{
+		name:        "verified log entry",
+		bundle:      cosignBundle(t, logKey, blob, integratedTime, integratedTime),
+		logVerifier: logVerifier,
+		expectEntry: true,
+	}, {
+		name:   "no log verifier",
+		bundle: cosignBundle(t, logKey, blob, integratedTime, integratedTime),
+	}, {
+		name:        "forged integrated time",
+		bundle:      cosignBundle(t, logKey, blob, integratedTime, integratedTime-3600),
+		logVerifier: logVerifier,
+	}, {
+		name:        "log entry about another blob",
+		bundle:      cosignBundle(t, logKey, []byte("other"), integratedTime, integratedTime),
+		logVerifier: logVerifier,
+	}, {
+		name:        "invalid annotation",
+		bundle:      "not a bundle",
+		logVerifier: logVerifier,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry()
+			r.referrersAPI = true
+			image := r.pushImage("team/app")
+			r.pushReferrer("team/app", "", image, r.pushBlob("application/vnd.dsse.envelope.v1+json", blob, map[string]string{
+				annotationCertificate: "CERT",
+				annotationBundle:      tt.bundle,
+			}))
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+
+			c, err := NewOCICollector(Options{
+				Images:      []string{strings.TrimPrefix(srv.URL, "http://") + "/team/app:v1"},
+				PlainHTTP:   true,
+				LogVerifier: tt.logVerifier,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := make(chan *processor.Document, 1)
+			if err := c.RetrieveArtifacts(context.Background(), ch); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			d := <-ch
+			if string(d.TrustInformation.Certificate) != "CERT" {
+				t.Errorf("got certificate %q, expected the annotation", d.TrustInformation.Certificate)
+			}
+			if e := d.TrustInformation.LogEntry; (e != nil) != tt.expectEntry {
+				t.Fatalf("got log entry %v, expected entry %v", e, tt.expectEntry)
+			}
+			if tt.expectEntry && d.TrustInformation.LogEntry.IntegratedTime != integratedTime {
+				t.Errorf("got integrated time %v, expected %v", d.TrustInformation.LogEntry.IntegratedTime, integratedTime)
+			}
+		})
+	}
+}
+
+func Test_ParseReference(t *testing.T) {
+	digest := sha256Digest([]byte("image"))
+	testCases := []struct {
+		ref      string
+		expected *reference
+		wantErr  bool
+	}{{
+		ref:      "localhost:5000/team/app",
+		expected: &reference{registry: "localhost:5000", repository: "team/app", tag: "latest"},
+	}, {
+		ref:      "ghcr.io/app:v1@" + digest,
+		expected: &reference{registry: "ghcr.io", repository: "app", tag: "v1", digest: digest},
+	}, {
+		ref:      "ghcr.io/app@" + digest,
+		expected: &reference{registry: "ghcr.io", repository: "app", digest: digest},
+	}, {
+		ref:     "app:v1",
+		wantErr: true,
+	}, {
+		ref:     "ghcr.io/app:",
+		wantErr: true,
+	}, {
+		ref:     "ghcr.io/app@sha256:abc",
+		wantErr: true,
+	}}
+	for _, tt := range testCases {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := parseReference(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got %+v, expected %+v", got, tt.expected)
+			}
+			if got != nil && got.String() != tt.ref && !strings.HasSuffix(got.String(), ":latest") {
+				t.Errorf("got string %q, expected %q", got.String(), tt.ref)
+			}
+		})
+	}
+}
+
+func Test_NextPage(t *testing.T) {
+	c := &client{scheme: "https"}
+	ref := &reference{registry: "ghcr.io", repository: "team/app"}
+	base, _ := url.Parse("https://ghcr.io/v2/team/app/referrers/sha256:abc")
+	testCases := []struct {
+		name     string
+		link     string
+		expected string
+		wantErr  bool
+	}{{
+		name:     "absolute path",
+		link:     `</v2/team/app/referrers/sha256:abc?page=1>; rel="next"`,
+		expected: "https://ghcr.io/v2/team/app/referrers/sha256:abc?page=1",
+	}, {
+		name:     "relative",
+		link:     `<?page=1>; rel="next"`,
+		expected: "https://ghcr.io/v2/team/app/referrers/sha256:abc?page=1",
+	}, {
+		name:     "same registry URL",
+		link:     `<https://ghcr.io/v2/team/app/referrers/sha256:abc?page=1>; rel="next"`,
+		expected: "https://ghcr.io/v2/team/app/referrers/sha256:abc?page=1",
+	}, {
+		name: "no next page",
+		link: `</v2/team/app/referrers/sha256:abc?page=0>; rel="prev"`,
+	}, {
+		name:    "other host",
+		link:    `<https://attacker.example.com/v2/team/app/referrers/sha256:abc>; rel="next"`,
+		wantErr: true,
+	}, {
+		name:    "plain HTTP",
+		link:    `<http://ghcr.io/v2/team/app/referrers/sha256:abc?page=1>; rel="next"`,
+		wantErr: true,
+	}}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.nextPage(tt.link, ref, base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("got %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_ClientCredentials(t *testing.T) {
+	var auth string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+	}))
+	defer other.Close()
+
+	c := &client{http: http.DefaultClient, scheme: "http", username: "user", password: "pass", tokens: map[string]string{}}
+	ref := &reference{registry: "registry.example.com", repository: "team/app"}
+	resp, err := c.do(context.Background(), ref, other.URL+"/v2/team/app/blobs/sha256:abc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if auth != "" {
+		t.Errorf("got Authorization %q sent to another host than the registry", auth)
+	}
+}
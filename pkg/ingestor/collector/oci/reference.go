@@ -0,0 +1,80 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// reference is an image reference, registry/repository[:tag][@digest]
+type reference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseReference parses an image reference. The registry is required, and
+// the tag defaults to latest if there is no digest.
+func parseReference(s string) (*reference, error) {
+	ref := &reference{}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.digest = name[:i], name[i+1:]
+		if !digestRegexp.MatchString(ref.digest) {
+			return nil, fmt.Errorf("invalid digest in image reference %q", s)
+		}
+	}
+	i := strings.Index(name, "/")
+	if i <= 0 {
+		return nil, fmt.Errorf("image reference %q has no registry", s)
+	}
+	ref.registry, name = name[:i], name[i+1:]
+	if j := strings.LastIndex(name, ":"); j >= 0 {
+		name, ref.tag = name[:j], name[j+1:]
+		if ref.tag == "" {
+			return nil, fmt.Errorf("empty tag in image reference %q", s)
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("image reference %q has no repository", s)
+	}
+	ref.repository = name
+	if ref.tag == "" && ref.digest == "" {
+		ref.tag = "latest"
+	}
+	return ref, nil
+}
+
+// name returns the registry and repository of the reference
+func (r *reference) name() string {
+	return r.registry + "/" + r.repository
+}
+
+func (r *reference) String() string {
+	s := r.name()
+	if r.tag != "" {
+		s += ":" + r.tag
+	}
+	if r.digest != "" {
+		s += "@" + r.digest
+	}
+	return s
+}
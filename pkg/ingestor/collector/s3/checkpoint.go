@@ -0,0 +1,93 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// checkpoint records the ETag of the collected objects by key. An empty
+// name keeps it in memory only.
+type checkpoint struct {
+	name    string
+	dirty   bool
+	Objects map[string]string `json:"objects"`
+}
+
+func loadCheckpoint(name string) (*checkpoint, error) {
+	cp := &checkpoint{name: name, Objects: map[string]string{}}
+	if name == "" {
+		return cp, nil
+	}
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	if cp.Objects == nil {
+		cp.Objects = map[string]string{}
+	}
+	return cp, nil
+}
+
+// changed returns whether the object was modified since it was recorded
+func (cp *checkpoint) changed(key, etag string) bool {
+	recorded, ok := cp.Objects[key]
+	return !ok || etag == "" || recorded != etag
+}
+
+func (cp *checkpoint) record(key, etag string) {
+	cp.Objects[key] = etag
+	cp.dirty = true
+}
+
+// prune forgets the objects not in keys, which were deleted
+func (cp *checkpoint) prune(keys map[string]bool) {
+	for key := range cp.Objects {
+		if !keys[key] {
+			delete(cp.Objects, key)
+			cp.dirty = true
+		}
+	}
+}
+
+// save writes the checkpoint if it changed. The checkpoint is written to
+// a temporary file renamed over the previous one, so that a crash never
+// leaves a partial checkpoint.
+func (cp *checkpoint) save() error {
+	if cp.name == "" || !cp.dirty {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := cp.name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, cp.name); err != nil {
+		return err
+	}
+	cp.dirty = false
+	return nil
+}
@@ -0,0 +1,253 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat = "20060102T150405Z"
+	// emptySHA256 is the hex sha256 of an empty payload
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// maxResponseSize is the size limit of listings and errors
+	maxResponseSize = 16 << 20
+)
+
+// object is an object of a listing
+type object struct {
+	Key  string `xml:"Key"`
+	ETag string `xml:"ETag"`
+	Size int64  `xml:"Size"`
+}
+
+type listBucketResult struct {
+	Contents              []object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+// client is a minimal client of the S3 API, using path-style requests
+// signed with AWS signature version 4, or anonymous if there is no access
+// key
+type client struct {
+	http          *http.Client
+	endpoint      *url.URL
+	region        string
+	accessKey     string
+	secretKey     string
+	sessionToken  string
+	maxObjectSize int64
+	now           func() time.Time
+}
+
+// list lists a page of the objects of bucket under prefix, and returns
+// the continuation token of the next page, empty on the last page
+func (c *client) list(ctx context.Context, bucket, prefix, token string) ([]object, string, error) {
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	resp, err := c.do(ctx, bucket, "", q)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var res listBucketResult
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&res); err != nil {
+		return nil, "", fmt.Errorf("unable to parse listing of bucket %s: %w", bucket, err)
+	}
+	for i := range res.Contents {
+		res.Contents[i].ETag = trimETag(res.Contents[i].ETag)
+	}
+	if !res.IsTruncated {
+		return res.Contents, "", nil
+	}
+	if res.NextContinuationToken == "" {
+		return nil, "", fmt.Errorf("truncated listing of bucket %s has no continuation token", bucket)
+	}
+	return res.Contents, res.NextContinuationToken, nil
+}
+
+// get fetches an object and returns it with its ETag
+func (c *client) get(ctx context.Context, bucket, key string) ([]byte, string, error) {
+	resp, err := c.do(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, c.maxObjectSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read object %s: %w", key, err)
+	}
+	if int64(len(b)) > c.maxObjectSize {
+		return nil, "", fmt.Errorf("object %s is larger than %d bytes", key, c.maxObjectSize)
+	}
+	return b, trimETag(resp.Header.Get("ETag")), nil
+}
+
+// listen opens the stream of the object created events of bucket under
+// prefix, with the MinIO bucket notification API
+func (c *client) listen(ctx context.Context, bucket, prefix string) (io.ReadCloser, error) {
+	q := url.Values{"events": {"s3:ObjectCreated:*"}, "prefix": {prefix}}
+	resp, err := c.do(ctx, bucket, "", q)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do sends a signed GET request for the key of bucket
+func (c *client) do(ctx context.Context, bucket, key string, q url.Values) (*http.Response, error) {
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.Path, u.RawPath = p, uriEncode(p, false)
+	u.RawQuery = canonicalQuery(q)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, emptySHA256)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&s3Err); err != nil || s3Err.Code == "" {
+		return nil, fmt.Errorf("unexpected status %q from %s", resp.Status, u.Host)
+	}
+	return nil, fmt.Errorf("%s: %s", s3Err.Code, s3Err.Message)
+}
+
+// sign signs the request with AWS signature version 4, signing the host
+// and x-amz-* headers
+func (c *client) sign(req *http.Request, payloadHash string) {
+	if c.accessKey == "" {
+		return
+	}
+	now := c.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, s := range []string{now.Format("20060102"), c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes the query sorted by key, as signature version 4
+// requires
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		values := append([]string{}, q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes all but the unreserved characters of RFC
+// 3986, and slashes unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~', ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func trimETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,394 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3 implements a collector of the objects of an S3-compatible
+// bucket
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorType is the type of the S3 collector
+const CollectorType = "s3"
+
+// DefaultRegion is the region used when Options.Region is not set
+const DefaultRegion = "us-east-1"
+
+// DefaultMaxObjectSize is the object size limit used when
+// Options.MaxObjectSize is not set
+const DefaultMaxObjectSize = 64 << 20
+
+// DefaultRetryInterval is the delay before reopening a closed
+// notification stream when Options.Interval is not set
+const DefaultRetryInterval = 5 * time.Second
+
+// saveInterval is the minimum delay between checkpoint saves while objects
+// are being acknowledged
+const saveInterval = time.Second
+
+// Options configures an S3Collector
+type Options struct {
+	// Endpoint is the URL of the S3-compatible service, such as
+	// https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+	// Bucket is the bucket to collect the objects of
+	Bucket string
+	// Prefix restricts the collected objects to the keys with this
+	// prefix
+	Prefix string
+	// Region is the region of the bucket, DefaultRegion if empty
+	Region string
+	// AccessKey, SecretKey and SessionToken are the credentials requests
+	// are signed with, anonymous if AccessKey is empty
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Client is the HTTP client used, http.DefaultClient if nil
+	Client *http.Client
+	// Checkpoint is the file recording the key and ETag of the collected
+	// objects, so that objects left unmodified are not collected again
+	// after a restart. No checkpoint is kept if empty.
+	Checkpoint string
+	// Interval lists the bucket for new objects every Interval until the
+	// context is done, it is listed once if 0. With Notifications, it is
+	// the delay before reopening a closed notification stream.
+	Interval time.Duration
+	// Notifications subscribes to the object created events of the bucket
+	// after the first listing, with the MinIO bucket notification API
+	Notifications bool
+	// MaxObjectSize is the size limit of collected objects,
+	// DefaultMaxObjectSize if 0
+	MaxObjectSize int64
+}
+
+// S3Collector collects the objects of a bucket as documents, with
+// s3://bucket/key as SourceInformation.Source. Objects are recorded in the
+// checkpoint once their document is acknowledged without error.
+type S3Collector struct {
+	opts   Options
+	client *client
+
+	mu      sync.Mutex
+	cp      *checkpoint
+	pending map[*processor.Document]pendingObject
+	running bool
+	saved   time.Time
+}
+
+// pendingObject is a collected object waiting for its acknowledgement
+type pendingObject struct {
+	key  string
+	etag string
+}
+
+// NewS3Collector creates an S3 collector
+func NewS3Collector(opts Options) (*S3Collector, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("s3 collector endpoint shouldn't be empty")
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 collector bucket shouldn't be empty")
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", opts.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", opts.Endpoint)
+	}
+	if opts.AccessKey != "" && opts.SecretKey == "" {
+		return nil, fmt.Errorf("s3 collector secret key shouldn't be empty")
+	}
+	if opts.Region == "" {
+		opts.Region = DefaultRegion
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.MaxObjectSize <= 0 {
+		opts.MaxObjectSize = DefaultMaxObjectSize
+	}
+	return &S3Collector{
+		opts: opts,
+		client: &client{
+			http:          opts.Client,
+			endpoint:      endpoint,
+			region:        opts.Region,
+			accessKey:     opts.AccessKey,
+			secretKey:     opts.SecretKey,
+			sessionToken:  opts.SessionToken,
+			maxObjectSize: opts.MaxObjectSize,
+			now:           time.Now,
+		},
+	}, nil
+}
+
+func (c *S3Collector) Type() string {
+	return CollectorType
+}
+
+// RetrieveArtifacts lists the bucket, then polls it or listens to its
+// notifications. Objects are recorded in the checkpoint by Ack, so that
+// objects not acknowledged or failing are collected again.
+func (c *S3Collector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	cp, err := loadCheckpoint(c.opts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cp, c.pending, c.running, c.saved = cp, map[*processor.Document]pendingObject{}, true, time.Now()
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.running = false
+		if err := c.cp.save(); err != nil {
+			logrus.Errorf("unable to save s3 collector checkpoint: %v", err)
+		}
+	}()
+
+	if err := c.list(ctx, docChannel); err != nil {
+		return ignoreDone(ctx, err)
+	}
+	if c.opts.Notifications {
+		return c.listen(ctx, docChannel)
+	}
+	if c.opts.Interval <= 0 {
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.opts.Interval):
+		}
+		if err := c.list(ctx, docChannel); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logrus.Errorf("unable to list bucket %s: %v", c.opts.Bucket, err)
+		}
+	}
+}
+
+// Ack records the object of the document in the checkpoint if it was
+// handled without error. The checkpoint is saved at most every
+// saveInterval, and once the collector stopped and all its documents are
+// acknowledged.
+func (c *S3Collector) Ack(d *processor.Document, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	o, ok := c.pending[d]
+	if !ok {
+		return
+	}
+	delete(c.pending, d)
+	if err != nil {
+		return
+	}
+	c.cp.record(o.key, o.etag)
+	if time.Since(c.saved) < saveInterval && (c.running || len(c.pending) > 0) {
+		return
+	}
+	if err := c.cp.save(); err != nil {
+		logrus.Errorf("unable to save s3 collector checkpoint: %v", err)
+	}
+	c.saved = time.Now()
+}
+
+// changed returns whether the object was modified since it was recorded
+// and is not waiting for the acknowledgement of the same version
+func (c *S3Collector) changed(key, etag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cp.changed(key, etag) {
+		return false
+	}
+	for _, o := range c.pending {
+		if o.key == key && o.etag == etag && etag != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// list sends the objects of the bucket not collected since their last
+// modification to docChannel
+func (c *S3Collector) list(ctx context.Context, docChannel chan<- *processor.Document) error {
+	keys := map[string]bool{}
+	token := ""
+	for {
+		objects, next, err := c.client.list(ctx, c.opts.Bucket, c.opts.Prefix, token)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			keys[o.Key] = true
+			if strings.HasSuffix(o.Key, "/") || !c.changed(o.Key, o.ETag) {
+				continue
+			}
+			if err := c.collect(ctx, o.Key, docChannel); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				logrus.Errorf("unable to collect s3://%s/%s: %v", c.opts.Bucket, o.Key, err)
+				continue
+			}
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cp.prune(keys)
+	return c.cp.save()
+}
+
+// listen collects the objects of the object created events of the bucket,
+// reopening the notification stream and listing the bucket again if it
+// closes, until ctx is done
+func (c *S3Collector) listen(ctx context.Context, docChannel chan<- *processor.Document) error {
+	retry := c.opts.Interval
+	if retry <= 0 {
+		retry = DefaultRetryInterval
+	}
+	for {
+		err := c.consume(ctx, docChannel)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logrus.Warnf("s3 notification stream of bucket %s closed: %v", c.opts.Bucket, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retry):
+		}
+		// objects created while the stream was closed
+		if err := c.list(ctx, docChannel); err != nil && ctx.Err() == nil {
+			logrus.Errorf("unable to list bucket %s: %v", c.opts.Bucket, err)
+		}
+	}
+}
+
+// event is a MinIO bucket notification
+type event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key  string `json:"key"`
+				ETag string `json:"eTag"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// consume collects the objects of the events of a notification stream
+// until it closes
+func (c *S3Collector) consume(ctx context.Context, docChannel chan<- *processor.Document) error {
+	stream, err := c.client.listen(ctx, c.opts.Bucket, c.opts.Prefix)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	dec := json.NewDecoder(stream)
+	for {
+		var ev event
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("stream ended")
+			}
+			return err
+		}
+		for _, r := range ev.Records {
+			if !strings.HasPrefix(r.EventName, "s3:ObjectCreated:") || r.S3.Bucket.Name != c.opts.Bucket {
+				continue
+			}
+			// keys are URL encoded in events
+			key, err := url.QueryUnescape(r.S3.Object.Key)
+			if err != nil {
+				logrus.Warnf("invalid key %q in s3 notification: %v", r.S3.Object.Key, err)
+				continue
+			}
+			if !strings.HasPrefix(key, c.opts.Prefix) || !c.changed(key, trimETag(r.S3.Object.ETag)) {
+				continue
+			}
+			if err := c.collect(ctx, key, docChannel); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				logrus.Errorf("unable to collect s3://%s/%s: %v", c.opts.Bucket, key, err)
+			}
+		}
+	}
+}
+
+// collect sends the object to docChannel
+func (c *S3Collector) collect(ctx context.Context, key string, docChannel chan<- *processor.Document) error {
+	blob, etag, err := c.client.get(ctx, c.opts.Bucket, key)
+	if err != nil {
+		return err
+	}
+	doc := &processor.Document{
+		Blob:   blob,
+		Type:   processor.DocumentUnknown,
+		Format: processor.FormatUnknown,
+		SourceInformation: processor.SourceInformation{
+			Collector: CollectorType,
+			Source:    "s3://" + c.opts.Bucket + "/" + key,
+		},
+	}
+	// the document is pending before being sent, as it may be
+	// acknowledged as soon as it is received
+	c.mu.Lock()
+	c.pending[doc] = pendingObject{key: key, etag: etag}
+	c.mu.Unlock()
+	select {
+	case docChannel <- doc:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, doc)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// ignoreDone returns nil for errors caused by ctx being done
+func ignoreDone(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,359 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook implements a collector of documents pushed over HTTP
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorType is the type of the webhook collector
+const CollectorType = "webhook"
+
+// Request headers
+const (
+	// HeaderDocumentType sets the processor.DocumentType of the document
+	HeaderDocumentType = "X-Guac-Document-Type"
+	// HeaderDocumentFormat sets the processor.FormatType of the document
+	HeaderDocumentFormat = "X-Guac-Document-Format"
+	// HeaderSignature is the hex encoded HMAC-SHA256 of the request body,
+	// prefixed with "sha256="
+	HeaderSignature = "X-Hub-Signature-256"
+)
+
+// DefaultMaxBodySize is the maximum request body size used when
+// Options.MaxBodySize is not set
+const DefaultMaxBodySize = 10 << 20
+
+// shutdownTimeout is how long in-flight requests are given to complete
+// when the collector stops
+const shutdownTimeout = 10 * time.Second
+
+// mediaTypes maps request media types to the type and format of the
+// document
+var mediaTypes = map[string]struct {
+	docType processor.DocumentType
+	format  processor.FormatType
+}{
+	"application/json":                   {processor.DocumentUnknown, processor.FormatJSON},
+	"application/xml":                    {processor.DocumentUnknown, processor.FormatXML},
+	"text/xml":                           {processor.DocumentUnknown, processor.FormatXML},
+	"application/vnd.dsse.envelope+json": {processor.DocumentDSSE, processor.FormatJSON},
+	"application/vnd.in-toto+json":       {processor.DocumentITE6, processor.FormatJSON},
+	"application/spdx+json":              {processor.DocumentSPDX, processor.FormatJSON},
+	"text/spdx":                          {processor.DocumentSPDX, processor.FormatTagValue},
+	"application/vnd.cyclonedx+json":     {processor.DocumentCycloneDX, processor.FormatJSON},
+	"application/vnd.cyclonedx+xml":      {processor.DocumentCycloneDX, processor.FormatXML},
+	"application/x-tar":                  {processor.DocumentArchive, processor.FormatTar},
+	"application/zip":                    {processor.DocumentArchive, processor.FormatZip},
+	"application/gzip":                   {processor.DocumentArchive, processor.FormatGzip},
+}
+
+// Options configures a WebhookCollector. At least one of Secret and
+// HMACKey is required.
+type Options struct {
+	// Addr is the address to listen on, ignored if Listener is set
+	Addr string
+	// Listener is the listener to serve on
+	Listener net.Listener
+	// Path is the URL path documents are posted to, "/" if empty
+	Path string
+
+	// Secret is a shared secret requests must give as a bearer token
+	Secret string
+	// HMACKey is the key of the HMAC-SHA256 of the request body requests
+	// must give in HeaderSignature
+	HMACKey []byte
+
+	// MaxBodySize is the maximum request body size, DefaultMaxBodySize if 0
+	MaxBodySize int64
+
+	// TLSCertFile and TLSKeyFile serve HTTPS if set
+	TLSCertFile string
+	TLSKeyFile  string
+	// ClientCAFile requires HTTPS clients to present a certificate issued
+	// by one of its PEM encoded CAs
+	ClientCAFile string
+}
+
+// WebhookCollector runs an HTTP server collecting the documents posted to
+// it. A request body is a single document, or one document per part for
+// multipart requests.
+//
+// The document type and format are taken from the HeaderDocumentType and
+// HeaderDocumentFormat headers, or derived from the content type, and
+// otherwise guessed when processed.
+//
+// SourceInformation.Source is the remote identity, being the common name
+// of the verified client certificate or the remote host. It is only made
+// of what the server verified, as trust policies match it, so the file
+// names of multipart documents are not part of it.
+//
+// Requests are answered 202 Accepted once their documents are queued for
+// processing, which is fire-and-forget: clients are not told whether the
+// documents are then ingested.
+type WebhookCollector struct {
+	opts Options
+}
+
+// NewWebhookCollector creates a webhook collector
+func NewWebhookCollector(opts Options) (*WebhookCollector, error) {
+	if opts.Secret == "" && len(opts.HMACKey) == 0 {
+		return nil, fmt.Errorf("webhook collector needs a secret or HMAC key")
+	}
+	if opts.Addr == "" && opts.Listener == nil {
+		return nil, fmt.Errorf("webhook collector address shouldn't be empty")
+	}
+	if (opts.TLSCertFile == "") != (opts.TLSKeyFile == "") {
+		return nil, fmt.Errorf("webhook collector needs both a TLS certificate and key")
+	}
+	if opts.ClientCAFile != "" && opts.TLSCertFile == "" {
+		return nil, fmt.Errorf("webhook collector client CA requires TLS")
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	return &WebhookCollector{opts: opts}, nil
+}
+
+func (c *WebhookCollector) Type() string {
+	return CollectorType
+}
+
+// RetrieveArtifacts serves until ctx is done, then stops accepting
+// requests and waits for in-flight requests to complete
+func (c *WebhookCollector) RetrieveArtifacts(ctx context.Context, docChannel chan<- *processor.Document) error {
+	mux := http.NewServeMux()
+	mux.Handle(c.opts.Path, c.handler(ctx, docChannel))
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if c.opts.ClientCAFile != "" {
+		b, err := os.ReadFile(c.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate in client CA file %s", c.opts.ClientCAFile)
+		}
+		srv.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+
+	l := c.opts.Listener
+	if l == nil {
+		var err error
+		if l, err = net.Listen("tcp", c.opts.Addr); err != nil {
+			return err
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if c.opts.TLSCertFile != "" {
+			errs <- srv.ServeTLS(l, c.opts.TLSCertFile, c.opts.TLSKeyFile)
+		} else {
+			errs <- srv.Serve(l)
+		}
+	}()
+	logrus.Infof("webhook collector listening on %s", l.Addr())
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// handler returns the handler sending the posted documents to docChannel
+func (c *WebhookCollector) handler(ctx context.Context, docChannel chan<- *processor.Document) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, c.opts.MaxBodySize+1))
+		if err != nil {
+			http.Error(w, "unable to read request body", http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > c.opts.MaxBodySize {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := c.authenticate(r, body); err != nil {
+			logrus.Warnf("webhook request from %s rejected: %v", r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		docs, err := documents(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, d := range docs {
+			select {
+			case docChannel <- d:
+			case <-ctx.Done():
+				http.Error(w, "collector shutting down", http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// authenticate checks the shared secret and body HMAC of the request
+func (c *WebhookCollector) authenticate(r *http.Request, body []byte) error {
+	if c.opts.Secret != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.opts.Secret)) != 1 {
+			return fmt.Errorf("invalid secret")
+		}
+	}
+	if len(c.opts.HMACKey) > 0 {
+		sig, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(HeaderSignature), "sha256="))
+		if err != nil {
+			return fmt.Errorf("invalid signature encoding")
+		}
+		mac := hmac.New(sha256.New, c.opts.HMACKey)
+		mac.Write(body)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return fmt.Errorf("invalid signature")
+		}
+	}
+	return nil
+}
+
+// documents returns the documents of the request
+func documents(r *http.Request, body []byte) ([]*processor.Document, error) {
+	source := identity(r)
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		d, err := document(body, r.Header)
+		if err != nil {
+			return nil, err
+		}
+		d.SourceInformation.Source = source
+		return []*processor.Document{d}, nil
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var docs []*processor.Document
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		d, err := document(b, http.Header(p.Header))
+		if err != nil {
+			return nil, err
+		}
+		d.SourceInformation.Source = source
+		docs = append(docs, d)
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no document in multipart body")
+	}
+	return docs, nil
+}
+
+// document returns a document of the blob, typed by the headers
+func document(blob []byte, h http.Header) (*processor.Document, error) {
+	if len(blob) == 0 {
+		return nil, fmt.Errorf("empty document")
+	}
+	d := &processor.Document{
+		Blob:   blob,
+		Type:   processor.DocumentUnknown,
+		Format: processor.FormatUnknown,
+		SourceInformation: processor.SourceInformation{
+			Collector: CollectorType,
+		},
+	}
+	if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil {
+		if m, ok := mediaTypes[mediaType]; ok {
+			d.Type, d.Format = m.docType, m.format
+		}
+	}
+	if t := h.Get(HeaderDocumentType); t != "" {
+		d.Type = processor.DocumentType(t)
+	}
+	if f := h.Get(HeaderDocumentFormat); f != "" {
+		d.Format = processor.FormatType(f)
+	}
+	return d, nil
+}
+
+// identity returns the common name of the verified client certificate, or
+// the remote host
+func identity(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		if cn := r.TLS.VerifiedChains[0][0].Subject.CommonName; cn != "" {
+			return cn
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,326 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+const testSecret = "s3cret"
+
+var testHMACKey = []byte("hmac-key")
+
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, testHMACKey)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func multipartBody(t *testing.T) (string, []byte) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range []struct {
+		name, contentType, content string
+	}{
+		{"sbom.spdx.json", "application/spdx+json", `{"spdxVersion": "SPDX-2.3"}`},
+		// file names are chosen by clients, and so not part of the source
+		{"../../var/ci/att.json", "application/vnd.dsse.envelope+json", `{"payloadType": "x"}`},
+	} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="document"; filename="`+p.name+`"`)
+		h.Set("Content-Type", p.contentType)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(p.content))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+func Test_WebhookCollector(t *testing.T) {
+	contentType, multipartBlob := multipartBody(t)
+	blob := []byte(`{"payloadType": "application/vnd.in-toto+json"}`)
+
+	type expectedDoc struct {
+		Blob   string
+		Type   processor.DocumentType
+		Format processor.FormatType
+		Source string
+	}
+	testCases := []struct {
+		name         string
+		opts         Options
+		method       string
+		body         []byte
+		headers      map[string]string
+		expectedCode int
+		expectedDocs []expectedDoc
+	}{{
+		name:         "raw document with secret",
+		opts:         Options{Secret: testSecret},
+		body:         blob,
+		headers:      map[string]string{"Authorization": "Bearer " + testSecret},
+		expectedCode: http.StatusAccepted,
+		expectedDocs: []expectedDoc{{string(blob), processor.DocumentUnknown, processor.FormatUnknown, "127.0.0.1"}},
+	}, {
+		name: "DSSE envelope with HMAC",
+		opts: Options{HMACKey: testHMACKey},
+		body: blob,
+		headers: map[string]string{
+			"Content-Type":  "application/vnd.dsse.envelope+json",
+			HeaderSignature: sign(blob),
+		},
+		expectedCode: http.StatusAccepted,
+		expectedDocs: []expectedDoc{{string(blob), processor.DocumentDSSE, processor.FormatJSON, "127.0.0.1"}},
+	}, {
+		name: "type and format headers",
+		opts: Options{Secret: testSecret},
+		body: blob,
+		headers: map[string]string{
+			"Authorization":      "Bearer " + testSecret,
+			"Content-Type":       "application/json",
+			HeaderDocumentType:   string(processor.DocumentITE6),
+			HeaderDocumentFormat: string(processor.FormatJSON),
+		},
+		expectedCode: http.StatusAccepted,
+		expectedDocs: []expectedDoc{{string(blob), processor.DocumentITE6, processor.FormatJSON, "127.0.0.1"}},
+	}, {
+		name: "multipart",
+		opts: Options{Secret: testSecret, HMACKey: testHMACKey},
+		body: multipartBlob,
+		headers: map[string]string{
+			"Authorization": "Bearer " + testSecret,
+			"Content-Type":  contentType,
+			HeaderSignature: sign(multipartBlob),
+		},
+		expectedCode: http.StatusAccepted,
+		expectedDocs: []expectedDoc{
+			{`{"spdxVersion": "SPDX-2.3"}`, processor.DocumentSPDX, processor.FormatJSON, "127.0.0.1"},
+			{`{"payloadType": "x"}`, processor.DocumentDSSE, processor.FormatJSON, "127.0.0.1"},
+		},
+	}, {
+		name:         "wrong secret",
+		opts:         Options{Secret: testSecret},
+		body:         blob,
+		headers:      map[string]string{"Authorization": "Bearer wrong"},
+		expectedCode: http.StatusUnauthorized,
+	}, {
+		name:         "missing HMAC",
+		opts:         Options{Secret: testSecret, HMACKey: testHMACKey},
+		body:         blob,
+		headers:      map[string]string{"Authorization": "Bearer " + testSecret},
+		expectedCode: http.StatusUnauthorized,
+	}, {
+		name:         "HMAC of another body",
+		opts:         Options{HMACKey: testHMACKey},
+		body:         blob,
+		headers:      map[string]string{HeaderSignature: sign([]byte("other"))},
+		expectedCode: http.StatusUnauthorized,
+	}, {
+		name:         "body too large",
+		opts:         Options{Secret: testSecret, MaxBodySize: 8},
+		body:         blob,
+		headers:      map[string]string{"Authorization": "Bearer " + testSecret},
+		expectedCode: http.StatusRequestEntityTooLarge,
+	}, {
+		name:         "empty body",
+		opts:         Options{Secret: testSecret},
+		headers:      map[string]string{"Authorization": "Bearer " + testSecret},
+		expectedCode: http.StatusBadRequest,
+	}, {
+		name:         "not a POST",
+		opts:         Options{Secret: testSecret},
+		method:       http.MethodGet,
+		expectedCode: http.StatusMethodNotAllowed,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Addr = "unused"
+			c, err := NewWebhookCollector(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := make(chan *processor.Document, 10)
+			srv := httptest.NewServer(c.handler(context.Background(), ch))
+			defer srv.Close()
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req, err := http.NewRequest(method, srv.URL, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("got status %v, expected %v", resp.StatusCode, tt.expectedCode)
+			}
+
+			close(ch)
+			var docs []expectedDoc
+			for d := range ch {
+				if d.SourceInformation.Collector != CollectorType {
+					t.Errorf("got collector %q, expected %q", d.SourceInformation.Collector, CollectorType)
+				}
+				docs = append(docs, expectedDoc{string(d.Blob), d.Type, d.Format, d.SourceInformation.Source})
+			}
+			if !reflect.DeepEqual(docs, tt.expectedDocs) {
+				t.Errorf("got documents %+v, expected %+v", docs, tt.expectedDocs)
+			}
+		})
+	}
+}
+
+func Test_NewWebhookCollector(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"no secret":         {Addr: ":0"},
+		"no address":        {Secret: testSecret},
+		"TLS key missing":   {Addr: ":0", Secret: testSecret, TLSCertFile: "cert.pem"},
+		"client CA, no TLS": {Addr: ":0", Secret: testSecret, ClientCAFile: "ca.pem"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewWebhookCollector(opts); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func Test_WebhookClientCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ci-runner"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	c, _ := NewWebhookCollector(Options{Addr: "unused", Secret: testSecret})
+	ch := make(chan *processor.Document, 1)
+	srv := httptest.NewUnstartedServer(c.handler(context.Background(), ch))
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Authorization", "Bearer "+testSecret)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got status %v, expected %v", resp.StatusCode, http.StatusAccepted)
+	}
+	if d := <-ch; d.SourceInformation.Source != "ci-runner" {
+		t.Errorf("got source %q, expected client certificate identity", d.SourceInformation.Source)
+	}
+}
+
+func Test_WebhookRetrieveArtifacts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewWebhookCollector(Options{Listener: l, Path: "/documents", Secret: testSecret})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan *processor.Document)
+	done := make(chan error)
+	go func() {
+		done <- c.RetrieveArtifacts(ctx, ch)
+	}()
+
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, "http://"+l.Addr().String()+"/documents", bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("Authorization", "Bearer "+testSecret)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	select {
+	case d := <-ch:
+		if string(d.Blob) != `{}` {
+			t.Errorf("unexpected document %s", d.Blob)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for document")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected graceful shutdown, got %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("collector did not stop")
+	}
+}
@@ -0,0 +1,302 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/guesser"
+)
+
+// Default limits of the archive processor
+const (
+	DefaultMaxTotalSize int64 = 256 << 20
+	DefaultMaxEntries         = 10000
+)
+
+var (
+	// ErrTotalSizeExceeded is returned when the uncompressed size of an
+	// archive is larger than the configured limit
+	ErrTotalSizeExceeded = errors.New("archive uncompressed size exceeds limit")
+	// ErrEntriesExceeded is returned when an archive has more entries than
+	// the configured limit
+	ErrEntriesExceeded = errors.New("archive entry count exceeds limit")
+	// ErrPathTraversal is returned when an archive entry path is absolute
+	// or escapes the archive root
+	ErrPathTraversal = errors.New("archive entry path escapes archive root")
+)
+
+// Limits bound the resources an archive may consume when unpacked
+type Limits struct {
+	// MaxTotalSize is the maximum total uncompressed size in bytes
+	MaxTotalSize int64
+	// MaxEntries is the maximum number of entries in the archive
+	MaxEntries int
+}
+
+// DefaultLimits returns the default archive limits
+func DefaultLimits() Limits {
+	return Limits{
+		MaxTotalSize: DefaultMaxTotalSize,
+		MaxEntries:   DefaultMaxEntries,
+	}
+}
+
+// ArchiveProcessor processes tar, zip and gzip archives. Each regular file
+// in the archive is unpacked as a child document whose type and format
+// are guessed from its content, and whose source is the archive source
+// followed by the member path. Directories are skipped, and links and
+// other special files are ignored.
+type ArchiveProcessor struct {
+	Limits Limits
+}
+
+// NewArchiveProcessor creates an archive processor with the given limits
+func NewArchiveProcessor(l Limits) *ArchiveProcessor {
+	return &ArchiveProcessor{Limits: l}
+}
+
+// ValidateSchema checks the archive entries from their headers, without
+// extracting them. The limits are enforced again by Unpack, on the actual
+// entry sizes.
+func (dp *ArchiveProcessor) ValidateSchema(d *processor.Document) error {
+	_, err := dp.extract(d, true)
+	return err
+}
+
+func (dp *ArchiveProcessor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (dp *ArchiveProcessor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	entries, err := dp.extract(d, false)
+	if err != nil {
+		return nil, err
+	}
+
+	retDocs := make([]*processor.Document, len(entries))
+	for i, e := range entries {
+		retDocs[i] = &processor.Document{
+			Blob:              e.blob,
+			TrustInformation:  d.TrustInformation,
+			SourceInformation: d.SourceInformation,
+		}
+		if e.name != "" {
+			retDocs[i].SourceInformation.Source += "/" + e.name
+		}
+		// Entries that cannot be recognized are kept as unknown
+		// documents and rejected by the processing pipeline
+		_ = guesser.GuessDocument(retDocs[i])
+	}
+	return retDocs, nil
+}
+
+// Describe describes the archive formats. Archive members are unpacked with
+// their guessed type, or as documents of unknown type.
+func (dp *ArchiveProcessor) Describe() processor.Description {
+	return processor.Description{
+		Formats:    []processor.FormatType{processor.FormatTar, processor.FormatZip, processor.FormatGzip},
+		ChildTypes: []processor.DocumentType{processor.DocumentUnknown},
+	}
+}
+
+type entry struct {
+	name string
+	blob []byte
+}
+
+// extractor accumulates archive entries, enforcing the limits. In scan
+// mode, entries are only accounted from their headers.
+type extractor struct {
+	limits  Limits
+	scan    bool
+	total   int64
+	count   int
+	entries []entry
+}
+
+func (dp *ArchiveProcessor) extract(d *processor.Document, scan bool) ([]entry, error) {
+	ex := &extractor{limits: dp.Limits, scan: scan}
+	if ex.limits.MaxTotalSize <= 0 {
+		ex.limits.MaxTotalSize = DefaultMaxTotalSize
+	}
+	if ex.limits.MaxEntries <= 0 {
+		ex.limits.MaxEntries = DefaultMaxEntries
+	}
+
+	var err error
+	switch d.Format {
+	case processor.FormatTar:
+		err = ex.extractTar(bytes.NewReader(d.Blob))
+	case processor.FormatZip:
+		err = ex.extractZip(d.Blob)
+	case processor.FormatGzip:
+		err = ex.extractGzip(d.Blob)
+	default:
+		return nil, fmt.Errorf("only accept tar, zip and gzip formats")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ex.entries, nil
+}
+
+func (ex *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read tar: %w", err)
+		}
+		if err := ex.countEntry(h.Name); err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := ex.add(h.Name, h.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (ex *extractor) extractZip(b []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return fmt.Errorf("unable to read zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if err := ex.countEntry(f.Name); err != nil {
+			return err
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if ex.scan {
+			if err := ex.account(int64(f.UncompressedSize64)); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("unable to open zip entry %s: %w", f.Name, err)
+		}
+		err = ex.add(f.Name, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractGzip decompresses a gzip blob, which is either a compressed
+// tarball or a single compressed file. In scan mode, only the gzip header
+// is checked.
+func (ex *extractor) extractGzip(b []byte) error {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unable to read gzip: %w", err)
+	}
+	defer gr.Close()
+	if ex.scan {
+		return ex.countEntry(gr.Name)
+	}
+
+	blob, err := ex.read(gr)
+	if err != nil {
+		return err
+	}
+	if guesser.GuessFormat(blob) == processor.FormatTar {
+		// the entries are accounted on top of the tarball, which is
+		// held in memory while they are read
+		return ex.extractTar(bytes.NewReader(blob))
+	}
+	if err := ex.countEntry(gr.Name); err != nil {
+		return err
+	}
+	ex.entries = append(ex.entries, entry{name: gr.Name, blob: blob})
+	return nil
+}
+
+func (ex *extractor) countEntry(name string) error {
+	ex.count++
+	if ex.count > ex.limits.MaxEntries {
+		return ErrEntriesExceeded
+	}
+	if name == "" {
+		return nil
+	}
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) {
+		return fmt.Errorf("%w: %s", ErrPathTraversal, name)
+	}
+	if c := path.Clean(name); c == ".." || strings.HasPrefix(c, "../") {
+		return fmt.Errorf("%w: %s", ErrPathTraversal, name)
+	}
+	return nil
+}
+
+// add reads the entry of the given header size, or only accounts the size
+// in scan mode
+func (ex *extractor) add(name string, size int64, r io.Reader) error {
+	if ex.scan {
+		return ex.account(size)
+	}
+	blob, err := ex.read(r)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", name, err)
+	}
+	ex.entries = append(ex.entries, entry{name: name, blob: blob})
+	return nil
+}
+
+// account adds size to the total, failing if the total size limit is
+// exceeded
+func (ex *extractor) account(size int64) error {
+	if size < 0 || size > ex.limits.MaxTotalSize-ex.total {
+		return ErrTotalSizeExceeded
+	}
+	ex.total += size
+	return nil
+}
+
+// read reads r fully, failing once the total size limit is reached
+func (ex *extractor) read(r io.Reader) ([]byte, error) {
+	remaining := ex.limits.MaxTotalSize - ex.total
+	blob, err := io.ReadAll(io.LimitReader(r, remaining+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(blob)) > remaining {
+		return nil, ErrTotalSizeExceeded
+	}
+	ex.total += int64(len(blob))
+	return blob, nil
+}
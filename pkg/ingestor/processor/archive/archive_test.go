@@ -0,0 +1,196 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+type file struct {
+	name string
+	body string
+}
+
+var (
+	spdxJSON = file{"sbom.spdx.json", `{"spdxVersion": "SPDX-2.3"}`}
+	cdxJSON  = file{"bom.json", `{"bomFormat": "CycloneDX"}`}
+	envelope = file{"att.dsse", `{"payloadType": "a", "payload": "", "signatures": []}`}
+)
+
+func makeTar(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, files ...file) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeGzip(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_ArchiveProcessor(t *testing.T) {
+	nestedZip := makeZip(t, spdxJSON)
+	testCases := []struct {
+		name          string
+		doc           processor.Document
+		limits        Limits
+		expectedTypes []processor.DocumentType
+		// expectedSources are the unpacked document sources, if checked
+		expectedSources []string
+		expectedErr     error
+		expectErr       bool
+	}{{
+		name: "tar",
+		doc: processor.Document{
+			Blob:              makeTar(t, spdxJSON, cdxJSON, envelope),
+			Format:            processor.FormatTar,
+			SourceInformation: processor.SourceInformation{Collector: "file", Source: "file:///sboms.tar"},
+		},
+		expectedTypes:   []processor.DocumentType{processor.DocumentSPDX, processor.DocumentCycloneDX, processor.DocumentDSSE},
+		expectedSources: []string{"file:///sboms.tar/sbom.spdx.json", "file:///sboms.tar/bom.json", "file:///sboms.tar/att.dsse"},
+	}, {
+		name: "tar.gz",
+		doc: processor.Document{
+			Blob:              makeGzip(t, makeTar(t, spdxJSON, cdxJSON)),
+			Format:            processor.FormatGzip,
+			SourceInformation: processor.SourceInformation{Source: "sboms.tar.gz"},
+		},
+		expectedTypes:   []processor.DocumentType{processor.DocumentSPDX, processor.DocumentCycloneDX},
+		expectedSources: []string{"sboms.tar.gz/sbom.spdx.json", "sboms.tar.gz/bom.json"},
+	}, {
+		name: "single gzip file",
+		doc: processor.Document{
+			Blob:              makeGzip(t, []byte(cdxJSON.body)),
+			Format:            processor.FormatGzip,
+			SourceInformation: processor.SourceInformation{Source: "bom.json.gz"},
+		},
+		expectedTypes:   []processor.DocumentType{processor.DocumentCycloneDX},
+		expectedSources: []string{"bom.json.gz"},
+	}, {
+		name:          "zip with nested zip",
+		doc:           processor.Document{Blob: makeZip(t, cdxJSON, file{"inner.zip", string(nestedZip)}), Format: processor.FormatZip},
+		expectedTypes: []processor.DocumentType{processor.DocumentCycloneDX, processor.DocumentArchive},
+	}, {
+		name:          "unknown member",
+		doc:           processor.Document{Blob: makeTar(t, file{"README", "hello"}), Format: processor.FormatTar},
+		expectedTypes: []processor.DocumentType{processor.DocumentUnknown},
+	}, {
+		name:        "tar path traversal",
+		doc:         processor.Document{Blob: makeTar(t, file{"../../etc/passwd", "x"}), Format: processor.FormatTar},
+		expectedErr: ErrPathTraversal,
+	}, {
+		name:        "zip absolute path",
+		doc:         processor.Document{Blob: makeZip(t, file{"/etc/passwd", "x"}), Format: processor.FormatZip},
+		expectedErr: ErrPathTraversal,
+	}, {
+		name:        "too many entries",
+		doc:         processor.Document{Blob: makeTar(t, spdxJSON, cdxJSON, envelope), Format: processor.FormatTar},
+		limits:      Limits{MaxEntries: 2},
+		expectedErr: ErrEntriesExceeded,
+	}, {
+		name:        "too large",
+		doc:         processor.Document{Blob: makeTar(t, spdxJSON, cdxJSON), Format: processor.FormatTar},
+		limits:      Limits{MaxTotalSize: 30},
+		expectedErr: ErrTotalSizeExceeded,
+	}, {
+		name:        "gzip bomb",
+		doc:         processor.Document{Blob: makeGzip(t, make([]byte, 1<<20)), Format: processor.FormatGzip},
+		limits:      Limits{MaxTotalSize: 1 << 10},
+		expectedErr: ErrTotalSizeExceeded,
+	}, {
+		name:      "not an archive",
+		doc:       processor.Document{Blob: []byte("not a zip"), Format: processor.FormatZip},
+		expectErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := NewArchiveProcessor(tt.limits)
+			var docs []*processor.Document
+			err := dp.ValidateSchema(&tt.doc)
+			if err == nil {
+				docs, err = dp.Unpack(&tt.doc)
+			}
+			if tt.expectedErr != nil || tt.expectErr {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("got error %v, expected %v", err, tt.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(docs) != len(tt.expectedTypes) {
+				t.Fatalf("got %v unpacked docs, expected %v", len(docs), len(tt.expectedTypes))
+			}
+			for i, d := range docs {
+				if d.Type != tt.expectedTypes[i] {
+					t.Errorf("doc %d: got type %v, expected %v", i, d.Type, tt.expectedTypes[i])
+				}
+				if tt.expectedSources != nil && d.SourceInformation.Source != tt.expectedSources[i] {
+					t.Errorf("doc %d: got source %v, expected %v", i, d.SourceInformation.Source, tt.expectedSources[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cyclonedx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// Media types of CycloneDX BOMs
+const (
+	MediaTypeJSON = "application/vnd.cyclonedx+json"
+	MediaTypeXML  = "application/vnd.cyclonedx+xml"
+)
+
+// supportedVersions are the CycloneDX specification versions accepted
+var supportedVersions = map[string]bool{
+	"1.4": true,
+	"1.5": true,
+	"1.6": true,
+}
+
+// BOM is the format independent model of a CycloneDX BOM
+type BOM struct {
+	SpecVersion  string
+	SerialNumber string
+	Version      int
+	// Metadata is the component the BOM describes, if any
+	Metadata     *Component
+	Components   []Component
+	Dependencies []Dependency
+}
+
+// Component is a CycloneDX component, which may contain sub-components
+type Component struct {
+	BOMRef     string
+	Type       string
+	Name       string
+	Version    string
+	PURL       string
+	Hashes     []Hash
+	Components []Component
+	// EmbeddedBOMs are the BOMs referenced by the component through
+	// "bom" external references with a data URL
+	EmbeddedBOMs []string
+}
+
+// Hash is a component hash, Algorithm is as named by CycloneDX (e.g. SHA-256)
+type Hash struct {
+	Algorithm string
+	Content   string
+}
+
+// Dependency lists the dependencies of the component with bom-ref Ref
+type Dependency struct {
+	Ref       string
+	DependsOn []string
+}
+
+// CycloneDXProcessor processes CycloneDX 1.4+ BOMs in JSON or XML format.
+// BOMs embedded in components as data URL "bom" external references are
+// unpacked as child documents, and the BOM embedding them is kept as well.
+type CycloneDXProcessor struct{}
+
+func (dp *CycloneDXProcessor) ValidateSchema(d *processor.Document) error {
+	_, err := ParseBOM(d)
+	return err
+}
+
+func (dp *CycloneDXProcessor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (dp *CycloneDXProcessor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	bom, err := ParseBOM(d)
+	if err != nil {
+		return nil, err
+	}
+
+	retDocs := []*processor.Document{}
+	var walk func(cs []Component) error
+	walk = func(cs []Component) error {
+		for _, c := range cs {
+			for _, u := range c.EmbeddedBOMs {
+				nd, err := decodeDataURL(u)
+				if err != nil {
+					return fmt.Errorf("component %s: %w", c.Name, err)
+				}
+				nd.TrustInformation = d.TrustInformation
+				retDocs = append(retDocs, nd)
+			}
+			if err := walk(c.Components); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if bom.Metadata != nil {
+		if err := walk([]Component{*bom.Metadata}); err != nil {
+			return nil, err
+		}
+	}
+	if err := walk(bom.Components); err != nil {
+		return nil, err
+	}
+	return retDocs, nil
+}
+
+// Embeds returns true, the documents unpacked from a BOM are embedded in it
+func (dp *CycloneDXProcessor) Embeds(d *processor.Document) bool {
+	return true
+}
+
+func (dp *CycloneDXProcessor) Describe() processor.Description {
+	versions := make([]string, 0, len(supportedVersions))
+	for v := range supportedVersions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return processor.Description{
+		Formats:    []processor.FormatType{processor.FormatJSON, processor.FormatXML},
+		Versions:   versions,
+		ChildTypes: []processor.DocumentType{processor.DocumentCycloneDX},
+	}
+}
+
+// ParseBOM parses and validates a CycloneDX BOM
+func ParseBOM(d *processor.Document) (*BOM, error) {
+	var (
+		bom *BOM
+		err error
+	)
+	switch d.Format {
+	case processor.FormatJSON:
+		bom, err = parseJSON(d.Blob)
+	case processor.FormatXML:
+		bom, err = parseXML(d.Blob)
+	default:
+		return nil, fmt.Errorf("only accept JSON and XML formats")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateBOM(bom); err != nil {
+		return nil, err
+	}
+	return bom, nil
+}
+
+func validateBOM(bom *BOM) error {
+	if !supportedVersions[bom.SpecVersion] {
+		return fmt.Errorf("unsupported CycloneDX spec version: %q", bom.SpecVersion)
+	}
+	if bom.SerialNumber != "" && !strings.HasPrefix(bom.SerialNumber, "urn:uuid:") {
+		return fmt.Errorf("serialNumber should be a urn:uuid: %q", bom.SerialNumber)
+	}
+	if bom.Version < 1 {
+		return fmt.Errorf("version should be at least 1")
+	}
+
+	refs := map[string]bool{}
+	var validate func(cs []Component) error
+	validate = func(cs []Component) error {
+		for _, c := range cs {
+			if c.Type == "" {
+				return fmt.Errorf("component %q type shouldn't be empty", c.Name)
+			}
+			if c.Name == "" {
+				return fmt.Errorf("component name shouldn't be empty")
+			}
+			if c.BOMRef != "" {
+				if refs[c.BOMRef] {
+					return fmt.Errorf("duplicate bom-ref: %q", c.BOMRef)
+				}
+				refs[c.BOMRef] = true
+			}
+			if err := validate(c.Components); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if bom.Metadata != nil {
+		if err := validate([]Component{*bom.Metadata}); err != nil {
+			return fmt.Errorf("metadata: %w", err)
+		}
+	}
+	if err := validate(bom.Components); err != nil {
+		return err
+	}
+
+	for _, dep := range bom.Dependencies {
+		if !refs[dep.Ref] {
+			return fmt.Errorf("dependency references unknown bom-ref: %q", dep.Ref)
+		}
+		for _, r := range dep.DependsOn {
+			if !refs[r] {
+				return fmt.Errorf("dependency references unknown bom-ref: %q", r)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeDataURL decodes an RFC 2397 data URL holding a CycloneDX BOM
+func decodeDataURL(u string) (*processor.Document, error) {
+	if !strings.HasPrefix(u, "data:") {
+		return nil, fmt.Errorf("not a data URL")
+	}
+	i := strings.Index(u, ",")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid data URL")
+	}
+	meta, data := u[len("data:"):i], u[i+1:]
+
+	var (
+		blob []byte
+		err  error
+	)
+	if strings.HasSuffix(meta, ";base64") {
+		meta = strings.TrimSuffix(meta, ";base64")
+		blob, err = base64.StdEncoding.DecodeString(data)
+	} else {
+		var s string
+		s, err = url.PathUnescape(data)
+		blob = []byte(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode data URL: %w", err)
+	}
+
+	format := processor.FormatJSON
+	switch mediaType := strings.Split(meta, ";")[0]; mediaType {
+	case MediaTypeXML:
+		format = processor.FormatXML
+	case MediaTypeJSON:
+	default:
+		if bytes.HasPrefix(bytes.TrimSpace(blob), []byte("<")) {
+			format = processor.FormatXML
+		}
+	}
+	return &processor.Document{
+		Blob:   blob,
+		Type:   processor.DocumentCycloneDX,
+		Format: format,
+	}, nil
+}
+
+func isDataURL(u string) bool {
+	return strings.HasPrefix(u, "data:")
+}
@@ -0,0 +1,180 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cyclonedx
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+const (
+	innerJSON = `{"bomFormat": "CycloneDX", "specVersion": "1.4", "version": 1,
+		"components": [{"type": "library", "name": "inner"}]}`
+
+	jsonBOMDoc = `{
+		"bomFormat": "CycloneDX",
+		"specVersion": "1.4",
+		"serialNumber": "urn:uuid:3e671687-395b-41f5-a30f-a58921a69b79",
+		"version": 1,
+		"metadata": {"component": {"bom-ref": "app", "type": "application", "name": "app"}},
+		"components": [{
+			"bom-ref": "pkg:golang/github.com/sirupsen/logrus@v1.4.2",
+			"type": "library",
+			"name": "logrus",
+			"version": "v1.4.2",
+			"purl": "pkg:golang/github.com/sirupsen/logrus@v1.4.2",
+			"hashes": [{"alg": "SHA-256", "content": "abcd"}],
+			"components": [{
+				"type": "library",
+				"name": "vendored",
+				"externalReferences": [{"type": "bom", "url": "data:application/vnd.cyclonedx+json;base64,` +
+		"%s" + `"}]
+			}]
+		}],
+		"dependencies": [{"ref": "app", "dependsOn": ["pkg:golang/github.com/sirupsen/logrus@v1.4.2"]}]
+	}`
+
+	xmlBOMDoc = `<?xml version="1.0" encoding="UTF-8"?>
+<bom xmlns="http://cyclonedx.org/schema/bom/1.4" serialNumber="urn:uuid:3e671687-395b-41f5-a30f-a58921a69b79" version="1">
+  <metadata>
+    <component type="application" bom-ref="app"><name>app</name></component>
+  </metadata>
+  <components>
+    <component type="library" bom-ref="pkg:golang/github.com/sirupsen/logrus@v1.4.2">
+      <name>logrus</name>
+      <version>v1.4.2</version>
+      <purl>pkg:golang/github.com/sirupsen/logrus@v1.4.2</purl>
+      <hashes><hash alg="SHA-256">abcd</hash></hashes>
+      <components>
+        <component type="library">
+          <name>vendored</name>
+          <externalReferences>
+            <reference type="bom"><url>data:application/vnd.cyclonedx+json;base64,%s</url></reference>
+          </externalReferences>
+        </component>
+      </components>
+    </component>
+  </components>
+  <dependencies>
+    <dependency ref="app"><dependency ref="pkg:golang/github.com/sirupsen/logrus@v1.4.2"/></dependency>
+  </dependencies>
+</bom>`
+)
+
+func withInner(s string) []byte {
+	return []byte(fmt.Sprintf(s, base64.StdEncoding.EncodeToString([]byte(innerJSON))))
+}
+
+func Test_CycloneDXProcessor(t *testing.T) {
+	embedded := "data:application/vnd.cyclonedx+json;base64," + base64.StdEncoding.EncodeToString([]byte(innerJSON))
+	expected := &BOM{
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:3e671687-395b-41f5-a30f-a58921a69b79",
+		Version:      1,
+		Metadata:     &Component{BOMRef: "app", Type: "application", Name: "app"},
+		Components: []Component{{
+			BOMRef:  "pkg:golang/github.com/sirupsen/logrus@v1.4.2",
+			Type:    "library",
+			Name:    "logrus",
+			Version: "v1.4.2",
+			PURL:    "pkg:golang/github.com/sirupsen/logrus@v1.4.2",
+			Hashes:  []Hash{{Algorithm: "SHA-256", Content: "abcd"}},
+			Components: []Component{{
+				Type:         "library",
+				Name:         "vendored",
+				EmbeddedBOMs: []string{embedded},
+			}},
+		}},
+		Dependencies: []Dependency{{
+			Ref:       "app",
+			DependsOn: []string{"pkg:golang/github.com/sirupsen/logrus@v1.4.2"},
+		}},
+	}
+
+	testCases := []struct {
+		name      string
+		doc       processor.Document
+		expectErr bool
+	}{{
+		name: "json",
+		doc:  processor.Document{Blob: withInner(jsonBOMDoc), Format: processor.FormatJSON},
+	}, {
+		name: "xml",
+		doc:  processor.Document{Blob: withInner(xmlBOMDoc), Format: processor.FormatXML},
+	}, {
+		name:      "wrong bomFormat",
+		doc:       processor.Document{Blob: []byte(`{"bomFormat": "SPDX", "specVersion": "1.4", "version": 1}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name:      "unsupported version",
+		doc:       processor.Document{Blob: []byte(`{"bomFormat": "CycloneDX", "specVersion": "1.2", "version": 1}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name: "component without name",
+		doc: processor.Document{Blob: []byte(`{"bomFormat": "CycloneDX", "specVersion": "1.4", "version": 1,
+			"components": [{"type": "library", "components": [{"type": "library"}], "name": "a"}]}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name: "unknown dependency",
+		doc: processor.Document{Blob: []byte(`{"bomFormat": "CycloneDX", "specVersion": "1.4", "version": 1,
+			"components": [{"type": "library", "name": "a", "bom-ref": "a"}],
+			"dependencies": [{"ref": "a", "dependsOn": ["b"]}]}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name: "xml wrong namespace",
+		doc: processor.Document{Blob: []byte(`<bom xmlns="http://example.com/bom" version="1"></bom>`),
+			Format: processor.FormatXML},
+		expectErr: true,
+	}}
+
+	dp := &CycloneDXProcessor{}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dp.ValidateSchema(&tt.doc)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			bom, err := ParseBOM(&tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bom, expected) {
+				t.Errorf("got BOM %+v, expected %+v", bom, expected)
+			}
+
+			docs, err := dp.Unpack(&tt.doc)
+			if err != nil {
+				t.Fatalf("unexpected unpack error: %v", err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("got %v unpacked docs, expected 1", len(docs))
+			}
+			if docs[0].Type != processor.DocumentCycloneDX || docs[0].Format != processor.FormatJSON {
+				t.Errorf("got type %v format %v, expected CycloneDX JSON", docs[0].Type, docs[0].Format)
+			}
+			if string(docs[0].Blob) != innerJSON {
+				t.Errorf("got embedded BOM %s, expected %s", docs[0].Blob, innerJSON)
+			}
+		})
+	}
+}
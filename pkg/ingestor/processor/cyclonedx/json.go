@@ -0,0 +1,106 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cyclonedx
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type jsonBOM struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     *struct {
+		Component *jsonComponent `json:"component"`
+	} `json:"metadata"`
+	Components   []jsonComponent `json:"components"`
+	Dependencies []struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	} `json:"dependencies"`
+}
+
+type jsonComponent struct {
+	BOMRef  string `json:"bom-ref"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	PURL    string `json:"purl"`
+	Hashes  []struct {
+		Alg     string `json:"alg"`
+		Content string `json:"content"`
+	} `json:"hashes"`
+	Components         []jsonComponent `json:"components"`
+	ExternalReferences []struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"externalReferences"`
+}
+
+func parseJSON(b []byte) (*BOM, error) {
+	var jb jsonBOM
+	if err := json.Unmarshal(b, &jb); err != nil {
+		return nil, err
+	}
+	if jb.BOMFormat != "CycloneDX" {
+		return nil, fmt.Errorf("bomFormat should be CycloneDX")
+	}
+
+	bom := &BOM{
+		SpecVersion:  jb.SpecVersion,
+		SerialNumber: jb.SerialNumber,
+		Version:      jb.Version,
+		Components:   convertJSONComponents(jb.Components),
+	}
+	if jb.Metadata != nil && jb.Metadata.Component != nil {
+		c := convertJSONComponent(*jb.Metadata.Component)
+		bom.Metadata = &c
+	}
+	for _, d := range jb.Dependencies {
+		bom.Dependencies = append(bom.Dependencies, Dependency{Ref: d.Ref, DependsOn: d.DependsOn})
+	}
+	return bom, nil
+}
+
+func convertJSONComponents(jcs []jsonComponent) []Component {
+	var cs []Component
+	for _, jc := range jcs {
+		cs = append(cs, convertJSONComponent(jc))
+	}
+	return cs
+}
+
+func convertJSONComponent(jc jsonComponent) Component {
+	c := Component{
+		BOMRef:     jc.BOMRef,
+		Type:       jc.Type,
+		Name:       jc.Name,
+		Version:    jc.Version,
+		PURL:       jc.PURL,
+		Components: convertJSONComponents(jc.Components),
+	}
+	for _, h := range jc.Hashes {
+		c.Hashes = append(c.Hashes, Hash{Algorithm: h.Alg, Content: h.Content})
+	}
+	for _, ref := range jc.ExternalReferences {
+		if ref.Type == "bom" && isDataURL(ref.URL) {
+			c.EmbeddedBOMs = append(c.EmbeddedBOMs, ref.URL)
+		}
+	}
+	return c
+}
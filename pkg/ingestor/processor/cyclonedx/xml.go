@@ -0,0 +1,117 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cyclonedx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// xmlNamespacePrefix is the prefix of the CycloneDX XML namespace, which
+// is followed by the spec version
+const xmlNamespacePrefix = "http://cyclonedx.org/schema/bom/"
+
+type xmlBOM struct {
+	XMLName      xml.Name `xml:"bom"`
+	SerialNumber string   `xml:"serialNumber,attr"`
+	Version      int      `xml:"version,attr"`
+	Metadata     *struct {
+		Component *xmlComponent `xml:"component"`
+	} `xml:"metadata"`
+	Components   []xmlComponent `xml:"components>component"`
+	Dependencies []struct {
+		Ref       string `xml:"ref,attr"`
+		DependsOn []struct {
+			Ref string `xml:"ref,attr"`
+		} `xml:"dependency"`
+	} `xml:"dependencies>dependency"`
+}
+
+type xmlComponent struct {
+	BOMRef  string `xml:"bom-ref,attr"`
+	Type    string `xml:"type,attr"`
+	Name    string `xml:"name"`
+	Version string `xml:"version"`
+	PURL    string `xml:"purl"`
+	Hashes  []struct {
+		Alg     string `xml:"alg,attr"`
+		Content string `xml:",chardata"`
+	} `xml:"hashes>hash"`
+	Components         []xmlComponent `xml:"components>component"`
+	ExternalReferences []struct {
+		Type string `xml:"type,attr"`
+		URL  string `xml:"url"`
+	} `xml:"externalReferences>reference"`
+}
+
+func parseXML(b []byte) (*BOM, error) {
+	var xb xmlBOM
+	if err := xml.Unmarshal(b, &xb); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(xb.XMLName.Space, xmlNamespacePrefix) {
+		return nil, fmt.Errorf("unexpected XML namespace: %q", xb.XMLName.Space)
+	}
+
+	bom := &BOM{
+		SpecVersion:  strings.TrimPrefix(xb.XMLName.Space, xmlNamespacePrefix),
+		SerialNumber: xb.SerialNumber,
+		Version:      xb.Version,
+		Components:   convertXMLComponents(xb.Components),
+	}
+	if xb.Metadata != nil && xb.Metadata.Component != nil {
+		c := convertXMLComponent(*xb.Metadata.Component)
+		bom.Metadata = &c
+	}
+	for _, d := range xb.Dependencies {
+		dep := Dependency{Ref: d.Ref}
+		for _, on := range d.DependsOn {
+			dep.DependsOn = append(dep.DependsOn, on.Ref)
+		}
+		bom.Dependencies = append(bom.Dependencies, dep)
+	}
+	return bom, nil
+}
+
+func convertXMLComponents(xcs []xmlComponent) []Component {
+	var cs []Component
+	for _, xc := range xcs {
+		cs = append(cs, convertXMLComponent(xc))
+	}
+	return cs
+}
+
+func convertXMLComponent(xc xmlComponent) Component {
+	c := Component{
+		BOMRef:     xc.BOMRef,
+		Type:       xc.Type,
+		Name:       xc.Name,
+		Version:    xc.Version,
+		PURL:       xc.PURL,
+		Components: convertXMLComponents(xc.Components),
+	}
+	for _, h := range xc.Hashes {
+		c.Hashes = append(c.Hashes, Hash{Algorithm: h.Alg, Content: strings.TrimSpace(h.Content)})
+	}
+	for _, ref := range xc.ExternalReferences {
+		u := strings.TrimSpace(ref.URL)
+		if ref.Type == "bom" && isDataURL(u) {
+			c.EmbeddedBOMs = append(c.EmbeddedBOMs, u)
+		}
+	}
+	return c
+}
@@ -0,0 +1,61 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+// Describer is optionally implemented by a DocumentProcessor to describe
+// the documents it supports
+type Describer interface {
+	Describe() Description
+}
+
+// LogEntrySubject is optionally implemented by a DocumentProcessor whose
+// documents have a transparency log entry about another blob than the
+// document itself, such as the payload of a signed envelope
+type LogEntrySubject interface {
+	// LogEntryBlob returns the blob the log entry of the document is about
+	LogEntryBlob(d *Document) ([]byte, error)
+}
+
+// Embedder is optionally implemented by a DocumentProcessor whose documents
+// are leaf documents themselves, besides the documents embedded in them
+// that Unpack returns, such as BOMs embedding other BOMs
+type Embedder interface {
+	// Embeds returns whether the documents unpacked from d are embedded in
+	// d, so that d is kept along with them
+	Embeds(d *Document) bool
+}
+
+// Description describes the documents supported by a DocumentProcessor
+type Description struct {
+	// Formats are the formats of the documents the processor accepts
+	Formats []FormatType
+	// Versions are the specification versions the processor accepts, in
+	// the notation of the specification
+	Versions []string
+	// ChildTypes are the types of the documents the processor unpacks to.
+	// DocumentUnknown means documents whose type is guessed when processed.
+	ChildTypes []DocumentType
+}
+
+// SupportsFormat returns whether the format is one of the Formats
+func (d Description) SupportsFormat(f FormatType) bool {
+	for _, s := range d.Formats {
+		if s == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsse
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Fulcio certificate extensions holding the OIDC issuer of the identity
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// CertificateIdentity is the identity of a signing certificate
+type CertificateIdentity struct {
+	// SAN is the first URI or email subject alternative name
+	SAN string
+	// Issuer is the OIDC issuer of the identity, from the Fulcio issuer
+	// certificate extension
+	Issuer string
+}
+
+// CertificateVerifier verifies signatures made with the key of a leaf
+// certificate chaining up to a configured root.
+type CertificateVerifier struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+}
+
+// NewCertificateVerifier creates a CertificateVerifier from a PEM bundle of
+// root and intermediate certificates. Self-signed certificates are used as
+// roots, others as intermediates.
+func NewCertificateVerifier(bundle []byte) (*CertificateVerifier, error) {
+	certs, err := ParseCertificates(bundle)
+	if err != nil {
+		return nil, err
+	}
+	cv := &CertificateVerifier{
+		roots:         x509.NewCertPool(),
+		intermediates: x509.NewCertPool(),
+	}
+	var roots int
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil {
+			cv.roots.AddCert(c)
+			roots++
+		} else {
+			cv.intermediates.AddCert(c)
+		}
+	}
+	if roots == 0 {
+		return nil, fmt.Errorf("no root certificate in bundle")
+	}
+	return cv, nil
+}
+
+// ParseCertificates parses a PEM encoded list of certificates
+func ParseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// Verify verifies the signature of data with the key of the first
+// certificate of the chain, and that this certificate is valid for code
+// signing at the given time and chains up to a configured root. The other
+// certificates of the chain are used as intermediates.
+func (cv *CertificateVerifier) Verify(data, sig []byte, chain []*x509.Certificate, at time.Time) (*CertificateIdentity, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no signing certificate")
+	}
+	leaf := chain[0]
+
+	intermediates := cv.intermediates.Clone()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         cv.roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+
+	v, err := NewVerifier("", leaf.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Verify(data, sig); err != nil {
+		return nil, err
+	}
+	return certificateIdentity(leaf)
+}
+
+func certificateIdentity(c *x509.Certificate) (*CertificateIdentity, error) {
+	id := &CertificateIdentity{}
+	switch {
+	case len(c.URIs) > 0:
+		id.SAN = c.URIs[0].String()
+	case len(c.EmailAddresses) > 0:
+		id.SAN = c.EmailAddresses[0]
+	default:
+		return nil, fmt.Errorf("signing certificate has no URI or email subject alternative name")
+	}
+
+	for _, ext := range c.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			if _, err := asn1.Unmarshal(ext.Value, &id.Issuer); err != nil {
+				return nil, fmt.Errorf("invalid certificate issuer extension: %w", err)
+			}
+		case ext.Id.Equal(oidIssuerV1) && id.Issuer == "":
+			id.Issuer = string(ext.Value)
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,353 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsse
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string, parent *testCA) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a short lived code signing certificate for the identity,
+// valid from notBefore
+func (ca *testCA) issue(t *testing.T, san, issuer string, notBefore time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(san)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerExt, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(time.Now().UnixNano()),
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{u},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuerExt}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func pemCerts(certs ...*x509.Certificate) []byte {
+	var b []byte
+	for _, c := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return b
+}
+
+// certSignedDoc returns a DSSE envelope signed with the key, with the
+// certificate chain embedded in the signature if embed is true
+func certSignedDoc(t *testing.T, key *ecdsa.PrivateKey, chain []byte, embed bool) *processor.Document {
+	d := signedDoc(t, newTestSigner(t, key), PayloadTypeInToto, []byte(`{"_type": "https://in-toto.io/Statement/v0.1"}`))
+	if !embed {
+		return d
+	}
+	var env struct {
+		PayloadType string      `json:"payloadType"`
+		Payload     string      `json:"payload"`
+		Signatures  []signature `json:"signatures"`
+	}
+	if err := json.Unmarshal(d.Blob, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.Signatures[0].Cert = string(chain)
+	b, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Blob = b
+	return d
+}
+
+// logEntry returns a hashedrekord log entry about the blob signed with the
+// certificate, integrated at the given time and signed by the log key
+func logEntry(t *testing.T, key *ecdsa.PrivateKey, blob []byte, cert *x509.Certificate, integrated time.Time) *tlog.LogEntry {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	digest := sha256.Sum256(blob)
+	e := &tlog.LogEntry{
+		Body: base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(
+			`{"kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"%s"}},"signature":{"publicKey":{"content":"%s"}}}}`,
+			hex.EncodeToString(digest[:]), base64.StdEncoding.EncodeToString(pemCerts(cert))))),
+		IntegratedTime: integrated.Unix(),
+		LogID:          hex.EncodeToString(logID[:]),
+		LogIndex:       1,
+	}
+	payload, err := cjson.EncodeCanonical(map[string]interface{}{
+		"body":           e.Body,
+		"integratedTime": e.IntegratedTime,
+		"logID":          e.LogID,
+		"logIndex":       e.LogIndex,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(payload)
+	sig, err := key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Verification = &tlog.Verification{SignedEntryTimestamp: base64.StdEncoding.EncodeToString(sig)}
+	return e
+}
+
+func Test_CertificateVerification(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	intermediate := newTestCA(t, "intermediate", root)
+	otherRoot := newTestCA(t, "other", nil)
+
+	const (
+		san    = "https://github.com/org/repo/.github/workflows/release.yml@refs/heads/main"
+		issuer = "https://token.actions.githubusercontent.com"
+	)
+	now := time.Now().Add(-time.Minute)
+	leaf, key := intermediate.issue(t, san, issuer, now)
+	expiredLeaf, expiredKey := intermediate.issue(t, san, issuer, now.Add(-30*time.Minute))
+	otherLeaf, otherKey := otherRoot.issue(t, san, issuer, now)
+	expiredDoc := certSignedDoc(t, expiredKey, pemCerts(expiredLeaf, intermediate.cert), true)
+
+	cv, err := NewCertificateVerifier(pemCerts(root.cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := tlog.NewVerifier(logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loggedEntry := logEntry(t, logKey, expiredDoc.Blob, expiredLeaf, now.Add(-25*time.Minute))
+	forgedEntry := logEntry(t, logKey, expiredDoc.Blob, expiredLeaf, now.Add(-25*time.Minute))
+	otherSignerEntry := logEntry(t, logKey, expiredDoc.Blob, leaf, now.Add(-25*time.Minute))
+	forgedEntry.IntegratedTime = now.Add(-26 * time.Minute).Unix()
+	if _, err := NewCertificateVerifier(pemCerts(intermediate.cert)); err == nil {
+		t.Errorf("expected error for bundle without root")
+	}
+
+	testCases := []struct {
+		name         string
+		doc          *processor.Document
+		trustInfo    processor.TrustInformation
+		verifyLog    bool
+		expectIssuer string
+		expectSANs   []string
+		expectErr    bool
+	}{{
+		name:         "embedded certificate chain",
+		doc:          certSignedDoc(t, key, pemCerts(leaf, intermediate.cert), true),
+		expectIssuer: issuer,
+		expectSANs:   []string{san},
+	}, {
+		name:         "certificate chain from trust information",
+		doc:          certSignedDoc(t, key, nil, false),
+		trustInfo:    processor.TrustInformation{Certificate: pemCerts(leaf, intermediate.cert)},
+		expectIssuer: issuer,
+		expectSANs:   []string{san},
+	}, {
+		name:      "missing intermediate",
+		doc:       certSignedDoc(t, key, pemCerts(leaf), true),
+		expectErr: true,
+	}, {
+		name:      "certificate of another root",
+		doc:       certSignedDoc(t, otherKey, pemCerts(otherLeaf), true),
+		expectErr: true,
+	}, {
+		name:      "signature by another key",
+		doc:       certSignedDoc(t, otherKey, pemCerts(leaf, intermediate.cert), true),
+		expectErr: true,
+	}, {
+		name:      "expired certificate",
+		doc:       certSignedDoc(t, expiredKey, pemCerts(expiredLeaf, intermediate.cert), true),
+		expectErr: true,
+	}, {
+		name:         "expired certificate valid when logged",
+		doc:          expiredDoc,
+		trustInfo:    processor.TrustInformation{LogEntry: loggedEntry},
+		verifyLog:    true,
+		expectIssuer: issuer,
+		expectSANs:   []string{san},
+	}, {
+		name:      "expired certificate with log entry but no log verifier",
+		doc:       expiredDoc,
+		trustInfo: processor.TrustInformation{LogEntry: loggedEntry},
+		expectErr: true,
+	}, {
+		name:      "expired certificate with forged integrated time",
+		doc:       expiredDoc,
+		trustInfo: processor.TrustInformation{LogEntry: forgedEntry},
+		verifyLog: true,
+		expectErr: true,
+	}, {
+		name:      "expired certificate with log entry of another signer",
+		doc:       expiredDoc,
+		trustInfo: processor.TrustInformation{LogEntry: otherSignerEntry},
+		verifyLog: true,
+		expectErr: true,
+	}, {
+		name: "expired certificate with unsigned log entry",
+		doc:  expiredDoc,
+		trustInfo: processor.TrustInformation{
+			LogEntry: &tlog.LogEntry{IntegratedTime: now.Add(-25 * time.Minute).Unix()},
+		},
+		verifyLog: true,
+		expectErr: true,
+	}, {
+		name:      "conflicting issuer",
+		doc:       certSignedDoc(t, key, pemCerts(leaf, intermediate.cert), true),
+		trustInfo: processor.TrustInformation{IssuerUri: ptrStr("https://accounts.google.com")},
+		expectErr: true,
+	}, {
+		name:      "no certificate",
+		doc:       certSignedDoc(t, key, nil, false),
+		expectErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.doc
+			d.TrustInformation = tt.trustInfo
+			dp := NewDSSEProcessor().WithCertificateVerifier(cv)
+			if tt.verifyLog {
+				dp.WithLogVerifier(lv)
+			}
+			trustInfo, err := dp.ValidateTrustInformation(d)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("ValidateTrustInformation() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if d.TrustInformation.IssuerUri == nil || *d.TrustInformation.IssuerUri != tt.expectIssuer {
+				t.Errorf("got issuer %v, expected %s", d.TrustInformation.IssuerUri, tt.expectIssuer)
+			}
+			if sans, _ := trustInfo["dsse_cert_identities"].([]string); !reflect.DeepEqual(sans, tt.expectSANs) {
+				t.Errorf("got identities %v, expected %v", trustInfo["dsse_cert_identities"], tt.expectSANs)
+			}
+			certs, _ := trustInfo[TrustInfoCertificates].([]CertificateIdentity)
+			for i, c := range certs {
+				if i >= len(tt.expectSANs) || c.SAN != tt.expectSANs[i] || c.Issuer != tt.expectIssuer {
+					t.Errorf("unexpected certificate identity %+v", c)
+				}
+			}
+			if len(certs) != len(tt.expectSANs) {
+				t.Errorf("got %v certificate identities, expected %v", len(certs), len(tt.expectSANs))
+			}
+		})
+	}
+}
+
+func Test_CertificateAndKeyVerification(t *testing.T) {
+	root := newTestCA(t, "root", nil)
+	_, key := root.issue(t, "mailto:someone@example.com", "https://accounts.google.com", time.Now())
+	cv, err := NewCertificateVerifier(pemCerts(root.cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a key signature is accepted without certificate
+	s := newTestSigner(t, key)
+	d := certSignedDoc(t, key, nil, false)
+	dp := NewDSSEProcessor(s.Verifier).WithCertificateVerifier(cv)
+	trustInfo, err := dp.ValidateTrustInformation(d)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyID, _ := s.KeyID()
+	if ids := trustInfo["dsse_keyids"].([]string); len(ids) != 1 || ids[0] != keyID {
+		t.Errorf("unexpected key ids %v", ids)
+	}
+	if _, ok := trustInfo["dsse_cert_identities"]; ok {
+		t.Errorf("unexpected certificate identities")
+	}
+}
+
+func ptrStr(s string) *string {
+	return &s
+}
@@ -0,0 +1,326 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsse
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// PayloadTypeInToto is the DSSE payload type of in-toto statements
+const PayloadTypeInToto = "application/vnd.in-toto+json"
+
+// TrustInfoCertificates is the trust information key of the identities of
+// the verified signing certificates, as a []CertificateIdentity
+const TrustInfoCertificates = "dsse_certificates"
+
+// payloadTypes maps DSSE payload types to the document type of the payload
+var payloadTypes = map[string]processor.DocumentType{
+	PayloadTypeInToto: processor.DocumentITE6,
+}
+
+// PayloadDocumentType returns the document type of a DSSE payload type
+func PayloadDocumentType(payloadType string) processor.DocumentType {
+	t, ok := payloadTypes[payloadType]
+	if !ok {
+		return processor.DocumentUnknown
+	}
+	return t
+}
+
+// PayloadDocumentTypes returns the document types DSSE payloads are
+// unpacked as, sorted
+func PayloadDocumentTypes() []processor.DocumentType {
+	types := []processor.DocumentType{processor.DocumentUnknown}
+	for _, t := range payloadTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// DSSEProcessor processes DSSE envelopes. The envelope signatures are
+// verified against the configured verifiers, at least one of which must
+// accept a signature for the envelope to be trusted.
+//
+// Keyless signatures are verified against their signing certificate, given
+// in the "cert" field of the signature or in the document TrustInformation,
+// if a CertificateVerifier is configured. Certificates are verified now,
+// or at the integrated time of the document log entry if it is verified
+// against the log of the configured log verifier. The OIDC issuer of the
+// certificates, if they all agree on it, is used as IssuerUri of the
+// document.
+//
+// The payload of the envelope is unpacked as a child document whose type
+// is derived from the envelope payload type.
+type DSSEProcessor struct {
+	verifiers    []dsse.Verifier
+	certVerifier *CertificateVerifier
+	logVerifier  *tlog.Verifier
+}
+
+// NewDSSEProcessor creates a DSSE processor verifying envelopes against
+// the given verifiers (see NewVerifier).
+func NewDSSEProcessor(verifiers ...dsse.Verifier) *DSSEProcessor {
+	return &DSSEProcessor{verifiers: verifiers}
+}
+
+// WithCertificateVerifier sets the verifier of keyless signatures
+func (dp *DSSEProcessor) WithCertificateVerifier(cv *CertificateVerifier) *DSSEProcessor {
+	dp.certVerifier = cv
+	return dp
+}
+
+// WithLogVerifier sets the verifier of the log entries dating keyless
+// signatures
+func (dp *DSSEProcessor) WithLogVerifier(v *tlog.Verifier) *DSSEProcessor {
+	dp.logVerifier = v
+	return dp
+}
+
+// LogVerifier returns the verifier of log entries, nil if not set
+func (dp *DSSEProcessor) LogVerifier() *tlog.Verifier {
+	return dp.logVerifier
+}
+
+// signature is a DSSE envelope signature, with the optional PEM encoded
+// certificate chain of the signing key
+type signature struct {
+	Sig  string `json:"sig"`
+	Cert string `json:"cert"`
+}
+
+func (dp *DSSEProcessor) ValidateSchema(d *processor.Document) error {
+	_, err := parseEnvelope(d)
+	return err
+}
+
+// ValidateTrustInformation verifies the envelope signatures and fills
+// in the DSSE envelope of the document TrustInformation.
+func (dp *DSSEProcessor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	env, err := parseEnvelope(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dp.verifiers) == 0 && dp.certVerifier == nil {
+		return nil, fmt.Errorf("no verifiers configured for DSSE envelope")
+	}
+
+	keyIDs, keyErr := dp.verifyKeys(env)
+	ids, certErr := dp.verifyCertificates(d, env)
+	if len(keyIDs) == 0 && len(ids) == 0 {
+		if keyErr == nil {
+			keyErr = certErr
+		}
+		return nil, fmt.Errorf("unable to verify DSSE envelope: %w", keyErr)
+	}
+
+	trustInfo := map[string]interface{}{
+		"dsse_keyids": keyIDs,
+	}
+	if len(ids) > 0 {
+		sans := make([]string, len(ids))
+		certs := make([]CertificateIdentity, len(ids))
+		issuer := ids[0].Issuer
+		for i, id := range ids {
+			if id.Issuer != "" && d.TrustInformation.IssuerUri != nil && *d.TrustInformation.IssuerUri != id.Issuer {
+				return nil, fmt.Errorf("certificate issuer %s doesn't match issuer %s", id.Issuer, *d.TrustInformation.IssuerUri)
+			}
+			if id.Issuer != issuer {
+				issuer = ""
+			}
+			sans[i] = id.SAN
+			certs[i] = *id
+		}
+		trustInfo["dsse_cert_identities"] = sans
+		trustInfo[TrustInfoCertificates] = certs
+
+		if issuer != "" {
+			d.TrustInformation.IssuerUri = &issuer
+		}
+	}
+	d.TrustInformation.DSSE = env
+
+	return trustInfo, nil
+}
+
+// verifyKeys returns the key IDs of the configured verifiers accepting a
+// signature of the envelope
+func (dp *DSSEProcessor) verifyKeys(env *dsse.Envelope) ([]string, error) {
+	if len(dp.verifiers) == 0 {
+		return []string{}, nil
+	}
+	// The envelope verifier mutates its list of verifiers while verifying,
+	// so a new one is created on every call.
+	verifiers := make([]dsse.Verifier, len(dp.verifiers))
+	copy(verifiers, dp.verifiers)
+	ev, err := dsse.NewEnvelopeVerifier(verifiers...)
+	if err != nil {
+		return []string{}, err
+	}
+	accepted, err := ev.Verify(env)
+	if err != nil {
+		return []string{}, err
+	}
+
+	keyIDs := make([]string, len(accepted))
+	for i, k := range accepted {
+		keyIDs[i] = k.KeyID
+	}
+	return keyIDs, nil
+}
+
+// verifyCertificates returns the identities of the signing certificates of
+// the valid signatures of the envelope, see certificateTime for the time
+// certificates are verified at.
+func (dp *DSSEProcessor) verifyCertificates(d *processor.Document, env *dsse.Envelope) ([]*CertificateIdentity, error) {
+	if dp.certVerifier == nil {
+		return nil, nil
+	}
+	var raw struct {
+		Signatures []signature `json:"signatures"`
+	}
+	if err := json.Unmarshal(d.Blob, &raw); err != nil {
+		return nil, err
+	}
+	payload, err := env.DecodeB64Payload()
+	if err != nil {
+		return nil, err
+	}
+	pae := dsse.PAE(env.PayloadType, payload)
+
+	var (
+		ids     []*CertificateIdentity
+		lastErr = fmt.Errorf("no signing certificate")
+	)
+	for _, s := range raw.Signatures {
+		certPEM := []byte(s.Cert)
+		if len(certPEM) == 0 {
+			certPEM = d.TrustInformation.Certificate
+		}
+		if len(certPEM) == 0 {
+			continue
+		}
+		chain, err := ParseCertificates(certPEM)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		at, err := dp.certificateTime(d, payload, sig, chain[0])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		id, err := dp.certVerifier.Verify(pae, sig, chain, at)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, lastErr
+	}
+	return ids, nil
+}
+
+// certificateTime returns the integrated time of the document log entry,
+// if the log entry is verified against the configured log verifier, as
+// being about either the envelope or its payload, and as recording the
+// signature or the leaf certificate being verified. Without log verifier
+// or log entry, the time is now, and an entry failing verification is an
+// error.
+func (dp *DSSEProcessor) certificateTime(d *processor.Document, payload, sig []byte, leaf *x509.Certificate) (time.Time, error) {
+	e := d.TrustInformation.LogEntry
+	if e == nil || dp.logVerifier == nil {
+		return time.Now(), nil
+	}
+	if err := dp.logVerifier.Verify(e, payload); err != nil {
+		if dp.logVerifier.Verify(e, d.Blob) != nil {
+			return time.Time{}, fmt.Errorf("unable to verify log entry: %w", err)
+		}
+	}
+	if err := tlog.VerifySigner(e, sig, leaf); err != nil {
+		return time.Time{}, fmt.Errorf("unable to verify log entry: %w", err)
+	}
+	return time.Unix(e.IntegratedTime, 0), nil
+}
+
+func (dp *DSSEProcessor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	env, err := parseEnvelope(d)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := env.DecodeB64Payload()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode DSSE payload: %w", err)
+	}
+
+	trustInfo := d.TrustInformation
+	trustInfo.DSSE = env
+	return []*processor.Document{{
+		Blob:             payload,
+		Type:             PayloadDocumentType(env.PayloadType),
+		Format:           processor.FormatJSON,
+		TrustInformation: trustInfo,
+	}}, nil
+}
+
+func (dp *DSSEProcessor) Describe() processor.Description {
+	return processor.Description{
+		Formats:    []processor.FormatType{processor.FormatJSON},
+		ChildTypes: PayloadDocumentTypes(),
+	}
+}
+
+func parseEnvelope(d *processor.Document) (*dsse.Envelope, error) {
+	if d.Format != processor.FormatJSON {
+		return nil, fmt.Errorf("only accept JSON formats")
+	}
+
+	var env dsse.Envelope
+	if err := json.Unmarshal(d.Blob, &env); err != nil {
+		return nil, err
+	}
+	if env.PayloadType == "" {
+		return nil, fmt.Errorf("DSSE envelope payloadType shouldn't be empty")
+	}
+	if env.Payload == "" {
+		return nil, fmt.Errorf("DSSE envelope payload shouldn't be empty")
+	}
+	if len(env.Signatures) == 0 {
+		return nil, dsse.ErrNoSignature
+	}
+	if _, err := env.DecodeB64Payload(); err != nil {
+		return nil, fmt.Errorf("DSSE envelope payload is not base64 encoded: %w", err)
+	}
+	return &env, nil
+}
@@ -0,0 +1,201 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsse
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// testSigner signs DSSE envelopes with a crypto.Signer
+type testSigner struct {
+	dsse.Verifier
+	signer crypto.Signer
+}
+
+func (s *testSigner) Sign(data []byte) ([]byte, error) {
+	if _, ok := s.signer.(ed25519.PrivateKey); ok {
+		return s.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func newTestSigner(t *testing.T, signer crypto.Signer) *testSigner {
+	v, err := NewVerifier("", signer.Public())
+	if err != nil {
+		t.Fatalf("unable to create verifier: %v", err)
+	}
+	return &testSigner{Verifier: v, signer: signer}
+}
+
+func signedDoc(t *testing.T, s *testSigner, payloadType string, payload []byte) *processor.Document {
+	es, err := dsse.NewEnvelopeSigner(s)
+	if err != nil {
+		t.Fatalf("unable to create envelope signer: %v", err)
+	}
+	env, err := es.SignPayload(payloadType, payload)
+	if err != nil {
+		t.Fatalf("unable to sign payload: %v", err)
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("unable to marshal envelope: %v", err)
+	}
+	return &processor.Document{
+		Blob:   b,
+		Type:   processor.DocumentDSSE,
+		Format: processor.FormatJSON,
+	}
+}
+
+func Test_DSSEProcessor(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecSigner := newTestSigner(t, ecKey)
+	edSigner := newTestSigner(t, edKey)
+	rsaSigner := newTestSigner(t, rsaKey)
+	otherSigner := newTestSigner(t, otherKey)
+
+	payload := []byte(`{"_type": "https://in-toto.io/Statement/v0.1"}`)
+	testCases := []struct {
+		name         string
+		doc          *processor.Document
+		verifiers    []dsse.Verifier
+		expectedType processor.DocumentType
+		expectErr    bool
+	}{{
+		name:         "ecdsa signed",
+		doc:          signedDoc(t, ecSigner, PayloadTypeInToto, payload),
+		verifiers:    []dsse.Verifier{ecSigner},
+		expectedType: processor.DocumentITE6,
+	}, {
+		name:         "ed25519 signed",
+		doc:          signedDoc(t, edSigner, PayloadTypeInToto, payload),
+		verifiers:    []dsse.Verifier{ecSigner, edSigner},
+		expectedType: processor.DocumentITE6,
+	}, {
+		name:         "rsa signed",
+		doc:          signedDoc(t, rsaSigner, PayloadTypeInToto, payload),
+		verifiers:    []dsse.Verifier{rsaSigner},
+		expectedType: processor.DocumentITE6,
+	}, {
+		name:         "unknown payload type",
+		doc:          signedDoc(t, ecSigner, "text/plain", payload),
+		verifiers:    []dsse.Verifier{ecSigner},
+		expectedType: processor.DocumentUnknown,
+	}, {
+		name:      "untrusted key",
+		doc:       signedDoc(t, otherSigner, PayloadTypeInToto, payload),
+		verifiers: []dsse.Verifier{ecSigner, rsaSigner},
+		expectErr: true,
+	}, {
+		name:      "no verifiers",
+		doc:       signedDoc(t, ecSigner, PayloadTypeInToto, payload),
+		expectErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := NewDSSEProcessor(tt.verifiers...)
+			if err := dp.ValidateSchema(tt.doc); err != nil {
+				t.Fatalf("unexpected schema error: %v", err)
+			}
+
+			_, err := dp.ValidateTrustInformation(tt.doc)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.doc.TrustInformation.DSSE == nil {
+				t.Errorf("expected DSSE envelope in trust information")
+			}
+
+			docs, err := dp.Unpack(tt.doc)
+			if err != nil {
+				t.Fatalf("unexpected unpack error: %v", err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("got %v unpacked docs, expected 1", len(docs))
+			}
+			if docs[0].Type != tt.expectedType {
+				t.Errorf("got type %v, expected %v", docs[0].Type, tt.expectedType)
+			}
+			if !reflect.DeepEqual(docs[0].Blob, payload) {
+				t.Errorf("got payload %s, expected %s", docs[0].Blob, payload)
+			}
+			if docs[0].TrustInformation.DSSE == nil {
+				t.Errorf("expected DSSE envelope in unpacked trust information")
+			}
+		})
+	}
+}
+
+func Test_DSSEProcessorSchema(t *testing.T) {
+	testCases := []struct {
+		name string
+		doc  processor.Document
+	}{{
+		name: "not json",
+		doc:  processor.Document{Blob: []byte(`not json`), Format: processor.FormatJSON},
+	}, {
+		name: "no signatures",
+		doc:  processor.Document{Blob: []byte(`{"payloadType": "a", "payload": "YQ==", "signatures": []}`), Format: processor.FormatJSON},
+	}, {
+		name: "no payload type",
+		doc:  processor.Document{Blob: []byte(`{"payload": "YQ==", "signatures": [{"sig": "YQ=="}]}`), Format: processor.FormatJSON},
+	}, {
+		name: "bad payload encoding",
+		doc:  processor.Document{Blob: []byte(`{"payloadType": "a", "payload": "!!", "signatures": [{"sig": "YQ=="}]}`), Format: processor.FormatJSON},
+	}}
+
+	dp := NewDSSEProcessor()
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := dp.ValidateSchema(&tt.doc); err == nil {
+				t.Errorf("expected schema error")
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsse
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// keyVerifier implements dsse.Verifier for a single public key.
+// Supported key types are ECDSA, Ed25519 and RSA.
+type keyVerifier struct {
+	keyID string
+	pub   crypto.PublicKey
+}
+
+// NewVerifier returns a dsse.Verifier for the given public key. If keyID
+// is empty, the key ID is derived from the public key by the dsse library.
+func NewVerifier(keyID string, pub crypto.PublicKey) (dsse.Verifier, error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+	return &keyVerifier{keyID: keyID, pub: pub}, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX public key
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("unable to decode PEM public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func (v *keyVerifier) Verify(data, sig []byte) error {
+	switch pub := v.pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return fmt.Errorf("ecdsa signature verification failed")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("ed25519 signature verification failed")
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		// Both RSASSA-PSS and PKCS #1 v1.5 signatures are seen in the wild
+		if err := rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, nil); err != nil {
+			if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+				return fmt.Errorf("rsa signature verification failed: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", v.pub)
+	}
+	return nil
+}
+
+func (v *keyVerifier) KeyID() (string, error) {
+	if v.keyID == "" {
+		return dsse.SHA256KeyID(v.pub)
+	}
+	return v.keyID, nil
+}
+
+func (v *keyVerifier) Public() crypto.PublicKey {
+	return v.pub
+}
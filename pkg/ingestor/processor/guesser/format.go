@@ -0,0 +1,95 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guesser
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/spdx"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic field in a tar header
+const tarMagicOffset = 257
+
+// archiveGuesser recognizes archives by their magic bytes
+type archiveGuesser struct{}
+
+func (g *archiveGuesser) GuessFormat(blob []byte) processor.FormatType {
+	switch {
+	case bytes.HasPrefix(blob, gzipMagic):
+		return processor.FormatGzip
+	case bytes.HasPrefix(blob, zipMagic):
+		return processor.FormatZip
+	case len(blob) >= tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(blob[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return processor.FormatTar
+	}
+	return processor.FormatUnknown
+}
+
+func (g *archiveGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	switch format {
+	case processor.FormatTar, processor.FormatZip, processor.FormatGzip:
+		return processor.DocumentArchive
+	}
+	return processor.DocumentUnknown
+}
+
+type jsonGuesser struct{}
+
+func (g *jsonGuesser) GuessFormat(blob []byte) processor.FormatType {
+	trimmed := bytes.TrimSpace(blob)
+	if (bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("["))) && json.Valid(trimmed) {
+		return processor.FormatJSON
+	}
+	return processor.FormatUnknown
+}
+
+type xmlGuesser struct{}
+
+func (g *xmlGuesser) GuessFormat(blob []byte) processor.FormatType {
+	if !bytes.HasPrefix(bytes.TrimSpace(blob), []byte("<")) {
+		return processor.FormatUnknown
+	}
+	dec := xml.NewDecoder(bytes.NewReader(blob))
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return processor.FormatUnknown
+		}
+		if _, ok := t.(xml.StartElement); ok {
+			return processor.FormatXML
+		}
+	}
+}
+
+type tagValueGuesser struct{}
+
+func (g *tagValueGuesser) GuessFormat(blob []byte) processor.FormatType {
+	if _, err := spdx.ParseTagValues(blob); err == nil {
+		return processor.FormatTagValue
+	}
+	return processor.FormatUnknown
+}
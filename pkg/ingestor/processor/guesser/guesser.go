@@ -0,0 +1,143 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guesser
+
+import (
+	"fmt"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/sirupsen/logrus"
+)
+
+// FormatGuesser guesses the format of a document from its content
+type FormatGuesser interface {
+	// GuessFormat returns the format of the blob, or FormatUnknown if
+	// the guesser does not recognize it
+	GuessFormat(blob []byte) processor.FormatType
+}
+
+// TypeGuesser guesses the type of a document from its content and format
+type TypeGuesser interface {
+	// GuessDocumentType returns the type of the blob, or DocumentUnknown
+	// if the guesser does not recognize it
+	GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType
+}
+
+type namedFormatGuesser struct {
+	name string
+	g    FormatGuesser
+}
+
+type namedTypeGuesser struct {
+	name string
+	g    TypeGuesser
+}
+
+// Guessers are consulted in registration order, the first one to
+// recognize the document wins.
+var (
+	formatGuessers []namedFormatGuesser
+	typeGuessers   []namedTypeGuesser
+)
+
+func init() {
+	RegisterFormatGuesser(&archiveGuesser{}, "archive")
+	RegisterFormatGuesser(&jsonGuesser{}, "json")
+	RegisterFormatGuesser(&xmlGuesser{}, "xml")
+	RegisterFormatGuesser(&tagValueGuesser{}, "tag-value")
+
+	RegisterTypeGuesser(&archiveGuesser{}, "archive")
+	RegisterTypeGuesser(&dsseGuesser{}, "dsse")
+	RegisterTypeGuesser(&sigstoreBundleGuesser{}, "sigstore-bundle")
+	RegisterTypeGuesser(&ite6Guesser{}, "ite6")
+	RegisterTypeGuesser(&spdxGuesser{}, "spdx")
+	RegisterTypeGuesser(&cycloneDXGuesser{}, "cyclonedx")
+	RegisterTypeGuesser(&openVEXGuesser{}, "openvex")
+	RegisterTypeGuesser(&slsaGuesser{}, "slsa")
+}
+
+// RegisterFormatGuesser adds a format guesser. A guesser registered under
+// an existing name replaces it in place.
+func RegisterFormatGuesser(g FormatGuesser, name string) {
+	for i, ng := range formatGuessers {
+		if ng.name == name {
+			logrus.Warnf("the format guesser is being overwritten: %s", name)
+			formatGuessers[i].g = g
+			return
+		}
+	}
+	formatGuessers = append(formatGuessers, namedFormatGuesser{name: name, g: g})
+}
+
+// RegisterTypeGuesser adds a document type guesser. A guesser registered
+// under an existing name replaces it in place.
+func RegisterTypeGuesser(g TypeGuesser, name string) {
+	for i, ng := range typeGuessers {
+		if ng.name == name {
+			logrus.Warnf("the document type guesser is being overwritten: %s", name)
+			typeGuessers[i].g = g
+			return
+		}
+	}
+	typeGuessers = append(typeGuessers, namedTypeGuesser{name: name, g: g})
+}
+
+// GuessFormat returns the format of the blob according to the registered
+// format guessers
+func GuessFormat(blob []byte) processor.FormatType {
+	for _, ng := range formatGuessers {
+		if f := ng.g.GuessFormat(blob); f != processor.FormatUnknown && f != "" {
+			return f
+		}
+	}
+	return processor.FormatUnknown
+}
+
+// GuessDocumentType returns the type of the blob according to the
+// registered type guessers
+func GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	for _, ng := range typeGuessers {
+		if t := ng.g.GuessDocumentType(blob, format); t != processor.DocumentUnknown && t != "" {
+			return t
+		}
+	}
+	return processor.DocumentUnknown
+}
+
+// GuessDocument fills in the Format and Type of the document if they are
+// empty or unknown. An error is returned if either cannot be guessed, in
+// which case it is left as unknown. The predicate of an in-toto statement
+// is left untyped rather than taken for a SLSA provenance by its shape, as
+// the statement processor types the predicates of SLSA predicate types.
+func GuessDocument(d *processor.Document) error {
+	if d.Format == "" || d.Format == processor.FormatUnknown {
+		d.Format = GuessFormat(d.Blob)
+	}
+	if d.Type == "" || d.Type == processor.DocumentUnknown {
+		d.Type = GuessDocumentType(d.Blob, d.Format)
+		if d.Type == processor.DocumentSLSA && typedPredicate(d) {
+			d.Type = processor.DocumentUnknown
+		}
+	}
+
+	if d.Format == processor.FormatUnknown {
+		return fmt.Errorf("unable to guess document format")
+	}
+	if d.Type == processor.DocumentUnknown {
+		return fmt.Errorf("unable to guess document type")
+	}
+	return nil
+}
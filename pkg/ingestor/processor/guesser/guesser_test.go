@@ -0,0 +1,168 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guesser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, _ = gw.Write(b)
+	_ = gw.Close()
+	return buf.Bytes()
+}
+
+func Test_GuessDocument(t *testing.T) {
+	testCases := []struct {
+		name           string
+		doc            processor.Document
+		expectedType   processor.DocumentType
+		expectedFormat processor.FormatType
+		expectErr      bool
+	}{{
+		name:           "dsse",
+		doc:            processor.Document{Blob: []byte(`{"payloadType": "application/vnd.in-toto+json", "payload": "e30=", "signatures": []}`)},
+		expectedType:   processor.DocumentDSSE,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "sigstore bundle",
+		doc:            processor.Document{Blob: []byte(`{"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json", "dsseEnvelope": {}}`)},
+		expectedType:   processor.DocumentSigstoreBundle,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "in-toto",
+		doc:            processor.Document{Blob: []byte(`{"_type": "https://in-toto.io/Statement/v0.1", "predicateType": "x"}`)},
+		expectedType:   processor.DocumentITE6,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "spdx json",
+		doc:            processor.Document{Blob: []byte(`{"spdxVersion": "SPDX-2.3"}`)},
+		expectedType:   processor.DocumentSPDX,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "spdx tag-value",
+		doc:            processor.Document{Blob: []byte("SPDXVersion: SPDX-2.3\nDataLicense: CC0-1.0\n")},
+		expectedType:   processor.DocumentSPDX,
+		expectedFormat: processor.FormatTagValue,
+	}, {
+		name:           "cyclonedx json",
+		doc:            processor.Document{Blob: []byte(`{"bomFormat": "CycloneDX", "specVersion": "1.4"}`)},
+		expectedType:   processor.DocumentCycloneDX,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "cyclonedx xml",
+		doc:            processor.Document{Blob: []byte(`<?xml version="1.0"?><bom xmlns="http://cyclonedx.org/schema/bom/1.4" version="1"/>`)},
+		expectedType:   processor.DocumentCycloneDX,
+		expectedFormat: processor.FormatXML,
+	}, {
+		name:           "openvex",
+		doc:            processor.Document{Blob: []byte(`{"@context": "https://openvex.dev/ns/v0.2.0", "statements": []}`)},
+		expectedType:   processor.DocumentOpenVEX,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "slsa v0.2 predicate",
+		doc:            processor.Document{Blob: []byte(`{"builder": {"id": "b"}, "buildType": "t"}`)},
+		expectedType:   processor.DocumentSLSA,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "slsa v1 predicate",
+		doc:            processor.Document{Blob: []byte(`{"buildDefinition": {}, "runDetails": {}}`)},
+		expectedType:   processor.DocumentSLSA,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "slsa predicate type",
+		doc:            processor.Document{Blob: []byte(`{"predicateType": "https://slsa.dev/provenance/v1", "predicate": {}}`)},
+		expectedType:   processor.DocumentSLSA,
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "slsa fields with another predicate type",
+		doc:            processor.Document{Blob: []byte(`{"predicateType": "https://example.com/custom/v1", "builder": {"id": "b"}, "buildType": "t"}`)},
+		expectedType:   processor.DocumentUnknown,
+		expectedFormat: processor.FormatJSON,
+		expectErr:      true,
+	}, {
+		name: "slsa fields in the predicate of another predicate type",
+		doc: processor.Document{
+			Blob: []byte(`{"builder": {"id": "b"}, "buildType": "t"}`),
+			Parent: &processor.Document{
+				Blob: []byte(`{"_type": "https://in-toto.io/Statement/v0.1", "predicateType": "https://example.com/custom/v1"}`),
+				Type: processor.DocumentITE6,
+			},
+		},
+		expectedType:   processor.DocumentUnknown,
+		expectedFormat: processor.FormatJSON,
+		expectErr:      true,
+	}, {
+		name:           "gzip",
+		doc:            processor.Document{Blob: gzipped([]byte("abc"))},
+		expectedType:   processor.DocumentArchive,
+		expectedFormat: processor.FormatGzip,
+	}, {
+		name:           "keep declared type",
+		doc:            processor.Document{Blob: []byte(`{"spdxVersion": "SPDX-2.3"}`), Type: "custom"},
+		expectedType:   "custom",
+		expectedFormat: processor.FormatJSON,
+	}, {
+		name:           "unknown json",
+		doc:            processor.Document{Blob: []byte(`{"a": "b"}`)},
+		expectedType:   processor.DocumentUnknown,
+		expectedFormat: processor.FormatJSON,
+		expectErr:      true,
+	}, {
+		name:           "unknown format",
+		doc:            processor.Document{Blob: []byte("hello")},
+		expectedType:   processor.DocumentUnknown,
+		expectedFormat: processor.FormatUnknown,
+		expectErr:      true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := GuessDocument(&tt.doc)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if tt.doc.Type != tt.expectedType {
+				t.Errorf("got type %v, expected %v", tt.doc.Type, tt.expectedType)
+			}
+			if tt.doc.Format != tt.expectedFormat {
+				t.Errorf("got format %v, expected %v", tt.doc.Format, tt.expectedFormat)
+			}
+		})
+	}
+}
+
+type fixedGuesser struct{}
+
+func (g *fixedGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	if bytes.Contains(blob, []byte("fixed")) {
+		return "fixed"
+	}
+	return processor.DocumentUnknown
+}
+
+func Test_RegisterTypeGuesser(t *testing.T) {
+	RegisterTypeGuesser(&fixedGuesser{}, "fixed")
+	if got := GuessDocumentType([]byte(`{"fixed": true}`), processor.FormatJSON); got != "fixed" {
+		t.Errorf("got type %v, expected fixed", got)
+	}
+}
@@ -0,0 +1,163 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package guesser
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// jsonFields returns the top level fields of a JSON object, or nil if
+// the blob is not a JSON object
+func jsonFields(blob []byte, format processor.FormatType) map[string]json.RawMessage {
+	if format != processor.FormatJSON {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(blob, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// jsonString returns the string value of a JSON field, or "" if the
+// field is missing or not a string
+func jsonString(fields map[string]json.RawMessage, key string) string {
+	var s string
+	if err := json.Unmarshal(fields[key], &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+type dsseGuesser struct{}
+
+func (g *dsseGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	fields := jsonFields(blob, format)
+	if jsonString(fields, "payloadType") != "" && fields["payload"] != nil && fields["signatures"] != nil {
+		return processor.DocumentDSSE
+	}
+	return processor.DocumentUnknown
+}
+
+type sigstoreBundleGuesser struct{}
+
+func (g *sigstoreBundleGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	fields := jsonFields(blob, format)
+	if strings.HasPrefix(jsonString(fields, "mediaType"), "application/vnd.dev.sigstore.bundle") {
+		return processor.DocumentSigstoreBundle
+	}
+	return processor.DocumentUnknown
+}
+
+type ite6Guesser struct{}
+
+func (g *ite6Guesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	fields := jsonFields(blob, format)
+	if strings.HasPrefix(jsonString(fields, "_type"), "https://in-toto.io/Statement/") {
+		return processor.DocumentITE6
+	}
+	return processor.DocumentUnknown
+}
+
+type spdxGuesser struct{}
+
+func (g *spdxGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	switch format {
+	case processor.FormatJSON:
+		if strings.HasPrefix(jsonString(jsonFields(blob, format), "spdxVersion"), "SPDX-") {
+			return processor.DocumentSPDX
+		}
+	case processor.FormatTagValue:
+		if bytes.Contains(blob, []byte("SPDXVersion:")) {
+			return processor.DocumentSPDX
+		}
+	}
+	return processor.DocumentUnknown
+}
+
+type cycloneDXGuesser struct{}
+
+func (g *cycloneDXGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	switch format {
+	case processor.FormatJSON:
+		if jsonString(jsonFields(blob, format), "bomFormat") == "CycloneDX" {
+			return processor.DocumentCycloneDX
+		}
+	case processor.FormatXML:
+		dec := xml.NewDecoder(bytes.NewReader(blob))
+		for {
+			t, err := dec.Token()
+			if err != nil {
+				break
+			}
+			if se, ok := t.(xml.StartElement); ok {
+				if se.Name.Local == "bom" && strings.HasPrefix(se.Name.Space, "http://cyclonedx.org/schema/bom/") {
+					return processor.DocumentCycloneDX
+				}
+				break
+			}
+		}
+	}
+	return processor.DocumentUnknown
+}
+
+// typedPredicate returns whether the document is the predicate of an
+// in-toto statement, which is typed by the predicateType of the statement
+// rather than by the shape of its fields
+func typedPredicate(d *processor.Document) bool {
+	return d.Parent != nil && d.Parent.Type == processor.DocumentITE6
+}
+
+type openVEXGuesser struct{}
+
+func (g *openVEXGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	fields := jsonFields(blob, format)
+	if strings.HasPrefix(jsonString(fields, "@context"), "https://openvex.dev/ns") {
+		return processor.DocumentOpenVEX
+	}
+	return processor.DocumentUnknown
+}
+
+// slsaPredicateTypePrefix is the prefix of the SLSA provenance predicate
+// types
+const slsaPredicateTypePrefix = "https://slsa.dev/provenance/"
+
+// slsaGuesser recognizes bare SLSA provenance predicates. A blob with a
+// predicateType is typed by it, the shape of the fields is only checked
+// for untyped blobs.
+type slsaGuesser struct{}
+
+func (g *slsaGuesser) GuessDocumentType(blob []byte, format processor.FormatType) processor.DocumentType {
+	fields := jsonFields(blob, format)
+	if fields["predicateType"] != nil {
+		if strings.HasPrefix(jsonString(fields, "predicateType"), slsaPredicateTypePrefix) {
+			return processor.DocumentSLSA
+		}
+		return processor.DocumentUnknown
+	}
+	if fields["builder"] != nil && fields["buildType"] != nil {
+		return processor.DocumentSLSA
+	}
+	if fields["buildDefinition"] != nil && fields["runDetails"] != nil {
+		return processor.DocumentSLSA
+	}
+	return processor.DocumentUnknown
+}
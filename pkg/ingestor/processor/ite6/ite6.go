@@ -0,0 +1,196 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ite6
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// Statement types accepted by the processor
+const (
+	StatementTypeV01 = "https://in-toto.io/Statement/v0.1"
+	StatementTypeV1  = "https://in-toto.io/Statement/v1"
+)
+
+// predicateTypes maps known predicate types to the document type of the
+// predicate. Predicate types not in this map are unpacked as
+// processor.DocumentUnknown.
+var predicateTypes = map[string]processor.DocumentType{
+	"https://slsa.dev/provenance/v0.2":         processor.DocumentSLSA,
+	"https://slsa.dev/provenance/v1":           processor.DocumentSLSA,
+	"https://spdx.dev/Document":                processor.DocumentSPDX,
+	"https://cyclonedx.org/bom":                processor.DocumentCycloneDX,
+	"https://cyclonedx.org/bom/v1.4":           processor.DocumentCycloneDX,
+	"https://in-toto.io/attestation/vuln/v0.1": processor.DocumentITE6Vul,
+}
+
+// digestLengths is the expected hex encoded length of well known digest
+// algorithms. Other algorithms only need to be hex encoded.
+var digestLengths = map[string]int{
+	"sha1":   40,
+	"sha224": 56,
+	"sha256": 64,
+	"sha384": 96,
+	"sha512": 128,
+}
+
+// Statement is an in-toto ITE-6 attestation statement
+type Statement struct {
+	Type          string          `json:"_type"`
+	Subject       []Subject       `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// Subject is an artifact the statement is about
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// ITE6Processor processes in-toto ITE-6 statements. The predicate of the
+// statement is unpacked as a child document, typed according to the
+// statement predicateType.
+type ITE6Processor struct{}
+
+func (dp *ITE6Processor) ValidateSchema(d *processor.Document) error {
+	_, err := parseStatement(d)
+	return err
+}
+
+func (dp *ITE6Processor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	s, err := parseStatement(d)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make([]map[string]string, len(s.Subject))
+	for i, sub := range s.Subject {
+		subjects[i] = sub.Digest
+	}
+	return map[string]interface{}{
+		"predicate_type": s.PredicateType,
+		"subjects":       subjects,
+	}, nil
+}
+
+func (dp *ITE6Processor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	s, err := parseStatement(d)
+	if err != nil {
+		return nil, err
+	}
+
+	t, ok := predicateTypes[s.PredicateType]
+	if !ok {
+		t = processor.DocumentUnknown
+	}
+	return []*processor.Document{{
+		Blob:             s.Predicate,
+		Type:             t,
+		Format:           processor.FormatJSON,
+		TrustInformation: d.TrustInformation,
+	}}, nil
+}
+
+func (dp *ITE6Processor) Describe() processor.Description {
+	children := []processor.DocumentType{processor.DocumentUnknown}
+	for _, t := range predicateTypes {
+		if !containsType(children, t) {
+			children = append(children, t)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i] < children[j] })
+	return processor.Description{
+		Formats:    []processor.FormatType{processor.FormatJSON},
+		Versions:   []string{StatementTypeV01, StatementTypeV1},
+		ChildTypes: children,
+	}
+}
+
+func containsType(types []processor.DocumentType, t processor.DocumentType) bool {
+	for _, s := range types {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+func parseStatement(d *processor.Document) (*Statement, error) {
+	if d.Format != processor.FormatJSON {
+		return nil, fmt.Errorf("only accept JSON formats")
+	}
+
+	var s Statement
+	if err := json.Unmarshal(d.Blob, &s); err != nil {
+		return nil, err
+	}
+	if err := validateStatement(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func validateStatement(s *Statement) error {
+	if s.Type != StatementTypeV01 && s.Type != StatementTypeV1 {
+		return fmt.Errorf("unsupported statement _type: %q", s.Type)
+	}
+	if len(s.Subject) == 0 {
+		return fmt.Errorf("statement subject shouldn't be empty")
+	}
+	for i, sub := range s.Subject {
+		if err := validateSubject(s.Type, sub); err != nil {
+			return fmt.Errorf("invalid subject %d: %w", i, err)
+		}
+	}
+	if s.PredicateType == "" {
+		return fmt.Errorf("statement predicateType shouldn't be empty")
+	}
+	p := bytes.TrimSpace(s.Predicate)
+	if len(p) == 0 || p[0] != '{' {
+		return fmt.Errorf("statement predicate should be an object")
+	}
+	return nil
+}
+
+func validateSubject(statementType string, sub Subject) error {
+	// Subject names are only optional from v1 onwards
+	if sub.Name == "" && statementType == StatementTypeV01 {
+		return fmt.Errorf("subject name shouldn't be empty")
+	}
+	if len(sub.Digest) == 0 {
+		return fmt.Errorf("subject digest shouldn't be empty")
+	}
+	for alg, v := range sub.Digest {
+		if alg == "" || alg != strings.ToLower(alg) {
+			return fmt.Errorf("invalid digest algorithm: %q", alg)
+		}
+		if _, err := hex.DecodeString(v); err != nil || v == "" {
+			return fmt.Errorf("digest %s is not hex encoded", alg)
+		}
+		if l, ok := digestLengths[alg]; ok && len(v) != l {
+			return fmt.Errorf("digest %s has length %d, expected %d", alg, len(v), l)
+		}
+	}
+	return nil
+}
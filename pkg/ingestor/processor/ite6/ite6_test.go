@@ -0,0 +1,197 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ite6
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+const sha256Digest = "5678c7f7d3a8e5a5d1ea3a3c4d4e0b0f7d9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c"
+
+func Test_ITE6Processor(t *testing.T) {
+	testCases := []struct {
+		name          string
+		blob          string
+		expectedType  processor.DocumentType
+		expectedChild string
+		expectErr     bool
+	}{{
+		name: "slsa provenance",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {"builder": {"id": "https://github.com/actions"}}
+		}`,
+		expectedType:  processor.DocumentSLSA,
+		expectedChild: `{"builder": {"id": "https://github.com/actions"}}`,
+	}, {
+		name: "spdx v1 statement without subject name",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v1",
+			"subject": [{"digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://spdx.dev/Document",
+			"predicate": {"spdxVersion": "SPDX-2.3"}
+		}`,
+		expectedType:  processor.DocumentSPDX,
+		expectedChild: `{"spdxVersion": "SPDX-2.3"}`,
+	}, {
+		name: "cyclonedx",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://cyclonedx.org/bom",
+			"predicate": {"bomFormat": "CycloneDX"}
+		}`,
+		expectedType:  processor.DocumentCycloneDX,
+		expectedChild: `{"bomFormat": "CycloneDX"}`,
+	}, {
+		name: "vuln",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://in-toto.io/attestation/vuln/v0.1",
+			"predicate": {"scanner": {}}
+		}`,
+		expectedType:  processor.DocumentITE6Vul,
+		expectedChild: `{"scanner": {}}`,
+	}, {
+		name: "unknown predicate",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://example.com/custom",
+			"predicate": {}
+		}`,
+		expectedType:  processor.DocumentUnknown,
+		expectedChild: `{}`,
+	}, {
+		name: "unsupported slsa provenance version",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.1",
+			"predicate": {"builder": {"id": "https://github.com/actions"}}
+		}`,
+		expectedType:  processor.DocumentUnknown,
+		expectedChild: `{"builder": {"id": "https://github.com/actions"}}`,
+	}, {
+		name: "bad _type",
+		blob: `{
+			"_type": "https://example.com/Statement",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {}
+		}`,
+		expectErr: true,
+	}, {
+		name: "no subject",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {}
+		}`,
+		expectErr: true,
+	}, {
+		name: "subject without name",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {}
+		}`,
+		expectErr: true,
+	}, {
+		name: "subject digest not hex",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "not-hex"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {}
+		}`,
+		expectErr: true,
+	}, {
+		name: "subject digest wrong length",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "abcd"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {}
+		}`,
+		expectErr: true,
+	}, {
+		name: "no predicateType",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicate": {}
+		}`,
+		expectErr: true,
+	}, {
+		name: "predicate not an object",
+		blob: `{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "` + sha256Digest + `"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": "abc"
+		}`,
+		expectErr: true,
+	}}
+
+	dp := &ITE6Processor{}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &processor.Document{
+				Blob:   []byte(tt.blob),
+				Type:   processor.DocumentITE6,
+				Format: processor.FormatJSON,
+			}
+			err := dp.ValidateSchema(d)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+
+			docs, err := dp.Unpack(d)
+			if err != nil {
+				t.Fatalf("unexpected unpack error: %v", err)
+			}
+			if len(docs) != 1 {
+				t.Fatalf("got %v unpacked docs, expected 1", len(docs))
+			}
+			if docs[0].Type != tt.expectedType {
+				t.Errorf("got type %v, expected %v", docs[0].Type, tt.expectedType)
+			}
+			var got, expected interface{}
+			if err := json.Unmarshal(docs[0].Blob, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.expectedChild), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("got predicate %s, expected %s", docs[0].Blob, tt.expectedChild)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors wrapped by LimitError, one for each unpacking limit
+var (
+	ErrMaxDepthExceeded      = errors.New("maximum unpacking depth exceeded")
+	ErrMaxChildrenExceeded   = errors.New("maximum number of unpacked documents exceeded")
+	ErrMaxDocumentsExceeded  = errors.New("maximum number of processed documents exceeded")
+	ErrMaxTotalBytesExceeded = errors.New("maximum total size of unpacked documents exceeded")
+	ErrCycleDetected         = errors.New("document unpacks to one of its ancestors")
+)
+
+// FormatError is returned when a document format is invalid or cannot
+// be determined
+type FormatError struct {
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("invalid document format: %v", e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// SchemaError is returned when a document does not match the schema of
+// its type, or no processor is registered for the type
+type SchemaError struct {
+	Err error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("error validating document schema: %v", e.Err)
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyError is returned when a document is rejected because its trust
+// information is not valid
+type PolicyError struct {
+	Err error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("document rejected by policy: %v", e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// UnpackError is returned when a valid document cannot be unpacked
+type UnpackError struct {
+	Err error
+}
+
+func (e *UnpackError) Error() string {
+	return fmt.Sprintf("unable to unpack document: %v", e.Err)
+}
+
+func (e *UnpackError) Unwrap() error {
+	return e.Err
+}
+
+// LimitError is returned when unpacking a document trips one of the
+// unpacking limits, Err is one of the ErrMax* errors or ErrCycleDetected
+type LimitError struct {
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("document processing limit exceeded: %v", e.Err)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// outcomeOf returns the outcome of processing a document given the
+// resulting error
+func outcomeOf(err error) Outcome {
+	var (
+		formatErr *FormatError
+		schemaErr *SchemaError
+		policyErr *PolicyError
+		unpackErr *UnpackError
+		limitErr  *LimitError
+	)
+	switch {
+	case err == nil:
+		return OutcomeAccepted
+	case errors.As(err, &formatErr):
+		return OutcomeInvalidFormat
+	case errors.As(err, &schemaErr):
+		return OutcomeSchemaError
+	case errors.As(err, &policyErr):
+		return OutcomeRejectedByPolicy
+	case errors.As(err, &unpackErr):
+		return OutcomeUnpackError
+	case errors.As(err, &limitErr):
+		return OutcomeLimitExceeded
+	}
+	return OutcomeSchemaError
+}
@@ -0,0 +1,60 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"fmt"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+)
+
+// Trust information keys set when the log entry of a document is verified
+const (
+	TrustInfoLogVerified = "tlog_verified"
+	TrustInfoLogIndex    = "tlog_log_index"
+)
+
+// verifyLogEntry verifies the transparency log entry of the document and
+// returns the resulting trust information. Log entries inherited from the
+// parent document are about the parent, and so are not verified again. The
+// entry is about the document blob, or about the blob returned by its
+// processor if it is a processor.LogEntrySubject.
+func verifyLogEntry(r *processor.Registry, v *tlog.Verifier, d *processor.Document) (map[string]interface{}, error) {
+	e := d.TrustInformation.LogEntry
+	if v == nil || e == nil {
+		return nil, nil
+	}
+	if d.Parent != nil && d.Parent.TrustInformation.LogEntry == e {
+		return nil, nil
+	}
+	blob := d.Blob
+	if p, ok := r.Lookup(d.Type); ok {
+		if s, ok := p.(processor.LogEntrySubject); ok {
+			var err error
+			if blob, err = s.LogEntryBlob(d); err != nil {
+				return nil, &PolicyError{Err: fmt.Errorf("invalid transparency log entry: %w", err)}
+			}
+		}
+	}
+	if err := v.Verify(e, blob); err != nil {
+		return nil, &PolicyError{Err: fmt.Errorf("invalid transparency log entry: %w", err)}
+	}
+	return map[string]interface{}{
+		TrustInfoLogVerified: true,
+		TrustInfoLogIndex:    e.LogIndex,
+	}, nil
+}
@@ -0,0 +1,145 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/internal/testing/ingestor/simpledoc"
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+)
+
+// logEntry returns a log entry about the blob, signed by the log key
+func logEntry(t *testing.T, key *ecdsa.PrivateKey, blob []byte) *tlog.LogEntry {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	digest := sha256.Sum256(blob)
+	e := &tlog.LogEntry{
+		Body:           base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`{"kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"%s"}}}}`, hex.EncodeToString(digest[:])))),
+		IntegratedTime: 1660000000,
+		LogID:          hex.EncodeToString(logID[:]),
+		LogIndex:       42,
+	}
+	payload, err := cjson.EncodeCanonical(map[string]interface{}{
+		"body":           e.Body,
+		"integratedTime": e.IntegratedTime,
+		"logID":          e.LogID,
+		"logIndex":       e.LogIndex,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(payload)
+	sig, err := key.Sign(rand.Reader, h[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Verification = &tlog.Verification{SignedEntryTimestamp: base64.StdEncoding.EncodeToString(sig)}
+	return e
+}
+
+func Test_LogVerification(t *testing.T) {
+	logKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := tlog.NewVerifier(logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, err := ParseTrustPolicy([]byte(`sources: [{collector: logged, requireLog: true}]`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	_ = r.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+
+	blob := []byte(`{"issuer": "google.com", "nested": [{"issuer": "google.com"}]}`)
+	testCases := []struct {
+		name             string
+		logEntry         *tlog.LogEntry
+		verifier         *tlog.Verifier
+		expectedOutcomes []Outcome
+	}{{
+		name:             "verified log entry",
+		logEntry:         logEntry(t, logKey, blob),
+		verifier:         v,
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted},
+	}, {
+		name:             "log entry of another log",
+		logEntry:         logEntry(t, otherKey, blob),
+		verifier:         v,
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name:             "log entry of another document",
+		logEntry:         logEntry(t, logKey, []byte(`{}`)),
+		verifier:         v,
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name:             "no log entry",
+		verifier:         v,
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name:             "no log verifier",
+		logEntry:         logEntry(t, logKey, blob),
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &processor.Document{
+				Blob:              blob,
+				Type:              simpledoc.SimpleDocType,
+				Format:            processor.FormatJSON,
+				TrustInformation:  processor.TrustInformation{LogEntry: tt.logEntry},
+				SourceInformation: processor.SourceInformation{Collector: "logged"},
+			}
+			res, err := ProcessWithOptions(doc, Options{Policy: tp, LogVerifier: tt.verifier, Registry: r})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			outcomes := []Outcome{}
+			for _, dr := range res.Results {
+				outcomes = append(outcomes, dr.Outcome)
+			}
+			if !reflect.DeepEqual(outcomes, tt.expectedOutcomes) {
+				t.Errorf("got outcomes %v, expected %v", outcomes, tt.expectedOutcomes)
+			}
+			if outcomes[0] == OutcomeAccepted && res.Results[0].TrustInfo[TrustInfoLogIndex] != int64(42) {
+				t.Errorf("expected log index in trust info, got %v", res.Results[0].TrustInfo)
+			}
+		})
+	}
+}
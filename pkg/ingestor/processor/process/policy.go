@@ -0,0 +1,75 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"fmt"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// Policy decides whether a document is accepted for further processing
+type Policy interface {
+	// Evaluate returns the decision for the document. trustInfo is the
+	// trust information map returned by the document processor, merged
+	// over the trust information of every ancestor of the document.
+	Evaluate(d *processor.Document, trustInfo map[string]interface{}) (PolicyDecision, error)
+}
+
+// PolicyDecision is the decision of a Policy on a document
+type PolicyDecision struct {
+	// Allow accepts the document, otherwise it is rejected
+	Allow bool
+	// Reason explains the decision
+	Reason string
+	// Annotations are merged into the trust information of the document,
+	// and so are seen by the policy evaluation of its unpacked documents
+	Annotations map[string]interface{}
+}
+
+// AllowAll is the default policy, it accepts every document
+type AllowAll struct{}
+
+func (p *AllowAll) Evaluate(d *processor.Document, trustInfo map[string]interface{}) (PolicyDecision, error) {
+	return PolicyDecision{Allow: true}, nil
+}
+
+// evaluatePolicy evaluates the policy and returns the trust information of
+// the document including annotations
+func evaluatePolicy(p Policy, d *processor.Document, parentTrustInfo, trustInfo map[string]interface{}) (map[string]interface{}, error) {
+	merged := mergeTrustInfo(parentTrustInfo, trustInfo)
+	decision, err := p.Evaluate(d, merged)
+	if err != nil {
+		return nil, &PolicyError{Err: fmt.Errorf("error evaluating policy: %w", err)}
+	}
+	if !decision.Allow {
+		return nil, &PolicyError{Err: fmt.Errorf("document not allowed: %s", decision.Reason)}
+	}
+	return mergeTrustInfo(merged, decision.Annotations), nil
+}
+
+// mergeTrustInfo returns a new map with the entries of m overridden by
+// the entries of o
+func mergeTrustInfo(m, o map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(m)+len(o))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range o {
+		merged[k] = v
+	}
+	return merged
+}
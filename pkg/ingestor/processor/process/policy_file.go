@@ -0,0 +1,196 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// Policy rule actions
+const (
+	ActionAllow  = "allow"
+	ActionReject = "reject"
+)
+
+// FilePolicy is a declarative policy made of an ordered list of rules.
+// The first rule matching a document decides, if no rule matches the
+// default action applies.
+//
+// Example policy file is
+//
+//	{
+//		"default": "reject",
+//		"rules": [{
+//			"name": "trusted builders",
+//			"match": {"type": "SLSA", "collector": "file"},
+//			"require": {"slsa_builder_id": ["https://github.com/slsa-framework/slsa-github-generator"]},
+//			"annotations": {"slsa_trusted": true}
+//		}, {
+//			"name": "everything else",
+//			"action": "allow"
+//		}]
+//	}
+type FilePolicy struct {
+	Default string       `json:"default"`
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// PolicyRule is a rule of a FilePolicy
+type PolicyRule struct {
+	Name  string `json:"name"`
+	Match struct {
+		// Type matches the document type, empty matches all types
+		Type processor.DocumentType `json:"type"`
+		// Collector and Source are path.Match patterns matched against
+		// the source information of the root document
+		Collector string `json:"collector"`
+		Source    string `json:"source"`
+	} `json:"match"`
+	// Require maps trust information keys to their allowed values. For
+	// list values, any allowed value in the list satisfies the
+	// requirement. A document not satisfying a requirement is rejected.
+	Require map[string][]string `json:"require"`
+	// Action is the action of the rule, allow if empty
+	Action      string                 `json:"action"`
+	Annotations map[string]interface{} `json:"annotations"`
+}
+
+// LoadPolicyFile loads a FilePolicy from a JSON file
+func LoadPolicyFile(name string) (*FilePolicy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(b)
+}
+
+// ParsePolicy parses and validates a JSON FilePolicy
+func ParsePolicy(b []byte) (*FilePolicy, error) {
+	var p FilePolicy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.Default == "" {
+		p.Default = ActionAllow
+	}
+	if err := validateAction(p.Default); err != nil {
+		return nil, err
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Action == "" {
+			r.Action = ActionAllow
+		}
+		if err := validateAction(r.Action); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		for _, pattern := range []string{r.Match.Collector, r.Match.Source} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+func validateAction(a string) error {
+	if a != ActionAllow && a != ActionReject {
+		return fmt.Errorf("invalid policy action: %q", a)
+	}
+	return nil
+}
+
+func (p *FilePolicy) Evaluate(d *processor.Document, trustInfo map[string]interface{}) (PolicyDecision, error) {
+	source := d.Lineage()[0].SourceInformation
+	for _, r := range p.Rules {
+		if !r.matches(d, source) {
+			continue
+		}
+		for key, allowed := range r.Require {
+			if !anyAllowed(trustValues(trustInfo[key]), allowed) {
+				return PolicyDecision{
+					Reason: fmt.Sprintf("rule %q: %s not in allowed values", r.Name, key),
+				}, nil
+			}
+		}
+		return PolicyDecision{
+			Allow:       r.Action == ActionAllow,
+			Reason:      fmt.Sprintf("rule %q", r.Name),
+			Annotations: r.Annotations,
+		}, nil
+	}
+	return PolicyDecision{
+		Allow:  p.Default == ActionAllow,
+		Reason: "no rule matched",
+	}, nil
+}
+
+func (r *PolicyRule) matches(d *processor.Document, source processor.SourceInformation) bool {
+	if r.Match.Type != "" && r.Match.Type != d.Type {
+		return false
+	}
+	if r.Match.Collector != "" {
+		if ok, _ := path.Match(r.Match.Collector, source.Collector); !ok {
+			return false
+		}
+	}
+	if r.Match.Source != "" {
+		if ok, _ := path.Match(r.Match.Source, source.Source); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// trustValues returns the string values of a trust information entry
+func trustValues(v interface{}) []string {
+	switch tv := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{tv}
+	case *string:
+		if tv == nil {
+			return nil
+		}
+		return []string{*tv}
+	case []string:
+		return tv
+	case []interface{}:
+		var vs []string
+		for _, e := range tv {
+			vs = append(vs, trustValues(e)...)
+		}
+		return vs
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+func anyAllowed(values, allowed []string) bool {
+	for _, v := range values {
+		for _, a := range allowed {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/internal/testing/ingestor/simpledoc"
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+func Test_FilePolicy(t *testing.T) {
+	RegisterDocumentProcessor(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+	nestedDoc := func(collector string) processor.Document {
+		return processor.Document{
+			Blob: []byte(`{
+				"issuer": "google.com",
+				"info": "root",
+				"nested": [{"issuer": "google.com", "info": "child"}]
+			}`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+			TrustInformation: processor.TrustInformation{
+				IssuerUri: ptrStr("google.com"),
+			},
+			SourceInformation: processor.SourceInformation{
+				Collector: collector,
+				Source:    "/var/attestations/a.json",
+			},
+		}
+	}
+
+	slsaStatement := processor.Document{
+		Blob: []byte(`{
+			"_type": "https://in-toto.io/Statement/v0.1",
+			"subject": [{"name": "img", "digest": {"sha256": "5678c7f7d3a8e5a5d1ea3a3c4d4e0b0f7d9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c"}}],
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"predicate": {"builder": {"id": "https://example.com/builder"}, "buildType": "https://example.com/build"}
+		}`),
+		Type:   processor.DocumentITE6,
+		Format: processor.FormatJSON,
+	}
+
+	testCases := []struct {
+		name             string
+		policy           string
+		doc              processor.Document
+		expectedOutcomes []Outcome
+		expectErr        bool
+	}{{
+		name:             "default allow",
+		policy:           `{}`,
+		doc:              nestedDoc("file"),
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted},
+	}, {
+		name:             "default reject",
+		policy:           `{"default": "reject"}`,
+		doc:              nestedDoc("file"),
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name: "required issuer",
+		policy: `{"default": "reject", "rules": [{
+			"name": "google", "match": {"collector": "file", "source": "/var/attestations/*"},
+			"require": {"issuer": ["google.com"]}
+		}]}`,
+		doc:              nestedDoc("file"),
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted},
+	}, {
+		name: "issuer not allowed",
+		policy: `{"rules": [{
+			"name": "bing", "match": {"collector": "file"},
+			"require": {"issuer": ["bing.com"]}
+		}]}`,
+		doc:              nestedDoc("file"),
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name: "collector not matched",
+		policy: `{"default": "reject", "rules": [{
+			"name": "file only", "match": {"collector": "file"}
+		}]}`,
+		doc:              nestedDoc("http"),
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name: "annotations and ancestor trust info are seen by children",
+		policy: `{"default": "reject", "rules": [{
+			"name": "statement", "match": {"type": "ITE6"}, "annotations": {"statement_checked": true}
+		}, {
+			"name": "provenance", "match": {"type": "SLSA"},
+			"require": {"statement_checked": ["true"], "predicate_type": ["https://slsa.dev/provenance/v0.2"]}
+		}]}`,
+		doc:              slsaStatement,
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted},
+	}, {
+		name: "untrusted builder",
+		policy: `{"rules": [{
+			"name": "provenance", "match": {"type": "SLSA"},
+			"require": {"slsa_builder_id": ["https://example.com/trusted-builder"]}
+		}]}`,
+		doc:              slsaStatement,
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeRejectedByPolicy},
+	}, {
+		name:      "invalid action",
+		policy:    `{"default": "maybe"}`,
+		expectErr: true,
+	}, {
+		name:      "invalid pattern",
+		policy:    `{"rules": [{"match": {"source": "[a-"}}]}`,
+		expectErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			f := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(f, []byte(tt.policy), 0600); err != nil {
+				t.Fatal(err)
+			}
+			p, err := LoadPolicyFile(f)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+
+			res, err := ProcessWithOptions(&tt.doc, Options{Policy: p})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			outcomes := []Outcome{}
+			for _, dr := range res.Results {
+				outcomes = append(outcomes, dr.Outcome)
+				var policyErr *PolicyError
+				if dr.Outcome == OutcomeRejectedByPolicy && !errors.As(dr.Err, &policyErr) {
+					t.Errorf("expected policy error, got %v", dr.Err)
+				}
+			}
+			if !reflect.DeepEqual(outcomes, tt.expectedOutcomes) {
+				t.Errorf("got outcomes %v, expected %v", outcomes, tt.expectedOutcomes)
+			}
+		})
+	}
+}
+
+// recordingPolicy records the trust information of each document
+type recordingPolicy struct {
+	trustInfo map[string]map[string]interface{}
+}
+
+func (p *recordingPolicy) Evaluate(d *processor.Document, trustInfo map[string]interface{}) (PolicyDecision, error) {
+	p.trustInfo[d.ID] = trustInfo
+	return PolicyDecision{Allow: true, Annotations: map[string]interface{}{"seen_" + string(d.Type): true}}, nil
+}
+
+func Test_PolicyMergesAncestorTrustInfo(t *testing.T) {
+	RegisterDocumentProcessor(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+	RegisterDocumentProcessor(&selfDocProc{}, selfDocType)
+
+	doc := processor.Document{
+		Blob:   []byte(`{"issuer": "google.com", "nested": [{"issuer": "google.com"}]}`),
+		Type:   simpledoc.SimpleDocType,
+		Format: processor.FormatJSON,
+		TrustInformation: processor.TrustInformation{
+			IssuerUri: ptrStr("google.com"),
+		},
+	}
+	p := &recordingPolicy{trustInfo: map[string]map[string]interface{}{}}
+	res, err := ProcessWithOptions(&doc, Options{Policy: p})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Documents) != 1 {
+		t.Fatalf("got %v docs, expected 1", len(res.Documents))
+	}
+
+	root, child := p.trustInfo[doc.ID], p.trustInfo[res.Documents[0].ID]
+	if _, ok := root["seen_simple-doc"]; ok {
+		t.Errorf("root should not see its own annotations")
+	}
+	if _, ok := child["seen_simple-doc"]; !ok {
+		t.Errorf("child should see the annotations of its parent")
+	}
+	if _, ok := child["issuer"]; !ok {
+		t.Errorf("child should see its trust information")
+	}
+	if _, ok := res.Results[1].TrustInfo["seen_simple-doc"]; !ok {
+		t.Errorf("result trust info should include annotations")
+	}
+}
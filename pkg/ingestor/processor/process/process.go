@@ -16,72 +16,312 @@
 package process
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"sync"
 
 	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/archive"
+	"github.com/guacsec/guac/pkg/ingestor/processor/cyclonedx"
+	"github.com/guacsec/guac/pkg/ingestor/processor/guesser"
+	"github.com/guacsec/guac/pkg/ingestor/processor/ite6"
+	"github.com/guacsec/guac/pkg/ingestor/processor/slsa"
+	"github.com/guacsec/guac/pkg/ingestor/processor/spdx"
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	documentProcessors = map[processor.DocumentType]processor.DocumentProcessor{}
-)
+// defaultRegistry is the registry used when Options.Registry is not set
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns a new non strict registry with the built-in document
+// processors registered
+func NewRegistry() *processor.Registry {
+	r := processor.NewRegistry()
+	registerBuiltins(r)
+	return r
+}
 
-func init() {
-	// Registerprocessor.DocumentProcessor()
+// registerBuiltins registers the document processors that need no
+// configuration. The registry is empty, so registration cannot fail.
+func registerBuiltins(r *processor.Registry) {
+	_ = r.Register(&ite6.ITE6Processor{}, processor.DocumentITE6)
+	_ = r.Register(&slsa.SLSAProcessor{}, processor.DocumentSLSA)
+	_ = r.Register(&spdx.SPDXProcessor{}, processor.DocumentSPDX)
+	_ = r.Register(&cyclonedx.CycloneDXProcessor{}, processor.DocumentCycloneDX)
+	_ = r.Register(archive.NewArchiveProcessor(archive.DefaultLimits()), processor.DocumentArchive)
 }
 
+// DefaultRegistry returns the registry used when Options.Registry is not
+// set, that the package level registration functions modify
+func DefaultRegistry() *processor.Registry {
+	return defaultRegistry
+}
+
+// RegisterDocumentProcessor registers the processor in the default
+// registry, overwriting the processor of the document type if any
 func RegisterDocumentProcessor(p processor.DocumentProcessor, d processor.DocumentType) {
-	if _, ok := documentProcessors[d]; ok {
-		logrus.Warnf("the document processor is being overwritten: %s", d)
-	}
-	documentProcessors[d] = p
+	_ = defaultRegistry.Register(p, d)
 }
 
+// UnregisterDocumentProcessor removes the processor of the document type
+// from the default registry
+func UnregisterDocumentProcessor(d processor.DocumentType) {
+	defaultRegistry.Unregister(d)
+}
+
+// Process processes the document, unpacking it recursively, and returns
+// the accepted leaf documents. Documents that fail processing are
+// dropped with a warning, use ProcessWithOptions to inspect them.
 func Process(i *processor.Document) ([]*processor.Document, error) {
-	docsToUnpack := []*processor.Document{i}
-	finalDocs := []*processor.Document{}
-	for len(docsToUnpack) > 0 {
-		logrus.Debugf("%v documents left in queue", len(docsToUnpack))
-		dd := docsToUnpack[0]
-		docsToUnpack = docsToUnpack[1:]
-
-		ds, err := processDocument(dd)
-		// TODO: return a policy type error to provide better log warnings
+	res, err := ProcessWithOptions(i, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Documents, nil
+}
+
+// ProcessWithOptions processes the document, unpacking it recursively, and
+// returns the outcome of every document processed. In strict mode, the
+// first document that is not accepted fails the call with its error.
+func ProcessWithOptions(i *processor.Document, opts Options) (*Result, error) {
+	return ProcessContext(context.Background(), i, opts)
+}
+
+// ProcessContext is ProcessWithOptions with a context. Unpacked documents
+// are processed level by level, each level fanned out to a pool of
+// opts.Workers workers. Results are returned in the same order as if the
+// documents were processed serially, breadth first.
+//
+// Documents unpacking to no document are returned, as are documents whose
+// processor is a processor.Embedder embedding the unpacked documents.
+// Unpacking is bounded by the opts limits. The number and total size of
+// the unpacked documents are budgets of the whole call, so that nested
+// archives cannot expand beyond them. A document unpacking to one of its
+// ancestors, as identified by the blob digest, is dropped as a cycle.
+//
+// If the context is done, processing stops and the results of the levels
+// processed so far are returned along with the context error.
+func ProcessContext(ctx context.Context, i *processor.Document, opts Options) (*Result, error) {
+	opts = opts.withDefaults()
+
+	res := &Result{
+		Documents: []*processor.Document{},
+		Results:   []DocumentResult{},
+	}
+	root := newNode(i, nil)
+	if opts.KeepTree {
+		res.Tree = root.tree
+	}
+	level := []*node{root}
+	processed := 0
+	var unpackedBytes int64
+	for len(level) > 0 {
+		logrus.Debugf("%v documents left in queue", len(level))
+		processed += len(level)
+		if processed > opts.MaxDocuments {
+			return res, &LimitError{Err: ErrMaxDocumentsExceeded}
+		}
+
+		outs, err := processLevel(ctx, level, opts)
 		if err != nil {
-			continue
+			return res, err
 		}
 
-		logrus.Debugf("unpacked document to %v documents", len(ds))
-		if len(ds) > 0 {
-			docsToUnpack = append(docsToUnpack, ds...)
-		} else {
-			dd.SourceInformation = i.SourceInformation
-			finalDocs = append(finalDocs, dd)
+		next := []*node{}
+		for j, out := range outs {
+			n := level[j]
+			if out.err == nil && len(out.docs) > opts.MaxChildren {
+				out.err = &LimitError{Err: ErrMaxChildrenExceeded}
+			}
+			n.trustInfo = out.trustInfo
+			dr := DocumentResult{
+				Document:  n.doc,
+				Outcome:   outcomeOf(out.err),
+				Err:       out.err,
+				TrustInfo: out.trustInfo,
+			}
+			res.Results = append(res.Results, dr)
+			n.tree.DocumentResult = dr
+			if out.err != nil {
+				if opts.Strict {
+					return res, out.err
+				}
+				logrus.Warnf("dropping document (%s): %v", outcomeOf(out.err), out.err)
+				continue
+			}
+
+			logrus.Debugf("unpacked document to %v documents", len(out.docs))
+			if len(out.docs) == 0 || embeds(opts.Registry, n.doc) {
+				n.doc.SourceInformation = n.sourceInformation()
+				res.Documents = append(res.Documents, n.doc)
+			}
+			for _, d := range out.docs {
+				unpackedBytes += int64(len(d.Blob))
+				if unpackedBytes > opts.MaxTotalBytes {
+					return res, &LimitError{Err: ErrMaxTotalBytesExceeded}
+				}
+				next = append(next, n.child(d, opts.MaxDepth))
+			}
 		}
+		level = next
 	}
-	return finalDocs, nil
+	return res, nil
 }
 
-func processDocument(i *processor.Document) ([]*processor.Document, error) {
-	if err := validateFormat(i); err != nil {
+// node is a document in the unpacking tree
+type node struct {
+	doc    *processor.Document
+	parent *node
+	depth  int
+	digest [sha256.Size]byte
+	tree   *TreeNode
+	// err is set if the document must not be processed
+	err error
+	// trustInfo is the trust information of the document, merged over
+	// the trust information of its ancestors
+	trustInfo map[string]interface{}
+}
+
+// newNode creates the node of a document, setting the document ID and
+// parent
+func newNode(d *processor.Document, parent *node) *node {
+	n := &node{
+		doc:    d,
+		parent: parent,
+		digest: sha256.Sum256(d.Blob),
+		tree:   &TreeNode{DocumentResult: DocumentResult{Document: d}},
+	}
+	d.ID = "sha256:" + hex.EncodeToString(n.digest[:])
+	if parent != nil {
+		n.depth = parent.depth + 1
+		d.Parent = parent.doc
+		parent.tree.Children = append(parent.tree.Children, n.tree)
+	}
+	return n
+}
+
+// child creates the node of a document unpacked from n, checking it
+// against the depth limit and the ancestors of n for cycles
+func (n *node) child(d *processor.Document, maxDepth int) *node {
+	c := newNode(d, n)
+	if c.depth > maxDepth {
+		c.err = &LimitError{Err: ErrMaxDepthExceeded}
+		return c
+	}
+	for a := n; a != nil; a = a.parent {
+		if a.digest == c.digest {
+			c.err = &LimitError{Err: ErrCycleDetected}
+			break
+		}
+	}
+	return c
+}
+
+// sourceInformation returns the source information of the document, or of
+// its closest ancestor setting one, such as an archive member
+func (n *node) sourceInformation() processor.SourceInformation {
+	for a := n; a != nil; a = a.parent {
+		if a.doc.SourceInformation != (processor.SourceInformation{}) {
+			return a.doc.SourceInformation
+		}
+	}
+	return processor.SourceInformation{}
+}
+
+type processOutput struct {
+	docs      []*processor.Document
+	trustInfo map[string]interface{}
+	err       error
+}
+
+// processLevel processes the nodes concurrently, returning their outputs
+// in the order of the input.
+func processLevel(ctx context.Context, nodes []*node, opts Options) ([]processOutput, error) {
+	outs := make([]processOutput, len(nodes))
+	workers := opts.Workers
+	if workers > len(nodes) {
+		workers = len(nodes)
+	}
+
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range idx {
+				if nodes[j].err != nil {
+					outs[j].err = nodes[j].err
+					continue
+				}
+				var parentTrustInfo map[string]interface{}
+				if nodes[j].parent != nil {
+					parentTrustInfo = nodes[j].parent.trustInfo
+				}
+				outs[j].docs, outs[j].trustInfo, outs[j].err = processDocument(nodes[j].doc, opts, parentTrustInfo)
+			}
+		}()
+	}
+
+feed:
+	for j := range nodes {
+		select {
+		case idx <- j:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(idx)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	return outs, nil
+}
 
-	trustInfo, err := validateDocument(i)
+// processDocument validates the document and evaluates the policy, it
+// returns the unpacked documents and the trust information of the document
+func processDocument(i *processor.Document, opts Options, parentTrustInfo map[string]interface{}) ([]*processor.Document, map[string]interface{}, error) {
+	if err := guesser.GuessDocument(i); err != nil {
+		return nil, nil, &FormatError{Err: err}
+	}
+
+	if err := validateFormat(i); err != nil {
+		return nil, nil, &FormatError{Err: err}
+	}
+
+	trustInfo, err := validateDocument(opts.Registry, i)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// pass trustInfo into policy
-	_ = trustInfo
+	logTrustInfo, err := verifyLogEntry(opts.Registry, opts.LogVerifier, i)
+	if err != nil {
+		return nil, nil, err
+	}
+	trustInfo = mergeTrustInfo(trustInfo, logTrustInfo)
+
+	trustInfo, err = evaluatePolicy(opts.Policy, i, parentTrustInfo, trustInfo)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	ds, err := unpackDocument(i)
+	ds, err := unpackDocument(opts.Registry, i)
 	if err != nil {
-		return nil, fmt.Errorf("unable to unpack document: %w", err)
+		return nil, nil, &UnpackError{Err: err}
 	}
 
-	return ds, nil
+	return ds, trustInfo, nil
 }
 
 func validateFormat(i *processor.Document) error {
@@ -91,50 +331,122 @@ func validateFormat(i *processor.Document) error {
 			return fmt.Errorf("invalid JSON document")
 		}
 		break
+	case processor.FormatTagValue:
+		if _, err := spdx.ParseTagValues(i.Blob); err != nil {
+			return fmt.Errorf("invalid tag-value document: %w", err)
+		}
+		break
+	case processor.FormatXML:
+		if !validXML(i.Blob) {
+			return fmt.Errorf("invalid XML document")
+		}
+		break
+	case processor.FormatTar:
+		if _, err := tar.NewReader(bytes.NewReader(i.Blob)).Next(); err != nil && err != io.EOF {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		break
+	case processor.FormatZip:
+		if _, err := zip.NewReader(bytes.NewReader(i.Blob), int64(len(i.Blob))); err != nil {
+			return fmt.Errorf("invalid zip archive: %w", err)
+		}
+		break
+	case processor.FormatGzip:
+		if _, err := gzip.NewReader(bytes.NewReader(i.Blob)); err != nil {
+			return fmt.Errorf("invalid gzip archive: %w", err)
+		}
+		break
 	default:
 		return fmt.Errorf("invalid document format type: %v", i.Format)
 	}
 	return nil
 }
 
-func validateDocument(i *processor.Document) (map[string]interface{}, error) {
-	p, ok := documentProcessors[i.Type]
+// validXML checks that the blob is a well-formed XML document with a
+// single root element
+func validXML(b []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	roots, depth := 0, 0
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return roots == 1 && depth == 0
+		}
+		if err != nil {
+			return false
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(tok)) > 0 {
+				return false
+			}
+		}
+	}
+}
+
+func validateDocument(r *processor.Registry, i *processor.Document) (map[string]interface{}, error) {
+	p, ok := r.Lookup(i.Type)
 	if !ok {
-		return nil, fmt.Errorf("no document processor registered for type: %s", i.Type)
+		return nil, &SchemaError{Err: fmt.Errorf("no document processor registered for type: %s", i.Type)}
+	}
+	if err := r.Check(i); err != nil {
+		return nil, &FormatError{Err: err}
 	}
 
 	if err := p.ValidateSchema(i); err != nil {
-		return nil, fmt.Errorf("error validating document schema: %w", err)
+		return nil, &SchemaError{Err: err}
 	}
 
 	trustInfo, err := p.ValidateTrustInformation(i)
 	if err != nil {
-		return nil, fmt.Errorf("error validating trust information: %w", err)
+		return nil, &PolicyError{Err: fmt.Errorf("error validating trust information: %w", err)}
 	}
 
 	return trustInfo, nil
 }
 
-func unpackDocument(i *processor.Document) ([]*processor.Document, error) {
-	p, ok := documentProcessors[i.Type]
+func unpackDocument(r *processor.Registry, i *processor.Document) ([]*processor.Document, error) {
+	p, ok := r.Lookup(i.Type)
 	if !ok {
 		return nil, fmt.Errorf("no document processor registered for type: %s", i.Type)
 	}
 	return p.Unpack(i)
 }
 
+// embeds returns whether the documents unpacked from i are embedded in it
+func embeds(r *processor.Registry, i *processor.Document) bool {
+	p, ok := r.Lookup(i.Type)
+	if !ok {
+		return false
+	}
+	e, ok := p.(processor.Embedder)
+	return ok && e.Embeds(i)
+}
+
 func validate(i *processor.Document) (bool, error) {
+	if err := guesser.GuessDocument(i); err != nil {
+		return false, &FormatError{Err: err}
+	}
+
 	if err := validateFormat(i); err != nil {
-		return false, err
+		return false, &FormatError{Err: err}
 	}
 
-	trustInfo, err := validateDocument(i)
+	trustInfo, err := validateDocument(defaultRegistry, i)
 	if err != nil {
 		return false, err
 	}
 
-	// pass trustInfo into policy
-	_ = trustInfo
+	if _, err := evaluatePolicy(&AllowAll{}, i, nil, trustInfo); err != nil {
+		return false, err
+	}
 
 	return true, nil
 }
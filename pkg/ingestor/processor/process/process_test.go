@@ -16,9 +16,19 @@
 package process
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/guacsec/guac/internal/testing/ingestor/simpledoc"
 	"github.com/guacsec/guac/pkg/ingestor/processor"
@@ -455,6 +465,8 @@ func Test_SimpleDocProcessTest(t *testing.T) {
 }
 
 func existAndPop(docs []processor.Document, d processor.Document) bool {
+	// Lineage is checked separately in Test_ProcessLineage
+	d.ID, d.Parent = "", nil
 	for i, dd := range docs {
 		d.Blob = consistentJsonBytes(d.Blob)
 		dd.Blob = consistentJsonBytes(dd.Blob)
@@ -481,3 +493,667 @@ func consistentJsonBytes(b []byte) []byte {
 	out, _ := json.Marshal(v)
 	return out
 }
+
+func Test_validateFormat(t *testing.T) {
+	testCases := []struct {
+		name      string
+		blob      string
+		format    processor.FormatType
+		expectErr bool
+	}{{
+		name:   "json",
+		blob:   `{"a": "b"}`,
+		format: processor.FormatJSON,
+	}, {
+		name:      "bad json",
+		blob:      `{"a": "b"`,
+		format:    processor.FormatJSON,
+		expectErr: true,
+	}, {
+		name:   "tag-value",
+		blob:   "SPDXVersion: SPDX-2.3\nDataLicense: CC0-1.0\n",
+		format: processor.FormatTagValue,
+	}, {
+		name:      "bad tag-value",
+		blob:      "SPDXVersion SPDX-2.3\n",
+		format:    processor.FormatTagValue,
+		expectErr: true,
+	}, {
+		name:   "xml",
+		blob:   `<?xml version="1.0"?><bom><components/></bom>`,
+		format: processor.FormatXML,
+	}, {
+		name:      "unclosed xml",
+		blob:      `<bom><components></bom>`,
+		format:    processor.FormatXML,
+		expectErr: true,
+	}, {
+		name:      "multiple xml roots",
+		blob:      `<bom></bom><bom></bom>`,
+		format:    processor.FormatXML,
+		expectErr: true,
+	}, {
+		name:      "no xml root",
+		blob:      `just text`,
+		format:    processor.FormatXML,
+		expectErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFormat(&processor.Document{Blob: []byte(tt.blob), Format: tt.format})
+			if (err != nil) != tt.expectErr {
+				t.Errorf("got error %v, expected error %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func Test_ProcessGuessesTypeAndFormat(t *testing.T) {
+	doc := processor.Document{
+		Blob: []byte(`{
+			"spdxVersion": "SPDX-2.3",
+			"dataLicense": "CC0-1.0",
+			"SPDXID": "SPDXRef-DOCUMENT",
+			"name": "guac",
+			"documentNamespace": "https://example.com/guac"
+		}`),
+	}
+	docs, err := Process(&doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %v docs, expected 1", len(docs))
+	}
+	if docs[0].Type != processor.DocumentSPDX || docs[0].Format != processor.FormatJSON {
+		t.Errorf("got type %v format %v, expected SPDX JSON", docs[0].Type, docs[0].Format)
+	}
+}
+
+func Test_ProcessArchiveMemberSource(t *testing.T) {
+	sbom := []byte(`{
+		"spdxVersion": "SPDX-2.3",
+		"dataLicense": "CC0-1.0",
+		"SPDXID": "SPDXRef-DOCUMENT",
+		"name": "guac",
+		"documentNamespace": "https://example.com/guac"
+	}`)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "sboms/guac.spdx.json", Mode: 0644, Size: int64(len(sbom)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(sbom); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := processor.Document{
+		Blob: buf.Bytes(),
+		SourceInformation: processor.SourceInformation{
+			Collector: "a-collector",
+			Source:    "a-source",
+		},
+	}
+	docs, err := Process(&doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %v docs, expected 1", len(docs))
+	}
+	expected := processor.SourceInformation{Collector: "a-collector", Source: "a-source/sboms/guac.spdx.json"}
+	if docs[0].SourceInformation != expected {
+		t.Errorf("got source %+v, expected %+v", docs[0].SourceInformation, expected)
+	}
+}
+
+func Test_ProcessEmbeddedBOM(t *testing.T) {
+	inner := `{"bomFormat": "CycloneDX", "specVersion": "1.4", "version": 1,
+		"components": [{"type": "library", "name": "inner"}]}`
+	outer := `{"bomFormat": "CycloneDX", "specVersion": "1.4", "version": 1,
+		"components": [{"type": "library", "name": "outer",
+			"externalReferences": [{"type": "bom", "url": "data:application/vnd.cyclonedx+json;base64,` +
+		base64.StdEncoding.EncodeToString([]byte(inner)) + `"}]}]}`
+
+	doc := processor.Document{
+		Blob: []byte(outer),
+		SourceInformation: processor.SourceInformation{
+			Collector: "a-collector",
+			Source:    "a-source",
+		},
+	}
+	docs, err := Process(&doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("got %v docs, expected 2", len(docs))
+	}
+	for i, expected := range []string{outer, inner} {
+		if string(docs[i].Blob) != expected {
+			t.Errorf("got doc %v %s, expected %s", i, docs[i].Blob, expected)
+		}
+		if docs[i].Type != processor.DocumentCycloneDX {
+			t.Errorf("got doc %v type %v, expected %v", i, docs[i].Type, processor.DocumentCycloneDX)
+		}
+		if docs[i].SourceInformation != doc.SourceInformation {
+			t.Errorf("got doc %v source %+v, expected %+v", i, docs[i].SourceInformation, doc.SourceInformation)
+		}
+	}
+}
+
+func Test_ProcessWithOptions(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+	testCases := []struct {
+		name             string
+		doc              processor.Document
+		strict           bool
+		expectedOutcomes []Outcome
+		expectedErr      interface{}
+	}{{
+		name: "accepted",
+		doc: processor.Document{
+			Blob:   []byte(`{"issuer": "google.com", "nested": [{"issuer": "google.com"}]}`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+		},
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted},
+	}, {
+		name: "invalid format",
+		doc: processor.Document{
+			Blob:   []byte(`{ NOT JSON`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+		},
+		expectedOutcomes: []Outcome{OutcomeInvalidFormat},
+	}, {
+		name: "schema error",
+		doc: processor.Document{
+			Blob:   []byte(`{"info": "no issuer"}`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+		},
+		expectedOutcomes: []Outcome{OutcomeSchemaError},
+	}, {
+		name: "no processor",
+		doc: processor.Document{
+			Blob:   []byte(`{"issuer": "google.com"}`),
+			Type:   "invalid-document-type",
+			Format: processor.FormatJSON,
+		},
+		expectedOutcomes: []Outcome{OutcomeSchemaError},
+	}, {
+		name: "nested rejected by policy",
+		doc: processor.Document{
+			Blob:   []byte(`{"issuer": "google.com", "nested": [{"issuer": "bing.com"}, {"issuer": "google.com"}]}`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+			TrustInformation: processor.TrustInformation{
+				IssuerUri: ptrStr("google.com"),
+			},
+		},
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeRejectedByPolicy, OutcomeAccepted},
+	}, {
+		name: "strict fails on nested policy rejection",
+		doc: processor.Document{
+			Blob:   []byte(`{"issuer": "google.com", "nested": [{"issuer": "bing.com"}, {"issuer": "google.com"}]}`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+			TrustInformation: processor.TrustInformation{
+				IssuerUri: ptrStr("google.com"),
+			},
+		},
+		strict:           true,
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeRejectedByPolicy},
+		expectedErr:      new(*PolicyError),
+	}, {
+		name: "strict fails on invalid format",
+		doc: processor.Document{
+			Blob:   []byte(`{ NOT JSON`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+		},
+		strict:           true,
+		expectedOutcomes: []Outcome{OutcomeInvalidFormat},
+		expectedErr:      new(*FormatError),
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ProcessWithOptions(&tt.doc, Options{Strict: tt.strict, Registry: r})
+			if tt.expectedErr != nil {
+				if err == nil || !errors.As(err, tt.expectedErr) {
+					t.Fatalf("got error %v, expected %T", err, tt.expectedErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			outcomes := []Outcome{}
+			for _, dr := range res.Results {
+				outcomes = append(outcomes, dr.Outcome)
+				if (dr.Outcome == OutcomeAccepted) != (dr.Err == nil) {
+					t.Errorf("outcome %v does not match error %v", dr.Outcome, dr.Err)
+				}
+			}
+			if !reflect.DeepEqual(outcomes, tt.expectedOutcomes) {
+				t.Errorf("got outcomes %v, expected %v", outcomes, tt.expectedOutcomes)
+			}
+		})
+	}
+}
+
+// slowDocProc is a simpledoc processor which takes a while to validate
+type slowDocProc struct {
+	simpledoc.SimpleDocProc
+	delay time.Duration
+}
+
+const slowDocType processor.DocumentType = "slow-doc"
+
+func (dp *slowDocProc) ValidateSchema(d *processor.Document) error {
+	time.Sleep(dp.delay)
+	return dp.SimpleDocProc.ValidateSchema(d)
+}
+
+func (dp *slowDocProc) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	docs, err := dp.SimpleDocProc.Unpack(d)
+	for _, nd := range docs {
+		nd.Type = slowDocType
+	}
+	return docs, err
+}
+
+func nestedSimpleDoc(depth, width int, info string) simpledoc.SimpleDoc {
+	sd := simpledoc.SimpleDoc{Issuer: "google.com", Info: info}
+	if depth == 0 {
+		return sd
+	}
+	for i := 0; i < width; i++ {
+		sd.Nested = append(sd.Nested, nestedSimpleDoc(depth-1, width, fmt.Sprintf("%s.%d", info, i)))
+	}
+	return sd
+}
+
+func Test_ProcessContextDeterministicOrder(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&slowDocProc{delay: time.Millisecond}, slowDocType)
+	b, err := json.Marshal(nestedSimpleDoc(3, 4, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []string
+	for _, workers := range []int{1, 2, 8, 64} {
+		res, err := ProcessContext(context.Background(), &processor.Document{
+			Blob:   b,
+			Type:   slowDocType,
+			Format: processor.FormatJSON,
+		}, Options{Workers: workers, Registry: r})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for _, d := range res.Documents {
+			var sd simpledoc.SimpleDoc
+			if err := json.Unmarshal(d.Blob, &sd); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, sd.Info)
+		}
+		if len(got) != 64 {
+			t.Fatalf("got %v leaves, expected 64", len(got))
+		}
+		if expected == nil {
+			expected = got
+		} else if !reflect.DeepEqual(got, expected) {
+			t.Errorf("workers=%d: got order %v, expected %v", workers, got, expected)
+		}
+	}
+}
+
+func Test_ProcessContextCancellation(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&slowDocProc{delay: 20 * time.Millisecond}, slowDocType)
+	b, err := json.Marshal(nestedSimpleDoc(2, 20, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := func() *processor.Document {
+		return &processor.Document{Blob: b, Type: slowDocType, Format: processor.FormatJSON}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ProcessContext(ctx, doc(), Options{Registry: r}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := ProcessContext(ctx, doc(), Options{Workers: 1, Registry: r}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+	// Processing all 421 documents serially would take over 8 seconds
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("processing was not interrupted, took %v", elapsed)
+	}
+}
+
+// selfDocProc is a simpledoc processor which unpacks a document to itself
+type selfDocProc struct {
+	simpledoc.SimpleDocProc
+}
+
+const selfDocType processor.DocumentType = "self-doc"
+
+func (dp *selfDocProc) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	return []*processor.Document{{
+		Blob:   append([]byte{}, d.Blob...),
+		Type:   selfDocType,
+		Format: processor.FormatJSON,
+	}}, nil
+}
+
+func Test_ProcessLimits(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+	_ = r.Register(&selfDocProc{}, selfDocType)
+
+	simpleDoc := func(depth, width int) processor.Document {
+		b, err := json.Marshal(nestedSimpleDoc(depth, width, "root"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return processor.Document{Blob: b, Type: simpledoc.SimpleDocType, Format: processor.FormatJSON}
+	}
+
+	testCases := []struct {
+		name          string
+		doc           processor.Document
+		opts          Options
+		expectedLimit error
+		expectErr     bool
+	}{{
+		name:          "max depth",
+		doc:           simpleDoc(3, 1),
+		opts:          Options{MaxDepth: 2},
+		expectedLimit: ErrMaxDepthExceeded,
+	}, {
+		name: "within max depth",
+		doc:  simpleDoc(3, 1),
+		opts: Options{MaxDepth: 3},
+	}, {
+		name:          "max children",
+		doc:           simpleDoc(1, 5),
+		opts:          Options{MaxChildren: 4},
+		expectedLimit: ErrMaxChildrenExceeded,
+	}, {
+		name: "within max children",
+		doc:  simpleDoc(1, 5),
+		opts: Options{MaxChildren: 5},
+	}, {
+		name:          "max documents",
+		doc:           simpleDoc(2, 3),
+		opts:          Options{MaxDocuments: 12},
+		expectedLimit: ErrMaxDocumentsExceeded,
+		expectErr:     true,
+	}, {
+		name: "within max documents",
+		doc:  simpleDoc(2, 3),
+		opts: Options{MaxDocuments: 13},
+	}, {
+		name:          "max total bytes",
+		doc:           simpleDoc(2, 3),
+		opts:          Options{MaxTotalBytes: 64},
+		expectedLimit: ErrMaxTotalBytesExceeded,
+		expectErr:     true,
+	}, {
+		name: "cycle",
+		doc: processor.Document{
+			Blob:   []byte(`{"issuer": "google.com"}`),
+			Type:   selfDocType,
+			Format: processor.FormatJSON,
+		},
+		expectedLimit: ErrCycleDetected,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Registry = r
+			res, err := ProcessWithOptions(&tt.doc, opts)
+			if tt.expectErr {
+				if !errors.Is(err, tt.expectedLimit) {
+					t.Fatalf("got error %v, expected %v", err, tt.expectedLimit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			failed := res.Failed()
+			if tt.expectedLimit == nil {
+				if len(failed) != 0 {
+					t.Errorf("unexpected failures: %v", failed)
+				}
+				return
+			}
+			if len(failed) != 1 {
+				t.Fatalf("got %v failures, expected 1", len(failed))
+			}
+			var limitErr *LimitError
+			if !errors.As(failed[0].Err, &limitErr) || !errors.Is(limitErr, tt.expectedLimit) {
+				t.Errorf("got error %v, expected %v", failed[0].Err, tt.expectedLimit)
+			}
+			if failed[0].Outcome != OutcomeLimitExceeded {
+				t.Errorf("got outcome %v, expected %v", failed[0].Outcome, OutcomeLimitExceeded)
+			}
+		})
+	}
+}
+
+// tgz returns a gzipped tarball of the files
+func tgz(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, b := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_ProcessNestedArchiveBomb(t *testing.T) {
+	inner := tgz(t, map[string][]byte{"zeros": make([]byte, 1<<20)})
+	files := map[string][]byte{}
+	for i := 0; i < 8; i++ {
+		files[fmt.Sprintf("inner-%d.tar.gz", i)] = inner
+	}
+	bomb := tgz(t, files)
+
+	testCases := []struct {
+		name      string
+		maxBytes  int64
+		expectErr bool
+	}{{
+		name:      "exceeds total bytes",
+		maxBytes:  4 << 20,
+		expectErr: true,
+	}, {
+		name:     "within total bytes",
+		maxBytes: 32 << 20,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := processor.Document{Blob: bomb}
+			_, err := ProcessWithOptions(&doc, Options{MaxTotalBytes: tt.maxBytes})
+			if tt.expectErr {
+				var limitErr *LimitError
+				if !errors.As(err, &limitErr) || !errors.Is(err, ErrMaxTotalBytesExceeded) {
+					t.Fatalf("got error %v, expected %v", err, ErrMaxTotalBytesExceeded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_ProcessLineage(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+	b, err := json.Marshal(nestedSimpleDoc(2, 2, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &processor.Document{
+		Blob:   b,
+		Type:   simpledoc.SimpleDocType,
+		Format: processor.FormatJSON,
+		SourceInformation: processor.SourceInformation{
+			Collector: "a-collector",
+			Source:    "a-source",
+		},
+	}
+
+	res, err := ProcessWithOptions(doc, Options{KeepTree: true, Registry: r})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Documents) != 4 {
+		t.Fatalf("got %v docs, expected 4", len(res.Documents))
+	}
+
+	digest := sha256.Sum256(b)
+	if expected := "sha256:" + hex.EncodeToString(digest[:]); doc.ID != expected {
+		t.Errorf("got root ID %v, expected %v", doc.ID, expected)
+	}
+	for _, d := range res.Documents {
+		lineage := d.Lineage()
+		if len(lineage) != 3 {
+			t.Fatalf("got lineage of length %v, expected 3", len(lineage))
+		}
+		if lineage[0] != doc || lineage[2] != d {
+			t.Errorf("lineage should go from the root to the document")
+		}
+		if lineage[1].ID == "" || lineage[1].Parent != doc {
+			t.Errorf("intermediate document lineage not set")
+		}
+	}
+
+	if res.Tree == nil || res.Tree.Document != doc {
+		t.Fatalf("expected tree rooted at the input document")
+	}
+	if len(res.Tree.Children) != 2 {
+		t.Fatalf("got %v children, expected 2", len(res.Tree.Children))
+	}
+	for _, c := range res.Tree.Children {
+		if c.Outcome != OutcomeAccepted || c.Document.Parent != doc || len(c.Children) != 2 {
+			t.Errorf("unexpected tree node: %+v", c)
+		}
+		for _, gc := range c.Children {
+			if gc.Document.Parent != c.Document || len(gc.Children) != 0 {
+				t.Errorf("unexpected tree leaf: %+v", gc)
+			}
+		}
+	}
+
+	res, err = ProcessWithOptions(doc, Options{Registry: r})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Tree != nil {
+		t.Errorf("tree should only be kept if requested")
+	}
+}
+
+func Test_ProcessRegistry(t *testing.T) {
+	withSimpleDoc := NewRegistry()
+	if err := withSimpleDoc.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType); err != nil {
+		t.Fatal(err)
+	}
+	withoutSimpleDoc := NewRegistry()
+	if _, ok := withoutSimpleDoc.Lookup(processor.DocumentSPDX); !ok {
+		t.Errorf("expected built-in processors in new registry")
+	}
+
+	testCases := []struct {
+		name            string
+		registry        *processor.Registry
+		expectedOutcome Outcome
+	}{{
+		name:            "registered",
+		registry:        withSimpleDoc,
+		expectedOutcome: OutcomeAccepted,
+	}, {
+		name:            "not registered",
+		registry:        withoutSimpleDoc,
+		expectedOutcome: OutcomeSchemaError,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ProcessWithOptions(&processor.Document{
+				Blob:   []byte(`{"issuer": "google.com"}`),
+				Type:   simpledoc.SimpleDocType,
+				Format: processor.FormatJSON,
+			}, Options{Registry: tt.registry})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Results[0].Outcome != tt.expectedOutcome {
+				t.Errorf("got outcome %v, expected %v", res.Results[0].Outcome, tt.expectedOutcome)
+			}
+		})
+	}
+}
+
+func Test_ProcessUnsupportedFormat(t *testing.T) {
+	res, err := ProcessWithOptions(&processor.Document{
+		Blob:   []byte(`<spdx/>`),
+		Type:   processor.DocumentSPDX,
+		Format: processor.FormatXML,
+	}, Options{Registry: NewRegistry()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Results[0].Outcome != OutcomeInvalidFormat || !errors.Is(res.Results[0].Err, processor.ErrUnsupportedFormat) {
+		t.Errorf("got outcome %v with error %v, expected unsupported format", res.Results[0].Outcome, res.Results[0].Err)
+	}
+}
+
+func Test_BuiltinDescriptions(t *testing.T) {
+	r := NewRegistry()
+	for _, d := range r.List() {
+		desc, ok := r.Describe(d)
+		if !ok {
+			t.Errorf("built-in processor %s has no description", d)
+			continue
+		}
+		if len(desc.Formats) == 0 {
+			t.Errorf("built-in processor %s describes no format", d)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"runtime"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+)
+
+// DefaultWorkers is the number of workers used when Options.Workers is
+// not set
+var DefaultWorkers = runtime.NumCPU()
+
+// Default unpacking limits, used when the Options limits are not set
+const (
+	DefaultMaxDepth     = 32
+	DefaultMaxChildren  = 10000
+	DefaultMaxDocuments = 100000
+
+	DefaultMaxTotalBytes int64 = 512 << 20
+)
+
+// Outcome describes what happened to a document during processing
+type Outcome string
+
+// Outcome* is the enumerables of Outcome
+const (
+	OutcomeAccepted         Outcome = "accepted"
+	OutcomeRejectedByPolicy Outcome = "rejected-by-policy"
+	OutcomeInvalidFormat    Outcome = "invalid-format"
+	OutcomeSchemaError      Outcome = "schema-error"
+	OutcomeUnpackError      Outcome = "unpack-error"
+	OutcomeLimitExceeded    Outcome = "limit-exceeded"
+)
+
+// DocumentResult is the outcome of processing a single document, either
+// the input document or one unpacked from it
+type DocumentResult struct {
+	Document *processor.Document
+	Outcome  Outcome
+	// Err is the error that caused the document to be dropped, it is one
+	// of FormatError, SchemaError, PolicyError, UnpackError or LimitError
+	Err error
+	// TrustInfo is the trust information of an accepted document, merged
+	// over the trust information of its ancestors and including the
+	// policy annotations
+	TrustInfo map[string]interface{}
+}
+
+// TreeNode is a document of the unpacking tree along with the documents
+// it was unpacked to
+type TreeNode struct {
+	DocumentResult
+	Children []*TreeNode
+}
+
+// Result is the result of processing a document
+type Result struct {
+	// Documents are the accepted leaf documents
+	Documents []*processor.Document
+	// Results are the outcomes of every document processed, in
+	// processing order
+	Results []DocumentResult
+	// Tree is the unpacking tree rooted at the input document, it is only
+	// set if Options.KeepTree is set
+	Tree *TreeNode
+}
+
+// Failed returns the results of the documents that were not accepted
+func (r *Result) Failed() []DocumentResult {
+	failed := []DocumentResult{}
+	for _, dr := range r.Results {
+		if dr.Outcome != OutcomeAccepted {
+			failed = append(failed, dr)
+		}
+	}
+	return failed
+}
+
+// Options configure the processing of a document
+type Options struct {
+	// Strict fails the whole processing call on the first document that
+	// is not accepted, instead of dropping the document.
+	Strict bool
+	// Workers is the number of documents of a same unpacking level that
+	// are processed concurrently, DefaultWorkers if 0.
+	Workers int
+
+	// MaxDepth is the maximum unpacking depth, the input document being
+	// at depth 0. Documents unpacked deeper are dropped.
+	MaxDepth int
+	// MaxChildren is the maximum number of documents a single document
+	// may unpack to. Documents unpacking to more are dropped.
+	MaxChildren int
+	// MaxDocuments is the maximum number of documents processed in one
+	// call. Reaching it fails the whole call.
+	MaxDocuments int
+	// MaxTotalBytes is the maximum total size of the documents unpacked in
+	// one call, across all unpacking levels. Reaching it fails the whole
+	// call.
+	MaxTotalBytes int64
+
+	// Registry provides the document processors, DefaultRegistry() if nil
+	Registry *processor.Registry
+
+	// Policy decides which documents are accepted, AllowAll if nil
+	Policy Policy
+	// LogVerifier verifies the transparency log entries of documents.
+	// If nil, log entries are not verified.
+	LogVerifier *tlog.Verifier
+
+	// KeepTree returns the full unpacking tree in Result.Tree. This keeps
+	// every intermediate document in memory.
+	KeepTree bool
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = DefaultWorkers
+	}
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = DefaultMaxDepth
+	}
+	if o.MaxChildren <= 0 {
+		o.MaxChildren = DefaultMaxChildren
+	}
+	if o.MaxDocuments <= 0 {
+		o.MaxDocuments = DefaultMaxDocuments
+	}
+	if o.MaxTotalBytes <= 0 {
+		o.MaxTotalBytes = DefaultMaxTotalBytes
+	}
+	if o.Registry == nil {
+		o.Registry = defaultRegistry
+	}
+	if o.Policy == nil {
+		o.Policy = &AllowAll{}
+	}
+	return o
+}
@@ -0,0 +1,307 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/dsse"
+	"github.com/guacsec/guac/pkg/ingestor/processor/slsa"
+	dsselib "github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"gopkg.in/yaml.v3"
+)
+
+// TrustPolicy binds document sources to the signers and builders trusted
+// for them. It is loaded from a YAML or JSON file.
+//
+// A document from a source matching an entry with issuers or keys must be
+// signed by one of the keys, or be issued by one of the issuers, or be
+// unpacked from such a document. A SLSA provenance from a source matching
+// an entry with builders must have one of the builders as builder id.
+// Keyless signatures are verified against the certificateRoots PEM bundle
+// of root and intermediate certificates, the OIDC issuer of a signing
+// certificate being an issuer of the document. A document from a source
+// matching an entry with identities must be signed with a certificate whose
+// subject alternative name matches one of the identities patterns, where
+// * matches any sequence of characters, including /, and whose issuer is
+// one of the issuers if the entry has issuers.
+//
+// A document from a source matching an entry with requireLog must have a
+// transparency log entry verified against the configured log public key,
+// or be unpacked from such a document.
+// The first entry matching a source applies, documents from sources
+// matching no entry are accepted unless default is reject.
+//
+// Example policy file is
+//
+//	default: reject
+//	certificateRoots: fulcio.pem
+//	sources:
+//	- collector: file
+//	  source: /var/ci/*
+//	  keys:
+//	  - path: keys/ci.pub
+//	  issuers:
+//	  - https://accounts.google.com
+//	  identities:
+//	  - https://github.com/org/*
+//	  builders:
+//	  - https://github.com/slsa-framework/slsa-github-generator
+type TrustPolicy struct {
+	Default          string        `yaml:"default"`
+	CertificateRoots string        `yaml:"certificateRoots"`
+	Sources          []TrustSource `yaml:"sources"`
+
+	certVerifier *dsse.CertificateVerifier
+}
+
+// TrustSource is the trust configuration of the sources matching the
+// Collector and Source path.Match patterns, empty patterns match all
+type TrustSource struct {
+	Collector  string     `yaml:"collector"`
+	Source     string     `yaml:"source"`
+	Issuers    []string   `yaml:"issuers"`
+	Identities []string   `yaml:"identities"`
+	Keys       []TrustKey `yaml:"keys"`
+	Builders   []string   `yaml:"builders"`
+	// RequireLog requires a verified transparency log entry
+	RequireLog bool `yaml:"requireLog"`
+
+	keyIDs     []string
+	identities []*regexp.Regexp
+}
+
+// TrustKey is a PEM encoded public key, given inline or by path relative
+// to the policy file. If ID is empty, the key ID is derived from the key.
+type TrustKey struct {
+	ID   string `yaml:"id"`
+	Path string `yaml:"path"`
+	PEM  string `yaml:"pem"`
+
+	verifier dsselib.Verifier
+}
+
+// LoadTrustPolicyFile loads a TrustPolicy from a YAML or JSON file
+func LoadTrustPolicyFile(name string) (*TrustPolicy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTrustPolicy(b, filepath.Dir(name))
+}
+
+// ParseTrustPolicy parses and validates a YAML or JSON TrustPolicy, key
+// paths are relative to baseDir
+func ParseTrustPolicy(b []byte, baseDir string) (*TrustPolicy, error) {
+	var p TrustPolicy
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.Default == "" {
+		p.Default = ActionAllow
+	}
+	if err := validateAction(p.Default); err != nil {
+		return nil, err
+	}
+
+	if p.CertificateRoots != "" {
+		b, err := os.ReadFile(resolvePath(baseDir, p.CertificateRoots))
+		if err != nil {
+			return nil, err
+		}
+		if p.certVerifier, err = dsse.NewCertificateVerifier(b); err != nil {
+			return nil, fmt.Errorf("certificate roots: %w", err)
+		}
+	}
+
+	for i := range p.Sources {
+		s := &p.Sources[i]
+		for _, pattern := range []string{s.Collector, s.Source} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("source %d: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+		for _, pattern := range s.Identities {
+			s.identities = append(s.identities, identityPattern(pattern))
+		}
+		for j := range s.Keys {
+			k := &s.Keys[j]
+			if err := k.load(baseDir); err != nil {
+				return nil, fmt.Errorf("source %d: key %d: %w", i, j, err)
+			}
+			id, err := k.verifier.KeyID()
+			if err != nil {
+				return nil, fmt.Errorf("source %d: key %d: %w", i, j, err)
+			}
+			s.keyIDs = append(s.keyIDs, id)
+		}
+	}
+	return &p, nil
+}
+
+func (k *TrustKey) load(baseDir string) error {
+	b := []byte(k.PEM)
+	if k.Path != "" {
+		var err error
+		if b, err = os.ReadFile(resolvePath(baseDir, k.Path)); err != nil {
+			return err
+		}
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("one of path or pem is required")
+	}
+
+	pub, err := dsse.ParsePublicKey(b)
+	if err != nil {
+		return err
+	}
+	k.verifier, err = dsse.NewVerifier(k.ID, pub)
+	return err
+}
+
+func resolvePath(baseDir, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(baseDir, name)
+}
+
+// Verifiers returns the verifiers of all the keys in the policy, to be
+// used to verify DSSE envelopes
+func (p *TrustPolicy) Verifiers() []dsselib.Verifier {
+	var vs []dsselib.Verifier
+	for _, s := range p.Sources {
+		for _, k := range s.Keys {
+			vs = append(vs, k.verifier)
+		}
+	}
+	return vs
+}
+
+// CertificateVerifier returns the verifier of keyless signatures, nil if
+// the policy has no certificate roots
+func (p *TrustPolicy) CertificateVerifier() *dsse.CertificateVerifier {
+	return p.certVerifier
+}
+
+func (p *TrustPolicy) Evaluate(d *processor.Document, trustInfo map[string]interface{}) (PolicyDecision, error) {
+	source := d.Lineage()[0].SourceInformation
+	for _, s := range p.Sources {
+		if !s.matches(source) {
+			continue
+		}
+		if reason := s.check(d, trustInfo); reason != "" {
+			return PolicyDecision{Reason: reason}, nil
+		}
+		return PolicyDecision{Allow: true}, nil
+	}
+	return PolicyDecision{
+		Allow:  p.Default == ActionAllow,
+		Reason: fmt.Sprintf("no trust policy for source %s/%s", source.Collector, source.Source),
+	}, nil
+}
+
+func (s *TrustSource) matches(source processor.SourceInformation) bool {
+	if s.Collector != "" {
+		if ok, _ := path.Match(s.Collector, source.Collector); !ok {
+			return false
+		}
+	}
+	if s.Source != "" {
+		if ok, _ := path.Match(s.Source, source.Source); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// check returns the reason the document is not trusted, or "" if it is
+func (s *TrustSource) check(d *processor.Document, trustInfo map[string]interface{}) string {
+	certs, _ := trustInfo[dsse.TrustInfoCertificates].([]dsse.CertificateIdentity)
+	if len(s.Issuers) > 0 || len(s.keyIDs) > 0 {
+		signed := anyAllowed(trustValues(trustInfo["dsse_keyids"]), s.keyIDs)
+		if !signed && !s.issued(certs) {
+			return "document is not signed by a trusted key nor issued by a trusted issuer"
+		}
+	}
+
+	if len(s.identities) > 0 && !s.trustedIdentity(certs) {
+		return "document is not signed by a trusted identity"
+	}
+
+	if s.RequireLog && trustInfo[TrustInfoLogVerified] != true {
+		return "document has no verified transparency log entry"
+	}
+
+	if builder, ok := trustInfo[slsa.TrustInfoBuilderID]; ok && len(s.Builders) > 0 && d.Type == processor.DocumentSLSA {
+		if !anyAllowed(trustValues(builder), s.Builders) {
+			return fmt.Sprintf("SLSA builder %v is not trusted", builder)
+		}
+	}
+	return ""
+}
+
+// issued returns true if the document is signed with a verified
+// certificate of one of the issuers, which must also have a trusted
+// identity if the source has identities. The issuer claimed by the trust
+// information of the document is not trusted on its own.
+func (s *TrustSource) issued(certs []dsse.CertificateIdentity) bool {
+	for _, c := range certs {
+		if anyAllowed([]string{c.Issuer}, s.Issuers) && (len(s.identities) == 0 || anyMatch([]string{c.SAN}, s.identities)) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedIdentity returns true if one of the certificates has a trusted
+// identity, issued by one of the issuers if the source has issuers
+func (s *TrustSource) trustedIdentity(certs []dsse.CertificateIdentity) bool {
+	for _, c := range certs {
+		if anyMatch([]string{c.SAN}, s.identities) && (len(s.Issuers) == 0 || anyAllowed([]string{c.Issuer}, s.Issuers)) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityPattern compiles an identity pattern, where * matches any
+// sequence of characters
+func identityPattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// anyMatch returns true if one of the values matches one of the patterns
+func anyMatch(values []string, patterns []*regexp.Regexp) bool {
+	for _, v := range values {
+		for _, p := range patterns {
+			if p.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,303 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/internal/testing/ingestor/simpledoc"
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/dsse"
+	dsselib "github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+type ecdsaSigner struct {
+	dsselib.Verifier
+	key *ecdsa.PrivateKey
+}
+
+func (s *ecdsaSigner) Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func newECDSASigner(t *testing.T) (*ecdsaSigner, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := dsse.NewVerifier("", key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ecdsaSigner{Verifier: v, key: key}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func signedStatement(t *testing.T, s *ecdsaSigner, builder string) []byte {
+	statement := []byte(`{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"subject": [{"name": "img", "digest": {"sha256": "5678c7f7d3a8e5a5d1ea3a3c4d4e0b0f7d9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c"}}],
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate": {"builder": {"id": "` + builder + `"}, "buildType": "https://example.com/build"}
+	}`)
+	es, err := dsselib.NewEnvelopeSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := es.SignPayload(dsse.PayloadTypeInToto, statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func Test_TrustPolicy(t *testing.T) {
+	trusted, trustedPEM := newECDSASigner(t)
+	untrusted, _ := newECDSASigner(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ci.pub"), trustedPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	yamlPolicy := `
+default: reject
+sources:
+- collector: file
+  source: /var/ci/*
+  keys:
+  - path: ci.pub
+  builders:
+  - https://example.com/trusted-builder
+- collector: simple
+  issuers:
+  - google.com
+`
+	f := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(f, []byte(yamlPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tp, err := LoadTrustPolicyFile(f)
+	if err != nil {
+		t.Fatalf("unable to load trust policy: %v", err)
+	}
+	// JSON is valid YAML
+	jsonPolicy, err := ParseTrustPolicy([]byte(`{"sources": [{"collector": "file", "keys": [{"pem": `+
+		string(mustJSON(t, string(trustedPEM)))+`}]}]}`), dir)
+	if err != nil {
+		t.Fatalf("unable to parse JSON trust policy: %v", err)
+	}
+	if len(jsonPolicy.Verifiers()) != 1 {
+		t.Errorf("expected one verifier in JSON policy")
+	}
+
+	r := NewRegistry()
+	_ = r.Register(dsse.NewDSSEProcessor(append(tp.Verifiers(), untrusted)...), processor.DocumentDSSE)
+	_ = r.Register(&simpledoc.SimpleDocProc{}, simpledoc.SimpleDocType)
+
+	ciSource := processor.SourceInformation{Collector: "file", Source: "/var/ci/att.json"}
+	testCases := []struct {
+		name             string
+		doc              processor.Document
+		expectedOutcomes []Outcome
+	}{{
+		name: "trusted key and builder",
+		doc: processor.Document{
+			Blob:              signedStatement(t, trusted, "https://example.com/trusted-builder"),
+			Type:              processor.DocumentDSSE,
+			Format:            processor.FormatJSON,
+			SourceInformation: ciSource,
+		},
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted, OutcomeAccepted},
+	}, {
+		name: "untrusted builder",
+		doc: processor.Document{
+			Blob:              signedStatement(t, trusted, "https://example.com/other-builder"),
+			Type:              processor.DocumentDSSE,
+			Format:            processor.FormatJSON,
+			SourceInformation: ciSource,
+		},
+		expectedOutcomes: []Outcome{OutcomeAccepted, OutcomeAccepted, OutcomeRejectedByPolicy},
+	}, {
+		name: "key not trusted for source",
+		doc: processor.Document{
+			Blob:              signedStatement(t, untrusted, "https://example.com/trusted-builder"),
+			Type:              processor.DocumentDSSE,
+			Format:            processor.FormatJSON,
+			SourceInformation: ciSource,
+		},
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name: "unknown source",
+		doc: processor.Document{
+			Blob:              signedStatement(t, trusted, "https://example.com/trusted-builder"),
+			Type:              processor.DocumentDSSE,
+			Format:            processor.FormatJSON,
+			SourceInformation: processor.SourceInformation{Collector: "file", Source: "/tmp/att.json"},
+		},
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name: "issuer claimed without certificate",
+		doc: processor.Document{
+			Blob:   []byte(`{"issuer": "google.com", "nested": [{"issuer": "google.com"}]}`),
+			Type:   simpledoc.SimpleDocType,
+			Format: processor.FormatJSON,
+			TrustInformation: processor.TrustInformation{
+				IssuerUri: ptrStr("google.com"),
+			},
+			SourceInformation: processor.SourceInformation{Collector: "simple"},
+		},
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}, {
+		name: "no issuer",
+		doc: processor.Document{
+			Blob:              []byte(`{"issuer": "google.com"}`),
+			Type:              simpledoc.SimpleDocType,
+			Format:            processor.FormatJSON,
+			SourceInformation: processor.SourceInformation{Collector: "simple"},
+		},
+		expectedOutcomes: []Outcome{OutcomeRejectedByPolicy},
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ProcessWithOptions(&tt.doc, Options{Policy: tp, Registry: r})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			outcomes := []Outcome{}
+			for _, dr := range res.Results {
+				outcomes = append(outcomes, dr.Outcome)
+			}
+			if !reflect.DeepEqual(outcomes, tt.expectedOutcomes) {
+				t.Errorf("got outcomes %v, expected %v", outcomes, tt.expectedOutcomes)
+			}
+		})
+	}
+}
+
+func Test_TrustPolicyIdentities(t *testing.T) {
+	const (
+		githubIssuer = "https://token.actions.githubusercontent.com"
+		googleIssuer = "https://accounts.google.com"
+		workflow     = "https://github.com/org/repo/.github/workflows/release.yml@refs/heads/main"
+	)
+	tp, err := ParseTrustPolicy([]byte(`
+sources:
+- issuers:
+  - `+githubIssuer+`
+  - `+googleIssuer+`
+  identities:
+  - https://github.com/org/*
+  - mailto:*@example.com
+`), t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to parse trust policy: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		certs       []dsse.CertificateIdentity
+		expectAllow bool
+	}{{
+		name:        "github workflow identity",
+		certs:       []dsse.CertificateIdentity{{SAN: workflow, Issuer: githubIssuer}},
+		expectAllow: true,
+	}, {
+		name:        "email identity",
+		certs:       []dsse.CertificateIdentity{{SAN: "mailto:someone@example.com", Issuer: googleIssuer}},
+		expectAllow: true,
+	}, {
+		name:  "identity of another org",
+		certs: []dsse.CertificateIdentity{{SAN: "https://github.com/other/repo/.github/workflows/release.yml@refs/heads/main", Issuer: githubIssuer}},
+	}, {
+		name:  "identity with the pattern as prefix only",
+		certs: []dsse.CertificateIdentity{{SAN: "https://github.com/organization/repo/.github/workflows/release.yml@refs/heads/main", Issuer: githubIssuer}},
+	}, {
+		name:  "email identity of another domain",
+		certs: []dsse.CertificateIdentity{{SAN: "mailto:someone@example.com.evil", Issuer: googleIssuer}},
+	}, {
+		name:  "trusted identity from another issuer",
+		certs: []dsse.CertificateIdentity{{SAN: workflow, Issuer: "https://issuer.example.com"}},
+	}, {
+		name: "trusted identity and trusted issuer on different certificates",
+		certs: []dsse.CertificateIdentity{
+			{SAN: workflow, Issuer: "https://issuer.example.com"},
+			{SAN: "https://github.com/other/repo/.github/workflows/release.yml@refs/heads/main", Issuer: githubIssuer},
+		},
+	}, {
+		name: "no certificate",
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			trustInfo := map[string]interface{}{}
+			if tt.certs != nil {
+				trustInfo[dsse.TrustInfoCertificates] = tt.certs
+			}
+			decision, err := tp.Evaluate(&processor.Document{}, trustInfo)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allow != tt.expectAllow {
+				t.Errorf("got allow %v (%s), expected %v", decision.Allow, decision.Reason, tt.expectAllow)
+			}
+		})
+	}
+}
+
+func Test_ParseTrustPolicyErrors(t *testing.T) {
+	for name, policy := range map[string]string{
+		"invalid default": `default: maybe`,
+		"invalid pattern": `sources: [{source: "[a-"}]`,
+		"missing key":     `sources: [{keys: [{id: a}]}]`,
+		"bad key file":    `sources: [{keys: [{path: missing.pub}]}]`,
+		"bad pem":         `sources: [{keys: [{pem: "not a key"}]}]`,
+		"missing roots":   `certificateRoots: missing.pem`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseTrustPolicy([]byte(policy), t.TempDir()); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
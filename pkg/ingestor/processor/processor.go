@@ -16,9 +16,13 @@
 package processor
 
 import (
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
 	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 )
 
+// DocumentProcessor validates and unpacks documents of a DocumentType.
+// Documents are processed concurrently, so implementations must be safe
+// for concurrent use.
 type DocumentProcessor interface {
 	ValidateSchema(i *Document) error
 	ValidateTrustInformation(i *Document) (map[string]interface{}, error)
@@ -40,6 +44,23 @@ type Document struct {
 	Format            FormatType
 	TrustInformation  TrustInformation
 	SourceInformation SourceInformation
+
+	// ID is the content digest of the Blob, of the form sha256:<hex>.
+	// It is set when the document is processed.
+	ID string
+	// Parent is the document this document was unpacked from, nil for
+	// the document given to the processor.
+	Parent *Document
+}
+
+// Lineage returns the chain of documents this document was unpacked from,
+// starting with the root document and ending with the document itself
+func (d *Document) Lineage() []*Document {
+	var chain []*Document
+	for a := d; a != nil; a = a.Parent {
+		chain = append([]*Document{a}, chain...)
+	}
+	return chain
 }
 
 // DocumentType describes the type of the document contents for schema checks
@@ -47,9 +68,16 @@ type DocumentType string
 
 // Document* is the enumerables of DocumentType
 const (
-	DocumentSLSA DocumentType = "SLSA"
-	DocumentITE6              = "ITE6"
-	DocumentDSSE              = "DSSE"
+	DocumentSLSA           DocumentType = "SLSA"
+	DocumentITE6                        = "ITE6"
+	DocumentDSSE                        = "DSSE"
+	DocumentITE6Vul                     = "ITE6VUL"
+	DocumentSPDX                        = "SPDX"
+	DocumentCycloneDX                   = "CycloneDX"
+	DocumentOpenVEX                     = "OpenVEX"
+	DocumentArchive                     = "ARCHIVE"
+	DocumentSigstoreBundle              = "SIGSTORE_BUNDLE"
+	DocumentUnknown                     = "UNKNOWN"
 )
 
 // FormatType describes the document format for malform checks
@@ -57,15 +85,25 @@ type FormatType string
 
 // Format* is the enumerables of FormatType
 const (
-	FormatJSON FormatType = "JSON"
+	FormatJSON     FormatType = "JSON"
+	FormatTagValue            = "TAG_VALUE"
+	FormatXML                 = "XML"
+	FormatTar                 = "TAR"
+	FormatZip                 = "ZIP"
+	FormatGzip                = "GZIP"
+	FormatUnknown             = "UNKNOWN"
 )
 
 // TrustInformation provides additional information about how to verify the document
 type TrustInformation struct {
 	DSSE      *dsse.Envelope
 	IssuerUri *string
-	// TODO: Figure out how to handle log verification trust
-	// LogVerification *rtype.LogEntryAnonVerification
+	// Certificate is the PEM encoded signing certificate chain of the
+	// document, leaf first, for signatures not embedding their certificate
+	Certificate []byte
+	// LogEntry is the transparency log entry of the document, verified
+	// offline against the log public key
+	LogEntry *tlog.LogEntry
 }
 
 // TrustInformation provides additional information about where the document comes from
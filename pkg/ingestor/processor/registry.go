@@ -0,0 +1,128 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrProcessorExists is returned by a strict Registry when registering a
+// processor for a document type that already has one
+var ErrProcessorExists = errors.New("document processor already registered")
+
+// ErrUnsupportedFormat is returned by Registry.Check for documents whose
+// format is not supported by the processor of their type
+var ErrUnsupportedFormat = errors.New("document format not supported by processor")
+
+// Registry maps document types to their document processor. It is safe
+// for concurrent use, and its zero value is an empty non strict registry.
+type Registry struct {
+	// Strict makes Register fail on duplicate document types instead of
+	// overwriting the registered processor with a warning
+	Strict bool
+
+	mu         sync.RWMutex
+	processors map[DocumentType]DocumentProcessor
+}
+
+// NewRegistry returns an empty non strict registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register registers the processor for the document type
+func (r *Registry) Register(p DocumentProcessor, d DocumentType) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.processors[d]; ok {
+		if r.Strict {
+			return fmt.Errorf("%w: %s", ErrProcessorExists, d)
+		}
+		logrus.Warnf("the document processor is being overwritten: %s", d)
+	}
+	if r.processors == nil {
+		r.processors = map[DocumentType]DocumentProcessor{}
+	}
+	r.processors[d] = p
+	return nil
+}
+
+// Unregister removes the processor of the document type, and returns
+// whether there was one
+func (r *Registry) Unregister(d DocumentType) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.processors[d]
+	delete(r.processors, d)
+	return ok
+}
+
+// Lookup returns the processor of the document type
+func (r *Registry) Lookup(d DocumentType) (DocumentProcessor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.processors[d]
+	return p, ok
+}
+
+// List returns the registered document types, sorted
+func (r *Registry) List() []DocumentType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]DocumentType, 0, len(r.processors))
+	for d := range r.processors {
+		types = append(types, d)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Describe returns the description of the processor of the document type.
+// It returns false if there is no processor or if it is not a Describer.
+func (r *Registry) Describe(d DocumentType) (Description, bool) {
+	p, ok := r.Lookup(d)
+	if !ok {
+		return Description{}, false
+	}
+	desc, ok := p.(Describer)
+	if !ok {
+		return Description{}, false
+	}
+	return desc.Describe(), true
+}
+
+// Check returns an error wrapping ErrUnsupportedFormat if the processor of
+// the document type describes its formats and the document format is not
+// one of them. Documents whose processor is not a Describer pass.
+func (r *Registry) Check(d *Document) error {
+	desc, ok := r.Describe(d.Type)
+	if !ok {
+		return nil
+	}
+	if !desc.SupportsFormat(d.Format) {
+		return fmt.Errorf("%w: %s document in %s format", ErrUnsupportedFormat, d.Type, d.Format)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package processor
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type nopProcessor struct {
+	name string
+}
+
+func (p *nopProcessor) ValidateSchema(d *Document) error { return nil }
+
+func (p *nopProcessor) ValidateTrustInformation(d *Document) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (p *nopProcessor) Unpack(d *Document) ([]*Document, error) { return nil, nil }
+
+func Test_Registry(t *testing.T) {
+	first, second := &nopProcessor{name: "first"}, &nopProcessor{name: "second"}
+	testCases := []struct {
+		name          string
+		strict        bool
+		expectErr     error
+		expectedProcs DocumentProcessor
+	}{{
+		name:          "overwrite",
+		expectedProcs: second,
+	}, {
+		name:          "strict duplicate",
+		strict:        true,
+		expectErr:     ErrProcessorExists,
+		expectedProcs: first,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Strict = tt.strict
+			if err := r.Register(first, DocumentSPDX); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := r.Register(&nopProcessor{}, DocumentDSSE); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := r.Register(second, DocumentSPDX); !errors.Is(err, tt.expectErr) {
+				t.Fatalf("got error %v, expected %v", err, tt.expectErr)
+			}
+			if p, ok := r.Lookup(DocumentSPDX); !ok || p != tt.expectedProcs {
+				t.Errorf("got processor %v, expected %v", p, tt.expectedProcs)
+			}
+			if types := r.List(); !reflect.DeepEqual(types, []DocumentType{DocumentDSSE, DocumentSPDX}) {
+				t.Errorf("unexpected document types %v", types)
+			}
+
+			if !r.Unregister(DocumentSPDX) {
+				t.Errorf("expected processor to be unregistered")
+			}
+			if r.Unregister(DocumentSPDX) {
+				t.Errorf("expected no processor to unregister")
+			}
+			if _, ok := r.Lookup(DocumentSPDX); ok {
+				t.Errorf("expected no processor after unregister")
+			}
+			// the document type can be registered again
+			if err := r.Register(second, DocumentSPDX); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func Test_RegistryZeroValue(t *testing.T) {
+	var r Registry
+	if _, ok := r.Lookup(DocumentSPDX); ok {
+		t.Errorf("expected empty registry")
+	}
+	if len(r.List()) != 0 || r.Unregister(DocumentSPDX) {
+		t.Errorf("expected empty registry")
+	}
+	if err := r.Register(&nopProcessor{}, DocumentSPDX); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type describedProcessor struct {
+	nopProcessor
+}
+
+func (p *describedProcessor) Describe() Description {
+	return Description{
+		Formats:    []FormatType{FormatJSON},
+		Versions:   []string{"1.0"},
+		ChildTypes: []DocumentType{DocumentUnknown},
+	}
+}
+
+func Test_RegistryCheck(t *testing.T) {
+	r := NewRegistry()
+	_ = r.Register(&describedProcessor{}, DocumentSPDX)
+	_ = r.Register(&nopProcessor{}, DocumentDSSE)
+
+	testCases := []struct {
+		name      string
+		doc       Document
+		expectErr error
+	}{{
+		name: "supported format",
+		doc:  Document{Type: DocumentSPDX, Format: FormatJSON},
+	}, {
+		name:      "unsupported format",
+		doc:       Document{Type: DocumentSPDX, Format: FormatXML},
+		expectErr: ErrUnsupportedFormat,
+	}, {
+		name: "processor without description",
+		doc:  Document{Type: DocumentDSSE, Format: FormatXML},
+	}, {
+		name: "no processor",
+		doc:  Document{Type: DocumentCycloneDX, Format: FormatXML},
+	}}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.Check(&tt.doc); !errors.Is(err, tt.expectErr) {
+				t.Errorf("got error %v, expected %v", err, tt.expectErr)
+			}
+		})
+	}
+
+	if desc, ok := r.Describe(DocumentSPDX); !ok || !reflect.DeepEqual(desc.Versions, []string{"1.0"}) {
+		t.Errorf("unexpected description %v", desc)
+	}
+	if _, ok := r.Describe(DocumentDSSE); ok {
+		t.Errorf("expected no description for processor without Describe")
+	}
+}
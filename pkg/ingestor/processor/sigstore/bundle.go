@@ -0,0 +1,362 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sigstore
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/dsse"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	dsselib "github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+// MediaTypePrefix is the prefix of the media type of all Sigstore bundle
+// versions
+const MediaTypePrefix = "application/vnd.dev.sigstore.bundle"
+
+// Bundle is a Sigstore bundle, in its protobuf JSON encoding. Only bundles
+// with a DSSE envelope are supported.
+type Bundle struct {
+	MediaType            string                `json:"mediaType"`
+	VerificationMaterial *VerificationMaterial `json:"verificationMaterial"`
+	DSSEEnvelope         *dsselib.Envelope     `json:"dsseEnvelope"`
+	MessageSignature     json.RawMessage       `json:"messageSignature"`
+}
+
+// VerificationMaterial is the material to verify the bundle signature
+type VerificationMaterial struct {
+	PublicKey            *PublicKeyIdentifier   `json:"publicKey"`
+	X509CertificateChain *CertificateChain      `json:"x509CertificateChain"`
+	Certificate          *Certificate           `json:"certificate"`
+	TlogEntries          []TransparencyLogEntry `json:"tlogEntries"`
+}
+
+type PublicKeyIdentifier struct {
+	Hint string `json:"hint"`
+}
+
+type CertificateChain struct {
+	Certificates []Certificate `json:"certificates"`
+}
+
+// Certificate is a base64 encoded DER certificate
+type Certificate struct {
+	RawBytes string `json:"rawBytes"`
+}
+
+// TransparencyLogEntry is a Rekor log entry, 64 bit integers being encoded
+// as strings and hashes in base64 as in protobuf JSON
+type TransparencyLogEntry struct {
+	LogIndex int64Value `json:"logIndex"`
+	LogID    struct {
+		KeyID string `json:"keyId"`
+	} `json:"logId"`
+	IntegratedTime   int64Value `json:"integratedTime"`
+	InclusionPromise *struct {
+		SignedEntryTimestamp string `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	InclusionProof *struct {
+		LogIndex   int64Value `json:"logIndex"`
+		RootHash   string     `json:"rootHash"`
+		TreeSize   int64Value `json:"treeSize"`
+		Hashes     []string   `json:"hashes"`
+		Checkpoint struct {
+			Envelope string `json:"envelope"`
+		} `json:"checkpoint"`
+	} `json:"inclusionProof"`
+	CanonicalizedBody string `json:"canonicalizedBody"`
+}
+
+// int64Value is an int64 encoded either as a JSON number or string
+type int64Value int64
+
+func (v *int64Value) UnmarshalJSON(b []byte) error {
+	i, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 value %s", b)
+	}
+	*v = int64Value(i)
+	return nil
+}
+
+// BundleProcessor processes Sigstore bundles. The DSSE envelope of the
+// bundle is verified by the DSSE processor, with the bundle certificate
+// chain as signing certificate. Bundles signed with a certificate must
+// have a log entry verified by the log verifier of the DSSE processor, the
+// certificate being verified at the integrated time of the entry.
+//
+// The first transparency log entry of the bundle is set on the bundle
+// document, as being about the envelope payload. The payload is unpacked as
+// a child document, carrying the envelope, certificate chain and log entry
+// of the bundle in its TrustInformation.
+type BundleProcessor struct {
+	dsse *dsse.DSSEProcessor
+}
+
+// NewBundleProcessor creates a Sigstore bundle processor verifying the
+// bundle DSSE envelopes with the given DSSE processor
+func NewBundleProcessor(dp *dsse.DSSEProcessor) *BundleProcessor {
+	return &BundleProcessor{dsse: dp}
+}
+
+func (dp *BundleProcessor) ValidateSchema(d *processor.Document) error {
+	_, err := ParseBundle(d)
+	return err
+}
+
+// ValidateTrustInformation verifies the bundle log entry and envelope, and
+// fills in the DSSE envelope, certificate chain, log entry and issuer of the
+// document TrustInformation.
+func (dp *BundleProcessor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	b, err := ParseBundle(d)
+	if err != nil {
+		return nil, err
+	}
+	env, err := envelopeDocument(d, b)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.TrustInformation.Certificate) > 0 {
+		if err := dp.verifyLogEntry(b, env.TrustInformation.LogEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	trustInfo, err := dp.dsse.ValidateTrustInformation(env)
+	if err != nil {
+		return nil, err
+	}
+	d.TrustInformation.DSSE = env.TrustInformation.DSSE
+	d.TrustInformation.Certificate = env.TrustInformation.Certificate
+	d.TrustInformation.LogEntry = env.TrustInformation.LogEntry
+	d.TrustInformation.IssuerUri = env.TrustInformation.IssuerUri
+	return trustInfo, nil
+}
+
+// verifyLogEntry verifies the log entry dating the signature of a bundle
+// signed with a certificate, as being about the envelope payload
+func (dp *BundleProcessor) verifyLogEntry(b *Bundle, e *tlog.LogEntry) error {
+	v := dp.dsse.LogVerifier()
+	if v == nil {
+		return fmt.Errorf("no log verifier configured for certificate signed bundle")
+	}
+	if e == nil {
+		return fmt.Errorf("certificate signed bundle has no tlog entry")
+	}
+	payload, err := b.DSSEEnvelope.DecodeB64Payload()
+	if err != nil {
+		return fmt.Errorf("unable to decode DSSE payload: %w", err)
+	}
+	if err := v.Verify(e, payload); err != nil {
+		return fmt.Errorf("unable to verify bundle tlog entry: %w", err)
+	}
+	return nil
+}
+
+func (dp *BundleProcessor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	b, err := ParseBundle(d)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := b.DSSEEnvelope.DecodeB64Payload()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode DSSE payload: %w", err)
+	}
+
+	trustInfo := d.TrustInformation
+	trustInfo.DSSE = b.DSSEEnvelope
+	if trustInfo.Certificate, err = b.certificates(); err != nil {
+		return nil, err
+	}
+	// the log entry set by ValidateTrustInformation is kept, so that it is
+	// not verified again on the payload
+	if trustInfo.LogEntry == nil && len(b.VerificationMaterial.TlogEntries) > 0 {
+		if trustInfo.LogEntry, err = b.VerificationMaterial.TlogEntries[0].logEntry(); err != nil {
+			return nil, err
+		}
+	}
+	return []*processor.Document{{
+		Blob:             payload,
+		Type:             dsse.PayloadDocumentType(b.DSSEEnvelope.PayloadType),
+		Format:           processor.FormatJSON,
+		TrustInformation: trustInfo,
+	}}, nil
+}
+
+// LogEntryBlob returns the envelope payload, which the log entries of
+// bundles are about
+func (dp *BundleProcessor) LogEntryBlob(d *processor.Document) ([]byte, error) {
+	b, err := ParseBundle(d)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := b.DSSEEnvelope.DecodeB64Payload()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode DSSE payload: %w", err)
+	}
+	return payload, nil
+}
+
+func (dp *BundleProcessor) Describe() processor.Description {
+	return processor.Description{
+		Formats:    []processor.FormatType{processor.FormatJSON},
+		Versions:   []string{"0.1", "0.2", "0.3"},
+		ChildTypes: dsse.PayloadDocumentTypes(),
+	}
+}
+
+// ParseBundle parses and validates a Sigstore bundle document
+func ParseBundle(d *processor.Document) (*Bundle, error) {
+	if d.Format != processor.FormatJSON {
+		return nil, fmt.Errorf("only accept JSON formats")
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(d.Blob, &b); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(b.MediaType, MediaTypePrefix) {
+		return nil, fmt.Errorf("unsupported bundle media type %q", b.MediaType)
+	}
+	vm := b.VerificationMaterial
+	if vm == nil {
+		return nil, fmt.Errorf("bundle verificationMaterial shouldn't be empty")
+	}
+	if vm.PublicKey == nil && vm.X509CertificateChain == nil && vm.Certificate == nil {
+		return nil, fmt.Errorf("bundle verificationMaterial should have a publicKey, x509CertificateChain or certificate")
+	}
+	if b.DSSEEnvelope == nil {
+		if b.MessageSignature != nil {
+			return nil, fmt.Errorf("only DSSE envelope bundles are supported")
+		}
+		return nil, fmt.Errorf("bundle dsseEnvelope shouldn't be empty")
+	}
+	if b.DSSEEnvelope.PayloadType == "" || b.DSSEEnvelope.Payload == "" || len(b.DSSEEnvelope.Signatures) == 0 {
+		return nil, fmt.Errorf("bundle dsseEnvelope should have a payloadType, payload and signatures")
+	}
+	if _, err := b.certificates(); err != nil {
+		return nil, err
+	}
+	for i, e := range vm.TlogEntries {
+		if _, err := e.logEntry(); err != nil {
+			return nil, fmt.Errorf("tlog entry %d: %w", i, err)
+		}
+	}
+	return &b, nil
+}
+
+// envelopeDocument returns the DSSE envelope of the bundle as a document,
+// with the bundle certificate chain and log entry
+func envelopeDocument(d *processor.Document, b *Bundle) (*processor.Document, error) {
+	blob, err := json.Marshal(b.DSSEEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	env := &processor.Document{
+		Blob:             blob,
+		Type:             processor.DocumentDSSE,
+		Format:           processor.FormatJSON,
+		TrustInformation: d.TrustInformation,
+	}
+	if env.TrustInformation.Certificate, err = b.certificates(); err != nil {
+		return nil, err
+	}
+	if len(b.VerificationMaterial.TlogEntries) > 0 {
+		if env.TrustInformation.LogEntry, err = b.VerificationMaterial.TlogEntries[0].logEntry(); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// certificates returns the PEM encoded certificate chain of the bundle,
+// nil if the bundle is signed with a public key
+func (b *Bundle) certificates() ([]byte, error) {
+	var certs []Certificate
+	if c := b.VerificationMaterial.Certificate; c != nil {
+		certs = append(certs, *c)
+	}
+	if c := b.VerificationMaterial.X509CertificateChain; c != nil {
+		certs = append(certs, c.Certificates...)
+	}
+
+	var chain []byte
+	for _, c := range certs {
+		der, err := base64.StdEncoding.DecodeString(c.RawBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate encoding: %w", err)
+		}
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return chain, nil
+}
+
+// logEntry converts the entry to a tlog.LogEntry
+func (e *TransparencyLogEntry) logEntry() (*tlog.LogEntry, error) {
+	if e.CanonicalizedBody == "" {
+		return nil, fmt.Errorf("canonicalizedBody shouldn't be empty")
+	}
+	logID, err := base64ToHex(e.LogID.KeyID)
+	if err != nil || logID == "" {
+		return nil, fmt.Errorf("invalid logId")
+	}
+
+	le := &tlog.LogEntry{
+		Body:           e.CanonicalizedBody,
+		IntegratedTime: int64(e.IntegratedTime),
+		LogID:          logID,
+		LogIndex:       int64(e.LogIndex),
+		Verification:   &tlog.Verification{},
+	}
+	if e.InclusionPromise != nil {
+		le.Verification.SignedEntryTimestamp = e.InclusionPromise.SignedEntryTimestamp
+	}
+	if p := e.InclusionProof; p != nil {
+		root, err := base64ToHex(p.RootHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inclusion proof rootHash: %w", err)
+		}
+		hashes := make([]string, len(p.Hashes))
+		for i, h := range p.Hashes {
+			if hashes[i], err = base64ToHex(h); err != nil {
+				return nil, fmt.Errorf("invalid inclusion proof hash: %w", err)
+			}
+		}
+		le.Verification.InclusionProof = &tlog.InclusionProof{
+			LogIndex:   int64(p.LogIndex),
+			RootHash:   root,
+			TreeSize:   int64(p.TreeSize),
+			Hashes:     hashes,
+			Checkpoint: p.Checkpoint.Envelope,
+		}
+	}
+	return le, nil
+}
+
+func base64ToHex(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
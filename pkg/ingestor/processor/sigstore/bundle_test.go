@@ -0,0 +1,415 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sigstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+	"github.com/guacsec/guac/pkg/ingestor/processor/dsse"
+	"github.com/guacsec/guac/pkg/ingestor/processor/process"
+	"github.com/guacsec/guac/pkg/ingestor/tlog"
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	dsselib "github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+const (
+	testIdentity = "https://github.com/org/repo/.github/workflows/release.yml@refs/heads/main"
+	testIssuer   = "https://token.actions.githubusercontent.com"
+	testPayload  = `{"_type": "https://in-toto.io/Statement/v0.1", "subject": [], "predicateType": "x", "predicate": {}}`
+)
+
+type fixture struct {
+	root    *x509.Certificate
+	leaf    *x509.Certificate
+	leafKey *ecdsa.PrivateKey
+	logKey  *ecdsa.PrivateKey
+}
+
+func newFixture(t *testing.T) *fixture {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	root := createCert(t, rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(testIdentity)
+	issuer, _ := asn1.Marshal(testIssuer)
+	leaf := createCert(t, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       time.Now().Add(-5 * time.Minute),
+		NotAfter:        time.Now().Add(5 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{u},
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}, Value: issuer}},
+	}, root, leafKey.Public(), rootKey)
+
+	logKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{root: root, leaf: leaf, leafKey: leafKey, logKey: logKey}
+}
+
+func createCert(t *testing.T, tmpl, parent *x509.Certificate, pub crypto.PublicKey, key crypto.Signer) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, data []byte) string {
+	digest := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// bundle returns a bundle of the test payload signed with the leaf
+// certificate, with a log entry signed by the log key
+func (f *fixture) bundle(t *testing.T) []byte {
+	return f.bundleOf(t, []byte(testPayload))
+}
+
+// bundleOf returns a bundle of the payload signed with the leaf
+// certificate, with a log entry signed by the log key
+func (f *fixture) bundleOf(t *testing.T, payload []byte) []byte {
+	sig := sign(t, f.leafKey, dsselib.PAE(dsse.PayloadTypeInToto, payload))
+	env := &dsselib.Envelope{
+		PayloadType: dsse.PayloadTypeInToto,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsselib.Signature{{Sig: sig}},
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(f.logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	payloadHash := sha256.Sum256(payload)
+	body := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(
+		`{"apiVersion":"0.0.1","kind":"dsse","spec":{"payloadHash":{"algorithm":"sha256","value":"%s"},"signatures":[{"signature":"%s","verifier":"%s"}]}}`,
+		hex.EncodeToString(payloadHash[:]), sig,
+		base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.leaf.Raw})))))
+	integratedTime := time.Now().Add(-time.Minute).Unix()
+	set, err := cjson.EncodeCanonical(map[string]interface{}{
+		"body":           body,
+		"integratedTime": integratedTime,
+		"logID":          hex.EncodeToString(logID[:]),
+		"logIndex":       7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"mediaType": "application/vnd.dev.sigstore.bundle+json;version=0.2",
+		"verificationMaterial": map[string]interface{}{
+			"x509CertificateChain": map[string]interface{}{
+				"certificates": []map[string]string{{"rawBytes": base64.StdEncoding.EncodeToString(f.leaf.Raw)}},
+			},
+			"tlogEntries": []map[string]interface{}{{
+				"logIndex":          "7",
+				"logId":             map[string]string{"keyId": base64.StdEncoding.EncodeToString(logID[:])},
+				"kindVersion":       map[string]string{"kind": "dsse", "version": "0.0.1"},
+				"integratedTime":    fmt.Sprint(integratedTime),
+				"inclusionPromise":  map[string]string{"signedEntryTimestamp": sign(t, f.logKey, set)},
+				"canonicalizedBody": body,
+			}},
+		},
+		"dsseEnvelope": env,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func Test_BundleProcessor(t *testing.T) {
+	f := newFixture(t)
+	cv, err := dsse.NewCertificateVerifier(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.root.Raw}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logVerifier, err := tlog.NewVerifier(f.logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp := NewBundleProcessor(dsse.NewDSSEProcessor().WithCertificateVerifier(cv).WithLogVerifier(logVerifier))
+
+	d := &processor.Document{
+		Blob:   f.bundle(t),
+		Type:   processor.DocumentSigstoreBundle,
+		Format: processor.FormatJSON,
+	}
+	if err := dp.ValidateSchema(d); err != nil {
+		t.Fatalf("unexpected schema error: %v", err)
+	}
+	trustInfo, err := dp.ValidateTrustInformation(d)
+	if err != nil {
+		t.Fatalf("unexpected trust error: %v", err)
+	}
+	if ids, _ := trustInfo["dsse_cert_identities"].([]string); len(ids) != 1 || ids[0] != testIdentity {
+		t.Errorf("unexpected certificate identities %v", trustInfo["dsse_cert_identities"])
+	}
+	if d.TrustInformation.IssuerUri == nil || *d.TrustInformation.IssuerUri != testIssuer {
+		t.Errorf("unexpected issuer %v", d.TrustInformation.IssuerUri)
+	}
+	if d.TrustInformation.DSSE == nil || len(d.TrustInformation.Certificate) == 0 {
+		t.Errorf("expected envelope and certificate in trust information")
+	}
+
+	docs, err := dp.Unpack(d)
+	if err != nil {
+		t.Fatalf("unexpected unpack error: %v", err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 unpacked document, got %d", len(docs))
+	}
+	child := docs[0]
+	if string(child.Blob) != testPayload || child.Type != processor.DocumentITE6 {
+		t.Errorf("unexpected unpacked document %s of type %s", child.Blob, child.Type)
+	}
+	if child.TrustInformation.LogEntry == nil {
+		t.Fatalf("expected log entry in unpacked document trust information")
+	}
+	if err := logVerifier.Verify(child.TrustInformation.LogEntry, child.Blob); err != nil {
+		t.Errorf("unable to verify log entry: %v", err)
+	}
+
+	// a bundle signed by another root is not trusted
+	other := newFixture(t)
+	other.logKey = f.logKey
+	d = &processor.Document{
+		Blob:   other.bundle(t),
+		Type:   processor.DocumentSigstoreBundle,
+		Format: processor.FormatJSON,
+	}
+	if _, err := dp.ValidateTrustInformation(d); err == nil {
+		t.Errorf("expected error for bundle of untrusted root")
+	}
+}
+
+func Test_BundleLogVerification(t *testing.T) {
+	f := newFixture(t)
+	cv, err := dsse.NewCertificateVerifier(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.root.Raw}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logVerifier, err := tlog.NewVerifier(f.logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLog := newFixture(t)
+	otherLog.root, otherLog.leaf, otherLog.leafKey = f.root, f.leaf, f.leafKey
+
+	// modified returns the bundle with its log entries modified
+	modified := func(blob []byte, modify func(entries []TransparencyLogEntry) []TransparencyLogEntry) []byte {
+		var b Bundle
+		if err := json.Unmarshal(blob, &b); err != nil {
+			t.Fatal(err)
+		}
+		b.VerificationMaterial.TlogEntries = modify(b.VerificationMaterial.TlogEntries)
+		out, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	testCases := []struct {
+		name        string
+		blob        []byte
+		logVerifier *tlog.Verifier
+		expectErr   bool
+	}{{
+		name:        "verified log entry",
+		blob:        f.bundle(t),
+		logVerifier: logVerifier,
+	}, {
+		name:      "no log verifier",
+		blob:      f.bundle(t),
+		expectErr: true,
+	}, {
+		name:        "no log entry",
+		blob:        modified(f.bundle(t), func([]TransparencyLogEntry) []TransparencyLogEntry { return nil }),
+		logVerifier: logVerifier,
+		expectErr:   true,
+	}, {
+		name: "forged integrated time",
+		blob: modified(f.bundle(t), func(entries []TransparencyLogEntry) []TransparencyLogEntry {
+			entries[0].IntegratedTime -= 3600
+			return entries
+		}),
+		logVerifier: logVerifier,
+		expectErr:   true,
+	}, {
+		name:        "log entry of another log",
+		blob:        otherLog.bundle(t),
+		logVerifier: logVerifier,
+		expectErr:   true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := NewBundleProcessor(dsse.NewDSSEProcessor().WithCertificateVerifier(cv).WithLogVerifier(tt.logVerifier))
+			d := &processor.Document{
+				Blob:   tt.blob,
+				Type:   processor.DocumentSigstoreBundle,
+				Format: processor.FormatJSON,
+			}
+			_, err := dp.ValidateTrustInformation(d)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("ValidateTrustInformation() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func Test_ProcessBundleRequireLog(t *testing.T) {
+	f := newFixture(t)
+	cv, err := dsse.NewCertificateVerifier(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.root.Raw}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logVerifier, err := tlog.NewVerifier(f.logKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp := dsse.NewDSSEProcessor().WithCertificateVerifier(cv).WithLogVerifier(logVerifier)
+	r := process.NewRegistry()
+	_ = r.Register(dp, processor.DocumentDSSE)
+	_ = r.Register(NewBundleProcessor(dp), processor.DocumentSigstoreBundle)
+	tp, err := process.ParseTrustPolicy([]byte(`sources: [{collector: logged, requireLog: true}]`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{
+		"_type": "https://in-toto.io/Statement/v0.1",
+		"subject": [{"name": "img", "digest": {"sha256": "5678c7f7d3a8e5a5d1ea3a3c4d4e0b0f7d9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c"}}],
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate": {"builder": {"id": "https://example.com/builder"}, "buildType": "https://example.com/build"}
+	}`)
+	res, err := process.ProcessWithOptions(&processor.Document{
+		Blob:              f.bundleOf(t, payload),
+		Type:              processor.DocumentSigstoreBundle,
+		Format:            processor.FormatJSON,
+		SourceInformation: processor.SourceInformation{Collector: "logged"},
+	}, process.Options{Registry: r, Policy: tp, LogVerifier: logVerifier})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []processor.DocumentType{}
+	for _, dr := range res.Results {
+		if dr.Outcome != process.OutcomeAccepted {
+			t.Errorf("%s document not accepted: %v", dr.Document.Type, dr.Err)
+		}
+		if dr.TrustInfo[process.TrustInfoLogVerified] != true {
+			t.Errorf("%s document log entry not verified", dr.Document.Type)
+		}
+		types = append(types, dr.Document.Type)
+	}
+	expected := []processor.DocumentType{processor.DocumentSigstoreBundle, processor.DocumentITE6, processor.DocumentSLSA}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Errorf("got documents %v, expected %v", types, expected)
+	}
+}
+
+func Test_BundleSchema(t *testing.T) {
+	valid := string(newFixture(t).bundle(t))
+	testCases := []struct {
+		name      string
+		blob      string
+		expectErr bool
+	}{{
+		name: "valid bundle",
+		blob: valid,
+	}, {
+		name:      "unknown media type",
+		blob:      strings.Replace(valid, "application/vnd.dev.sigstore.bundle", "application/json", 1),
+		expectErr: true,
+	}, {
+		name:      "no verification material",
+		blob:      `{"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json", "dsseEnvelope": {}}`,
+		expectErr: true,
+	}, {
+		name:      "message signature",
+		blob:      `{"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json", "verificationMaterial": {"publicKey": {"hint": "a"}}, "messageSignature": {}}`,
+		expectErr: true,
+	}, {
+		name:      "invalid log entry",
+		blob:      `{"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json", "verificationMaterial": {"publicKey": {"hint": "a"}, "tlogEntries": [{"logIndex": "1"}]}, "dsseEnvelope": {}}`,
+		expectErr: true,
+	}, {
+		name:      "empty envelope",
+		blob:      `{"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json", "verificationMaterial": {"publicKey": {"hint": "a"}}, "dsseEnvelope": {}}`,
+		expectErr: true,
+	}, {
+		name:      "invalid log index",
+		blob:      strings.Replace(valid, `"logIndex":"7"`, `"logIndex":"seven"`, 1),
+		expectErr: true,
+	}}
+
+	dp := NewBundleProcessor(dsse.NewDSSEProcessor())
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &processor.Document{Blob: []byte(tt.blob), Format: processor.FormatJSON}
+			err := dp.ValidateSchema(d)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("ValidateSchema() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slsa
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+// SLSA provenance predicate versions
+const (
+	VersionV02 = "v0.2"
+	VersionV1  = "v1"
+)
+
+// Keys of the trust information map returned by ValidateTrustInformation
+const (
+	TrustInfoBuilderID = "slsa_builder_id"
+	TrustInfoBuildType = "slsa_build_type"
+	TrustInfoVersion   = "slsa_version"
+)
+
+// Provenance is the version independent model of a SLSA provenance predicate
+type Provenance struct {
+	Version   string
+	BuilderID string
+	BuildType string
+	// Materials are the v0.2 materials or the v1 resolvedDependencies
+	Materials []Material
+	// Parameters are the v0.2 invocation parameters or the v1
+	// externalParameters
+	Parameters map[string]interface{}
+}
+
+// Material is an artifact that was an input to the build
+type Material struct {
+	URI    string
+	Digest map[string]string
+}
+
+type provenanceV02 struct {
+	Builder *struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	BuildType  string `json:"buildType"`
+	Invocation *struct {
+		Parameters map[string]interface{} `json:"parameters"`
+	} `json:"invocation"`
+	Materials []struct {
+		URI    string            `json:"uri"`
+		Digest map[string]string `json:"digest"`
+	} `json:"materials"`
+}
+
+type provenanceV1 struct {
+	BuildDefinition *struct {
+		BuildType            string                 `json:"buildType"`
+		ExternalParameters   map[string]interface{} `json:"externalParameters"`
+		ResolvedDependencies []struct {
+			URI     string            `json:"uri"`
+			Digest  map[string]string `json:"digest"`
+			Content string            `json:"content"`
+		} `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails *struct {
+		Builder *struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+	} `json:"runDetails"`
+}
+
+// SLSAProcessor processes SLSA provenance predicates, as unpacked from
+// in-toto statements. Both v0.2 and v1 predicates are accepted.
+type SLSAProcessor struct{}
+
+func (dp *SLSAProcessor) ValidateSchema(d *processor.Document) error {
+	_, err := ParseProvenance(d)
+	return err
+}
+
+// ValidateTrustInformation returns the builder identity of the provenance
+// so that policy can enforce trusted builders.
+func (dp *SLSAProcessor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	p, err := ParseProvenance(d)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		TrustInfoBuilderID: p.BuilderID,
+		TrustInfoBuildType: p.BuildType,
+		TrustInfoVersion:   p.Version,
+	}, nil
+}
+
+func (dp *SLSAProcessor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	return []*processor.Document{}, nil
+}
+
+func (dp *SLSAProcessor) Describe() processor.Description {
+	return processor.Description{
+		Formats:  []processor.FormatType{processor.FormatJSON},
+		Versions: []string{VersionV02, VersionV1},
+	}
+}
+
+// ParseProvenance parses and validates a SLSA provenance predicate
+func ParseProvenance(d *processor.Document) (*Provenance, error) {
+	if d.Format != processor.FormatJSON {
+		return nil, fmt.Errorf("only accept JSON formats")
+	}
+
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(d.Blob, &probe); err != nil {
+		return nil, err
+	}
+	if _, ok := probe["buildDefinition"]; ok {
+		return parseV1(d.Blob)
+	}
+	return parseV02(d.Blob)
+}
+
+func parseV02(b []byte) (*Provenance, error) {
+	var p provenanceV02
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.Builder == nil || p.Builder.ID == "" {
+		return nil, fmt.Errorf("builder.id shouldn't be empty")
+	}
+	if p.BuildType == "" {
+		return nil, fmt.Errorf("buildType shouldn't be empty")
+	}
+
+	prov := &Provenance{
+		Version:   VersionV02,
+		BuilderID: p.Builder.ID,
+		BuildType: p.BuildType,
+	}
+	if p.Invocation != nil {
+		prov.Parameters = p.Invocation.Parameters
+	}
+	for i, m := range p.Materials {
+		if m.URI == "" {
+			return nil, fmt.Errorf("materials[%d].uri shouldn't be empty", i)
+		}
+		prov.Materials = append(prov.Materials, Material{URI: m.URI, Digest: m.Digest})
+	}
+	return prov, nil
+}
+
+func parseV1(b []byte) (*Provenance, error) {
+	var p provenanceV1
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.BuildDefinition == nil {
+		return nil, fmt.Errorf("buildDefinition shouldn't be empty")
+	}
+	if p.BuildDefinition.BuildType == "" {
+		return nil, fmt.Errorf("buildDefinition.buildType shouldn't be empty")
+	}
+	if p.BuildDefinition.ExternalParameters == nil {
+		return nil, fmt.Errorf("buildDefinition.externalParameters shouldn't be empty")
+	}
+	if p.RunDetails == nil || p.RunDetails.Builder == nil || p.RunDetails.Builder.ID == "" {
+		return nil, fmt.Errorf("runDetails.builder.id shouldn't be empty")
+	}
+
+	prov := &Provenance{
+		Version:    VersionV1,
+		BuilderID:  p.RunDetails.Builder.ID,
+		BuildType:  p.BuildDefinition.BuildType,
+		Parameters: p.BuildDefinition.ExternalParameters,
+	}
+	for i, r := range p.BuildDefinition.ResolvedDependencies {
+		// A resource descriptor must identify the resource in some way
+		if r.URI == "" && len(r.Digest) == 0 && r.Content == "" {
+			return nil, fmt.Errorf("resolvedDependencies[%d] needs one of uri, digest or content", i)
+		}
+		prov.Materials = append(prov.Materials, Material{URI: r.URI, Digest: r.Digest})
+	}
+	return prov, nil
+}
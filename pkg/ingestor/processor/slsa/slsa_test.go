@@ -0,0 +1,137 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slsa
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+func Test_SLSAProcessor(t *testing.T) {
+	testCases := []struct {
+		name              string
+		blob              string
+		expected          *Provenance
+		expectedTrustInfo map[string]interface{}
+		expectErr         bool
+	}{{
+		name: "v0.2",
+		blob: `{
+			"builder": {"id": "https://github.com/Attestations/GitHubHostedActions@v1"},
+			"buildType": "https://github.com/Attestations/GitHubActionsWorkflow@v1",
+			"invocation": {"parameters": {"ref": "main"}},
+			"materials": [{"uri": "git+https://github.com/guacsec/guac", "digest": {"sha1": "abcd"}}]
+		}`,
+		expected: &Provenance{
+			Version:    VersionV02,
+			BuilderID:  "https://github.com/Attestations/GitHubHostedActions@v1",
+			BuildType:  "https://github.com/Attestations/GitHubActionsWorkflow@v1",
+			Parameters: map[string]interface{}{"ref": "main"},
+			Materials: []Material{{
+				URI:    "git+https://github.com/guacsec/guac",
+				Digest: map[string]string{"sha1": "abcd"},
+			}},
+		},
+		expectedTrustInfo: map[string]interface{}{
+			TrustInfoBuilderID: "https://github.com/Attestations/GitHubHostedActions@v1",
+			TrustInfoBuildType: "https://github.com/Attestations/GitHubActionsWorkflow@v1",
+			TrustInfoVersion:   VersionV02,
+		},
+	}, {
+		name: "v1",
+		blob: `{
+			"buildDefinition": {
+				"buildType": "https://slsa-framework.github.io/github-actions-buildtypes/workflow/v1",
+				"externalParameters": {"workflow": "release.yml"},
+				"resolvedDependencies": [{"uri": "git+https://github.com/guacsec/guac", "digest": {"gitCommit": "abcd"}}]
+			},
+			"runDetails": {"builder": {"id": "https://github.com/slsa-framework/slsa-github-generator"}}
+		}`,
+		expected: &Provenance{
+			Version:    VersionV1,
+			BuilderID:  "https://github.com/slsa-framework/slsa-github-generator",
+			BuildType:  "https://slsa-framework.github.io/github-actions-buildtypes/workflow/v1",
+			Parameters: map[string]interface{}{"workflow": "release.yml"},
+			Materials: []Material{{
+				URI:    "git+https://github.com/guacsec/guac",
+				Digest: map[string]string{"gitCommit": "abcd"},
+			}},
+		},
+		expectedTrustInfo: map[string]interface{}{
+			TrustInfoBuilderID: "https://github.com/slsa-framework/slsa-github-generator",
+			TrustInfoBuildType: "https://slsa-framework.github.io/github-actions-buildtypes/workflow/v1",
+			TrustInfoVersion:   VersionV1,
+		},
+	}, {
+		name:      "v0.2 missing builder",
+		blob:      `{"buildType": "https://example.com/build"}`,
+		expectErr: true,
+	}, {
+		name:      "v0.2 material without uri",
+		blob:      `{"builder": {"id": "b"}, "buildType": "t", "materials": [{"digest": {"sha1": "abcd"}}]}`,
+		expectErr: true,
+	}, {
+		name:      "v1 missing builder",
+		blob:      `{"buildDefinition": {"buildType": "t", "externalParameters": {}}, "runDetails": {}}`,
+		expectErr: true,
+	}, {
+		name:      "v1 missing external parameters",
+		blob:      `{"buildDefinition": {"buildType": "t"}, "runDetails": {"builder": {"id": "b"}}}`,
+		expectErr: true,
+	}, {
+		name: "v1 empty resolved dependency",
+		blob: `{
+			"buildDefinition": {"buildType": "t", "externalParameters": {}, "resolvedDependencies": [{}]},
+			"runDetails": {"builder": {"id": "b"}}
+		}`,
+		expectErr: true,
+	}}
+
+	dp := &SLSAProcessor{}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &processor.Document{
+				Blob:   []byte(tt.blob),
+				Type:   processor.DocumentSLSA,
+				Format: processor.FormatJSON,
+			}
+			err := dp.ValidateSchema(d)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+
+			p, err := ParseProvenance(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, tt.expected) {
+				t.Errorf("got provenance %+v, expected %+v", p, tt.expected)
+			}
+			trustInfo, err := dp.ValidateTrustInformation(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(trustInfo, tt.expectedTrustInfo) {
+				t.Errorf("got trust info %v, expected %v", trustInfo, tt.expectedTrustInfo)
+			}
+		})
+	}
+}
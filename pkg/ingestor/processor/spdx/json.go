@@ -0,0 +1,115 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"encoding/json"
+)
+
+type jsonDocument struct {
+	SPDXVersion       string   `json:"spdxVersion"`
+	DataLicense       string   `json:"dataLicense"`
+	SPDXID            string   `json:"SPDXID"`
+	Name              string   `json:"name"`
+	DocumentNamespace string   `json:"documentNamespace"`
+	DocumentDescribes []string `json:"documentDescribes"`
+	Packages          []struct {
+		SPDXID       string         `json:"SPDXID"`
+		Name         string         `json:"name"`
+		VersionInfo  string         `json:"versionInfo"`
+		Checksums    []jsonChecksum `json:"checksums"`
+		ExternalRefs []struct {
+			ReferenceCategory string `json:"referenceCategory"`
+			ReferenceType     string `json:"referenceType"`
+			ReferenceLocator  string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+	Files []struct {
+		SPDXID    string         `json:"SPDXID"`
+		FileName  string         `json:"fileName"`
+		Checksums []jsonChecksum `json:"checksums"`
+	} `json:"files"`
+	Relationships []struct {
+		SPDXElementID      string `json:"spdxElementId"`
+		RelationshipType   string `json:"relationshipType"`
+		RelatedSPDXElement string `json:"relatedSpdxElement"`
+	} `json:"relationships"`
+}
+
+type jsonChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+func parseJSON(b []byte) (*Document, error) {
+	var jd jsonDocument
+	if err := json.Unmarshal(b, &jd); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		SPDXVersion:       jd.SPDXVersion,
+		DataLicense:       jd.DataLicense,
+		SPDXID:            jd.SPDXID,
+		Name:              jd.Name,
+		DocumentNamespace: jd.DocumentNamespace,
+	}
+	for _, p := range jd.Packages {
+		pkg := Package{
+			SPDXID:      p.SPDXID,
+			Name:        p.Name,
+			VersionInfo: p.VersionInfo,
+			Checksums:   convertChecksums(p.Checksums),
+		}
+		for _, ref := range p.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				pkg.PURLs = append(pkg.PURLs, ref.ReferenceLocator)
+			}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+	}
+	for _, f := range jd.Files {
+		doc.Files = append(doc.Files, File{
+			SPDXID:    f.SPDXID,
+			Name:      f.FileName,
+			Checksums: convertChecksums(f.Checksums),
+		})
+	}
+	// documentDescribes is the SPDX 2.2 shorthand for DESCRIBES relationships
+	for _, id := range jd.DocumentDescribes {
+		doc.Relationships = append(doc.Relationships, Relationship{
+			Element: jd.SPDXID,
+			Type:    "DESCRIBES",
+			Related: id,
+		})
+	}
+	for _, r := range jd.Relationships {
+		doc.Relationships = append(doc.Relationships, Relationship{
+			Element: r.SPDXElementID,
+			Type:    r.RelationshipType,
+			Related: r.RelatedSPDXElement,
+		})
+	}
+	return doc, nil
+}
+
+func convertChecksums(jcs []jsonChecksum) []Checksum {
+	var cs []Checksum
+	for _, c := range jcs {
+		cs = append(cs, Checksum{Algorithm: c.Algorithm, Value: c.ChecksumValue})
+	}
+	return cs
+}
@@ -0,0 +1,214 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+const (
+	documentSPDXID = "SPDXRef-DOCUMENT"
+	dataLicense    = "CC0-1.0"
+)
+
+// supportedVersions are the SPDX specification versions accepted
+var supportedVersions = map[string]bool{
+	"SPDX-2.2": true,
+	"SPDX-2.3": true,
+}
+
+// Document is the format independent model of an SPDX document
+type Document struct {
+	SPDXVersion       string
+	DataLicense       string
+	SPDXID            string
+	Name              string
+	DocumentNamespace string
+	Packages          []Package
+	Files             []File
+	Relationships     []Relationship
+}
+
+// Package is an SPDX package
+type Package struct {
+	SPDXID      string
+	Name        string
+	VersionInfo string
+	Checksums   []Checksum
+	PURLs       []string
+}
+
+// File is an SPDX file
+type File struct {
+	SPDXID    string
+	Name      string
+	Checksums []Checksum
+}
+
+// Checksum is an SPDX checksum, Algorithm is upper case (e.g. SHA256)
+type Checksum struct {
+	Algorithm string
+	Value     string
+}
+
+// Relationship is an SPDX relationship between two elements
+type Relationship struct {
+	Element string
+	Type    string
+	Related string
+}
+
+// SPDXProcessor processes SPDX 2.2 and 2.3 documents in JSON or tag-value
+// format.
+type SPDXProcessor struct{}
+
+func (dp *SPDXProcessor) ValidateSchema(d *processor.Document) error {
+	_, err := ParseSPDX(d)
+	return err
+}
+
+func (dp *SPDXProcessor) ValidateTrustInformation(d *processor.Document) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (dp *SPDXProcessor) Unpack(d *processor.Document) ([]*processor.Document, error) {
+	return []*processor.Document{}, nil
+}
+
+func (dp *SPDXProcessor) Describe() processor.Description {
+	versions := make([]string, 0, len(supportedVersions))
+	for v := range supportedVersions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return processor.Description{
+		Formats:  []processor.FormatType{processor.FormatJSON, processor.FormatTagValue},
+		Versions: versions,
+	}
+}
+
+// ParseSPDX parses and validates an SPDX document
+func ParseSPDX(d *processor.Document) (*Document, error) {
+	var (
+		doc *Document
+		err error
+	)
+	switch d.Format {
+	case processor.FormatJSON:
+		doc, err = parseJSON(d.Blob)
+	case processor.FormatTagValue:
+		doc, err = parseTagValue(d.Blob)
+	default:
+		return nil, fmt.Errorf("only accept JSON and tag-value formats")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDocument(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func validateDocument(doc *Document) error {
+	if !supportedVersions[doc.SPDXVersion] {
+		return fmt.Errorf("unsupported SPDX version: %q", doc.SPDXVersion)
+	}
+	if doc.DataLicense != dataLicense {
+		return fmt.Errorf("dataLicense should be %s", dataLicense)
+	}
+	if doc.SPDXID != documentSPDXID {
+		return fmt.Errorf("document SPDXID should be %s", documentSPDXID)
+	}
+	if doc.Name == "" {
+		return fmt.Errorf("document name shouldn't be empty")
+	}
+	if doc.DocumentNamespace == "" {
+		return fmt.Errorf("documentNamespace shouldn't be empty")
+	}
+
+	ids := map[string]bool{documentSPDXID: true}
+	addID := func(id string) error {
+		if !strings.HasPrefix(id, "SPDXRef-") {
+			return fmt.Errorf("invalid SPDXID: %q", id)
+		}
+		if ids[id] {
+			return fmt.Errorf("duplicate SPDXID: %q", id)
+		}
+		ids[id] = true
+		return nil
+	}
+	for _, p := range doc.Packages {
+		if p.Name == "" {
+			return fmt.Errorf("package %s name shouldn't be empty", p.SPDXID)
+		}
+		if err := addID(p.SPDXID); err != nil {
+			return err
+		}
+		if err := validateChecksums(p.Checksums); err != nil {
+			return fmt.Errorf("package %s: %w", p.SPDXID, err)
+		}
+		for _, purl := range p.PURLs {
+			if !strings.HasPrefix(purl, "pkg:") {
+				return fmt.Errorf("package %s has invalid purl: %q", p.SPDXID, purl)
+			}
+		}
+	}
+	for _, f := range doc.Files {
+		if err := addID(f.SPDXID); err != nil {
+			return err
+		}
+		if err := validateChecksums(f.Checksums); err != nil {
+			return fmt.Errorf("file %s: %w", f.SPDXID, err)
+		}
+	}
+
+	isElement := func(id string) bool {
+		// External document references and the special values cannot be
+		// checked against the document elements
+		return ids[id] || strings.HasPrefix(id, "DocumentRef-") ||
+			id == "NONE" || id == "NOASSERTION"
+	}
+	for _, r := range doc.Relationships {
+		if r.Type == "" {
+			return fmt.Errorf("relationship type shouldn't be empty")
+		}
+		if !isElement(r.Element) {
+			return fmt.Errorf("relationship references unknown element: %q", r.Element)
+		}
+		if !isElement(r.Related) {
+			return fmt.Errorf("relationship references unknown element: %q", r.Related)
+		}
+	}
+	return nil
+}
+
+func validateChecksums(checksums []Checksum) error {
+	for _, c := range checksums {
+		if c.Algorithm == "" {
+			return fmt.Errorf("checksum algorithm shouldn't be empty")
+		}
+		if _, err := hex.DecodeString(c.Value); err != nil || c.Value == "" {
+			return fmt.Errorf("checksum %s is not hex encoded", c.Algorithm)
+		}
+	}
+	return nil
+}
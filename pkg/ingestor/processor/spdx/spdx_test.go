@@ -0,0 +1,182 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guacsec/guac/pkg/ingestor/processor"
+)
+
+const (
+	sha1Value = "85ed0817af83a24ad8da68c2b5094de69833983c"
+
+	jsonSPDX = `{
+		"spdxVersion": "SPDX-2.3",
+		"dataLicense": "CC0-1.0",
+		"SPDXID": "SPDXRef-DOCUMENT",
+		"name": "guac",
+		"documentNamespace": "https://example.com/guac",
+		"documentDescribes": ["SPDXRef-Package-guac"],
+		"packages": [{
+			"SPDXID": "SPDXRef-Package-guac",
+			"name": "guac",
+			"versionInfo": "v0.1.0",
+			"checksums": [{"algorithm": "SHA1", "checksumValue": "` + sha1Value + `"}],
+			"externalRefs": [{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType": "purl",
+				"referenceLocator": "pkg:golang/github.com/guacsec/guac@v0.1.0"
+			}]
+		}, {
+			"SPDXID": "SPDXRef-Package-logrus",
+			"name": "logrus",
+			"versionInfo": "v1.4.2"
+		}],
+		"relationships": [{
+			"spdxElementId": "SPDXRef-Package-guac",
+			"relationshipType": "DEPENDS_ON",
+			"relatedSpdxElement": "SPDXRef-Package-logrus"
+		}]
+	}`
+
+	tagValueSPDX = `SPDXVersion: SPDX-2.3
+DataLicense: CC0-1.0
+SPDXID: SPDXRef-DOCUMENT
+DocumentName: guac
+DocumentNamespace: https://example.com/guac
+DocumentComment: <text>A document
+over multiple lines</text>
+
+# Packages
+PackageName: guac
+SPDXID: SPDXRef-Package-guac
+PackageVersion: v0.1.0
+PackageChecksum: SHA1: ` + sha1Value + `
+ExternalRef: PACKAGE-MANAGER purl pkg:golang/github.com/guacsec/guac@v0.1.0
+
+PackageName: logrus
+SPDXID: SPDXRef-Package-logrus
+PackageVersion: v1.4.2
+
+Relationship: SPDXRef-DOCUMENT DESCRIBES SPDXRef-Package-guac
+Relationship: SPDXRef-Package-guac DEPENDS_ON SPDXRef-Package-logrus
+`
+)
+
+var expectedDocument = &Document{
+	SPDXVersion:       "SPDX-2.3",
+	DataLicense:       "CC0-1.0",
+	SPDXID:            "SPDXRef-DOCUMENT",
+	Name:              "guac",
+	DocumentNamespace: "https://example.com/guac",
+	Packages: []Package{{
+		SPDXID:      "SPDXRef-Package-guac",
+		Name:        "guac",
+		VersionInfo: "v0.1.0",
+		Checksums:   []Checksum{{Algorithm: "SHA1", Value: sha1Value}},
+		PURLs:       []string{"pkg:golang/github.com/guacsec/guac@v0.1.0"},
+	}, {
+		SPDXID:      "SPDXRef-Package-logrus",
+		Name:        "logrus",
+		VersionInfo: "v1.4.2",
+	}},
+	Relationships: []Relationship{{
+		Element: "SPDXRef-DOCUMENT",
+		Type:    "DESCRIBES",
+		Related: "SPDXRef-Package-guac",
+	}, {
+		Element: "SPDXRef-Package-guac",
+		Type:    "DEPENDS_ON",
+		Related: "SPDXRef-Package-logrus",
+	}},
+}
+
+func Test_SPDXProcessor(t *testing.T) {
+	testCases := []struct {
+		name      string
+		doc       processor.Document
+		expected  *Document
+		expectErr bool
+	}{{
+		name:     "json",
+		doc:      processor.Document{Blob: []byte(jsonSPDX), Format: processor.FormatJSON},
+		expected: expectedDocument,
+	}, {
+		name:     "tag-value",
+		doc:      processor.Document{Blob: []byte(tagValueSPDX), Format: processor.FormatTagValue},
+		expected: expectedDocument,
+	}, {
+		name: "unsupported version",
+		doc: processor.Document{Blob: []byte(`{
+			"spdxVersion": "SPDX-2.1", "dataLicense": "CC0-1.0", "SPDXID": "SPDXRef-DOCUMENT",
+			"name": "a", "documentNamespace": "https://example.com/a"
+		}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name: "missing namespace",
+		doc: processor.Document{Blob: []byte(`{
+			"spdxVersion": "SPDX-2.3", "dataLicense": "CC0-1.0", "SPDXID": "SPDXRef-DOCUMENT",
+			"name": "a"
+		}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name: "bad checksum",
+		doc: processor.Document{Blob: []byte(`{
+			"spdxVersion": "SPDX-2.3", "dataLicense": "CC0-1.0", "SPDXID": "SPDXRef-DOCUMENT",
+			"name": "a", "documentNamespace": "https://example.com/a",
+			"packages": [{"SPDXID": "SPDXRef-a", "name": "a", "checksums": [{"algorithm": "SHA1", "checksumValue": "xyz"}]}]
+		}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name: "dangling relationship",
+		doc: processor.Document{Blob: []byte(`{
+			"spdxVersion": "SPDX-2.3", "dataLicense": "CC0-1.0", "SPDXID": "SPDXRef-DOCUMENT",
+			"name": "a", "documentNamespace": "https://example.com/a",
+			"relationships": [{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": "SPDXRef-missing"}]
+		}`), Format: processor.FormatJSON},
+		expectErr: true,
+	}, {
+		name:      "malformed tag-value",
+		doc:       processor.Document{Blob: []byte("SPDXVersion SPDX-2.3\n"), Format: processor.FormatTagValue},
+		expectErr: true,
+	}, {
+		name:      "unterminated text",
+		doc:       processor.Document{Blob: []byte("SPDXVersion: SPDX-2.3\nDocumentComment: <text>abc\n"), Format: processor.FormatTagValue},
+		expectErr: true,
+	}}
+
+	dp := &SPDXProcessor{}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dp.ValidateSchema(&tt.doc)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			doc, err := ParseSPDX(&tt.doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc, tt.expected) {
+				t.Errorf("got document %+v, expected %+v", doc, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// TagValue is a single "Tag: value" pair of a tag-value document
+type TagValue struct {
+	Tag   string
+	Value string
+}
+
+// ParseTagValues splits a tag-value document into its tag-value pairs.
+// Comment lines and empty lines are skipped, and multi-line values
+// wrapped in <text></text> are joined. An error is returned if the
+// document is not well formed.
+func ParseTagValues(b []byte) ([]TagValue, error) {
+	var (
+		tvs     []TagValue
+		inText  bool
+		textTag string
+		text    strings.Builder
+		lineNo  int
+	)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(make([]byte, 64*1024), len(b)+1)
+	for s.Scan() {
+		lineNo++
+		line := s.Text()
+		if inText {
+			if i := strings.Index(line, "</text>"); i >= 0 {
+				text.WriteString(line[:i])
+				tvs = append(tvs, TagValue{Tag: textTag, Value: text.String()})
+				inText = false
+				continue
+			}
+			text.WriteString(line)
+			text.WriteString("\n")
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		i := strings.Index(trimmed, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected tag-value pair", lineNo)
+		}
+		tag := trimmed[:i]
+		if strings.ContainsAny(tag, " \t") {
+			return nil, fmt.Errorf("line %d: invalid tag %q", lineNo, tag)
+		}
+		value := strings.TrimSpace(trimmed[i+1:])
+		if strings.HasPrefix(value, "<text>") {
+			value = strings.TrimPrefix(value, "<text>")
+			if j := strings.Index(value, "</text>"); j >= 0 {
+				tvs = append(tvs, TagValue{Tag: tag, Value: value[:j]})
+				continue
+			}
+			inText, textTag = true, tag
+			text.Reset()
+			text.WriteString(value)
+			text.WriteString("\n")
+			continue
+		}
+		tvs = append(tvs, TagValue{Tag: tag, Value: value})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if inText {
+		return nil, fmt.Errorf("unterminated <text> value for tag %s", textTag)
+	}
+	if len(tvs) == 0 {
+		return nil, fmt.Errorf("no tag-value pairs found")
+	}
+	return tvs, nil
+}
+
+func parseTagValue(b []byte) (*Document, error) {
+	tvs, err := ParseTagValues(b)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	var (
+		pkg  *Package
+		file *File
+	)
+	flush := func() {
+		if pkg != nil {
+			doc.Packages = append(doc.Packages, *pkg)
+			pkg = nil
+		}
+		if file != nil {
+			doc.Files = append(doc.Files, *file)
+			file = nil
+		}
+	}
+
+	for _, tv := range tvs {
+		switch tv.Tag {
+		case "SPDXVersion":
+			doc.SPDXVersion = tv.Value
+		case "DataLicense":
+			doc.DataLicense = tv.Value
+		case "DocumentName":
+			doc.Name = tv.Value
+		case "DocumentNamespace":
+			doc.DocumentNamespace = tv.Value
+		case "SPDXID":
+			switch {
+			case file != nil:
+				file.SPDXID = tv.Value
+			case pkg != nil:
+				pkg.SPDXID = tv.Value
+			default:
+				doc.SPDXID = tv.Value
+			}
+		case "PackageName":
+			flush()
+			pkg = &Package{Name: tv.Value}
+		case "FileName":
+			flush()
+			file = &File{Name: tv.Value}
+		case "PackageVersion":
+			if pkg == nil {
+				return nil, fmt.Errorf("%s outside of a package", tv.Tag)
+			}
+			pkg.VersionInfo = tv.Value
+		case "PackageChecksum", "FileChecksum":
+			c, err := parseChecksum(tv.Value)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case tv.Tag == "FileChecksum" && file != nil:
+				file.Checksums = append(file.Checksums, c)
+			case tv.Tag == "PackageChecksum" && pkg != nil:
+				pkg.Checksums = append(pkg.Checksums, c)
+			default:
+				return nil, fmt.Errorf("%s outside of its section", tv.Tag)
+			}
+		case "ExternalRef":
+			if pkg == nil {
+				return nil, fmt.Errorf("%s outside of a package", tv.Tag)
+			}
+			fields := strings.Fields(tv.Value)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("invalid ExternalRef: %q", tv.Value)
+			}
+			if fields[1] == "purl" {
+				pkg.PURLs = append(pkg.PURLs, fields[2])
+			}
+		case "Relationship":
+			fields := strings.Fields(tv.Value)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("invalid Relationship: %q", tv.Value)
+			}
+			doc.Relationships = append(doc.Relationships, Relationship{
+				Element: fields[0],
+				Type:    fields[1],
+				Related: fields[2],
+			})
+		}
+	}
+	flush()
+	return doc, nil
+}
+
+// parseChecksum parses a tag-value checksum of the form "SHA256: abcd"
+func parseChecksum(v string) (Checksum, error) {
+	i := strings.Index(v, ":")
+	if i <= 0 {
+		return Checksum{}, fmt.Errorf("invalid checksum: %q", v)
+	}
+	return Checksum{
+		Algorithm: strings.TrimSpace(v[:i]),
+		Value:     strings.TrimSpace(v[i+1:]),
+	}, nil
+}
@@ -0,0 +1,78 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// verifyCheckpoint verifies that the checkpoint of the inclusion proof is
+// signed by the log and commits to the proof tree size and root hash.
+//
+// Checkpoints are signed notes, the note text being
+//
+//	<origin>
+//	<tree size>
+//	<base64 root hash>
+//	[other content]
+//
+// followed by an empty line and signature lines of the form
+// "— <name> <base64 of 4 byte key hint and signature>".
+func (v *Verifier) verifyCheckpoint(p *InclusionProof) error {
+	i := strings.Index(p.Checkpoint, "\n\n")
+	if i < 0 {
+		return fmt.Errorf("checkpoint has no signatures")
+	}
+	text, sigs := p.Checkpoint[:i+1], p.Checkpoint[i+2:]
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) < 3 {
+		return fmt.Errorf("checkpoint is malformed")
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("checkpoint tree size is malformed: %w", err)
+	}
+	root, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return fmt.Errorf("checkpoint root hash is malformed: %w", err)
+	}
+	if size != p.TreeSize || hex.EncodeToString(root) != strings.ToLower(p.RootHash) {
+		return fmt.Errorf("checkpoint does not match inclusion proof")
+	}
+
+	for _, line := range strings.Split(sigs, "\n") {
+		if !strings.HasPrefix(line, "— ") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "— "))
+		if len(fields) != 2 {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(b) <= 4 {
+			continue
+		}
+		if verifySignature(v.pub, []byte(text), b[4:]) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("checkpoint is not signed by the log")
+}
@@ -0,0 +1,97 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// RFC 6962 hash prefixes
+const (
+	leafHashPrefix = 0x00
+	nodeHashPrefix = 0x01
+)
+
+func leafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafHashPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodeHashPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// verifyInclusion verifies the RFC 6962 inclusion proof of the leaf
+func verifyInclusion(p *InclusionProof, leaf []byte) error {
+	if p.LogIndex < 0 || p.LogIndex >= p.TreeSize {
+		return fmt.Errorf("inclusion proof index %d out of tree of size %d", p.LogIndex, p.TreeSize)
+	}
+	root, err := hex.DecodeString(p.RootHash)
+	if err != nil {
+		return fmt.Errorf("unable to decode root hash: %w", err)
+	}
+	hashes := make([][]byte, len(p.Hashes))
+	for i, h := range p.Hashes {
+		if hashes[i], err = hex.DecodeString(h); err != nil {
+			return fmt.Errorf("unable to decode inclusion proof hash: %w", err)
+		}
+	}
+
+	computed, err := rootFromInclusionProof(p.LogIndex, p.TreeSize, leafHash(leaf), hashes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computed, root) {
+		return fmt.Errorf("inclusion proof does not match root hash")
+	}
+	return nil
+}
+
+// rootFromInclusionProof computes the tree root from the leaf hash and the
+// audit path, following RFC 9162 section 2.1.3.2
+func rootFromInclusionProof(index, size int64, leaf []byte, proof [][]byte) ([]byte, error) {
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return nil, fmt.Errorf("inclusion proof is too long")
+		}
+		if fn%2 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn%2 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return nil, fmt.Errorf("inclusion proof is too short")
+	}
+	return r, nil
+}
@@ -0,0 +1,338 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tlog verifies Rekor-style transparency log entries offline,
+// against the public key of the log.
+package tlog
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+)
+
+// LogEntry is a transparency log entry, as returned by Rekor
+type LogEntry struct {
+	// Body is the base64 encoded canonicalized entry
+	Body           string        `json:"body"`
+	IntegratedTime int64         `json:"integratedTime"`
+	LogID          string        `json:"logID"`
+	LogIndex       int64         `json:"logIndex"`
+	Verification   *Verification `json:"verification,omitempty"`
+}
+
+// Verification is the material to verify a LogEntry offline
+type Verification struct {
+	// SignedEntryTimestamp is the base64 encoded signature of the log
+	// over the canonicalized body, integratedTime, logID and logIndex
+	SignedEntryTimestamp string          `json:"signedEntryTimestamp"`
+	InclusionProof       *InclusionProof `json:"inclusionProof,omitempty"`
+}
+
+// InclusionProof is a RFC 6962 inclusion proof of an entry in the log,
+// hashes are hex encoded
+type InclusionProof struct {
+	LogIndex int64    `json:"logIndex"`
+	RootHash string   `json:"rootHash"`
+	TreeSize int64    `json:"treeSize"`
+	Hashes   []string `json:"hashes"`
+	// Checkpoint is the signed note committing to RootHash and TreeSize
+	Checkpoint string `json:"checkpoint"`
+}
+
+// Verifier verifies log entries against the public key of a log
+type Verifier struct {
+	pub   crypto.PublicKey
+	logID string
+}
+
+// NewVerifier creates a verifier for the log with the given public key.
+// Supported key types are ECDSA, Ed25519 and RSA.
+func NewVerifier(pub crypto.PublicKey) (*Verifier, error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pub)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(der)
+	return &Verifier{pub: pub, logID: hex.EncodeToString(id[:])}, nil
+}
+
+// NewVerifierFromPEM creates a verifier from a PEM encoded public key
+func NewVerifierFromPEM(b []byte) (*Verifier, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("unable to decode PEM public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return NewVerifier(pub)
+}
+
+// Verify verifies that the entry was logged and that it is about the
+// blob. It checks the signed entry timestamp, and if present the
+// inclusion proof and its checkpoint. The SHA-256 digest of the blob must
+// be one of the artifact digests of the entry body, see bodyDigests.
+func (v *Verifier) Verify(e *LogEntry, blob []byte) error {
+	if e == nil {
+		return fmt.Errorf("no log entry")
+	}
+	if e.LogID != v.logID {
+		return fmt.Errorf("log entry is from log %s, expected %s", e.LogID, v.logID)
+	}
+	if e.Verification == nil || e.Verification.SignedEntryTimestamp == "" {
+		return fmt.Errorf("log entry has no signed entry timestamp")
+	}
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return fmt.Errorf("unable to decode log entry body: %w", err)
+	}
+
+	digests, err := bodyDigests(body)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(blob)
+	if !digests[hex.EncodeToString(digest[:])] {
+		return fmt.Errorf("log entry does not reference the document")
+	}
+
+	if err := v.verifySET(e); err != nil {
+		return err
+	}
+
+	if p := e.Verification.InclusionProof; p != nil {
+		if p.LogIndex != e.LogIndex {
+			return fmt.Errorf("inclusion proof is for index %d, expected %d", p.LogIndex, e.LogIndex)
+		}
+		if err := verifyInclusion(p, body); err != nil {
+			return err
+		}
+		if p.Checkpoint != "" {
+			if err := v.verifyCheckpoint(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// VerifySigner checks that the body of the entry records the signature or
+// the leaf certificate of a signer, so that an entry about the same
+// artifact but another signer is not taken as proof of when this one
+// signed. The entry itself must be verified with Verify.
+func VerifySigner(e *LogEntry, sig []byte, cert *x509.Certificate) error {
+	if e == nil {
+		return fmt.Errorf("no log entry")
+	}
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return fmt.Errorf("unable to decode log entry body: %w", err)
+	}
+	var b entryBody
+	if err := json.Unmarshal(body, &b); err != nil {
+		return fmt.Errorf("unable to decode log entry body: %w", err)
+	}
+	var sigs, keys []string
+	switch b.Kind {
+	case "hashedrekord", "rekord":
+		sigs = []string{b.Spec.Signature.Content}
+		keys = []string{b.Spec.Signature.PublicKey.Content}
+	case "intoto":
+		for _, s := range b.Spec.Content.Envelope.Signatures {
+			sigs, keys = append(sigs, s.Sig), append(keys, s.PublicKey)
+		}
+	case "dsse":
+		for _, s := range b.Spec.Signatures {
+			sigs, keys = append(sigs, s.Signature), append(keys, s.Verifier)
+		}
+	default:
+		return fmt.Errorf("unsupported log entry kind: %q", b.Kind)
+	}
+	for _, s := range sigs {
+		if len(sig) > 0 && matchesSignature(s, sig) {
+			return nil
+		}
+	}
+	for _, k := range keys {
+		if cert != nil && matchesCertificate(k, cert) {
+			return nil
+		}
+	}
+	return fmt.Errorf("log entry does not record the signature or certificate of the signer")
+}
+
+// matchesSignature returns whether the base64 encoded signature of an
+// entry body is sig. intoto entries encode the base64 signature of the
+// envelope again.
+func matchesSignature(s string, sig []byte) bool {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return false
+	}
+	if string(b) == string(sig) {
+		return true
+	}
+	inner, err := base64.StdEncoding.DecodeString(string(b))
+	return err == nil && string(inner) == string(sig)
+}
+
+// matchesCertificate returns whether the base64 encoded PEM verification
+// material of an entry body holds cert
+func matchesCertificate(k string, cert *x509.Certificate) bool {
+	rest, err := base64.StdEncoding.DecodeString(k)
+	if err != nil {
+		return false
+	}
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return false
+		}
+		if block.Type == "CERTIFICATE" && string(block.Bytes) == string(cert.Raw) {
+			return true
+		}
+	}
+}
+
+// hash is a digest of a Rekor entry body
+type hash struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// entryBody is the subset of a Rekor entry body holding the artifact
+// digests and the signers, for the supported kinds
+type entryBody struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		// hashedrekord and rekord
+		Data struct {
+			Hash *hash `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+		// intoto
+		Content struct {
+			Hash        *hash `json:"hash"`
+			PayloadHash *hash `json:"payloadHash"`
+			Envelope    struct {
+				Signatures []struct {
+					Sig       string `json:"sig"`
+					PublicKey string `json:"publicKey"`
+				} `json:"signatures"`
+			} `json:"envelope"`
+		} `json:"content"`
+		// dsse
+		EnvelopeHash *hash `json:"envelopeHash"`
+		PayloadHash  *hash `json:"payloadHash"`
+		Signatures   []struct {
+			Signature string `json:"signature"`
+			Verifier  string `json:"verifier"`
+		} `json:"signatures"`
+	} `json:"spec"`
+}
+
+// bodyDigests returns the hex encoded SHA-256 digests an entry body
+// binds to: the artifact of hashedrekord and rekord entries, and the
+// envelope and payload of intoto and dsse entries.
+func bodyDigests(body []byte) (map[string]bool, error) {
+	var b entryBody
+	if err := json.Unmarshal(body, &b); err != nil {
+		return nil, fmt.Errorf("unable to decode log entry body: %w", err)
+	}
+	var hashes []*hash
+	switch b.Kind {
+	case "hashedrekord", "rekord":
+		hashes = []*hash{b.Spec.Data.Hash}
+	case "intoto":
+		hashes = []*hash{b.Spec.Content.Hash, b.Spec.Content.PayloadHash}
+	case "dsse":
+		hashes = []*hash{b.Spec.EnvelopeHash, b.Spec.PayloadHash}
+	default:
+		return nil, fmt.Errorf("unsupported log entry kind: %q", b.Kind)
+	}
+	digests := map[string]bool{}
+	for _, h := range hashes {
+		if h != nil && strings.EqualFold(h.Algorithm, "sha256") {
+			digests[strings.ToLower(h.Value)] = true
+		}
+	}
+	return digests, nil
+}
+
+// verifySET verifies the signed entry timestamp of the entry
+func (v *Verifier) verifySET(e *LogEntry) error {
+	payload, err := cjson.EncodeCanonical(map[string]interface{}{
+		"body":           e.Body,
+		"integratedTime": e.IntegratedTime,
+		"logID":          e.LogID,
+		"logIndex":       e.LogIndex,
+	})
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(e.Verification.SignedEntryTimestamp)
+	if err != nil {
+		return fmt.Errorf("unable to decode signed entry timestamp: %w", err)
+	}
+	if err := verifySignature(v.pub, payload, sig); err != nil {
+		return fmt.Errorf("invalid signed entry timestamp: %w", err)
+	}
+	return nil
+}
+
+func verifySignature(pub crypto.PublicKey, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return fmt.Errorf("ecdsa signature verification failed")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("ed25519 signature verification failed")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("rsa signature verification failed: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", pub)
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+//
+// Copyright 2022 The AFF Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tlog
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+)
+
+// merkleRoot computes the RFC 6962 tree head of the leaves
+func merkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leafHash(leaves[0])
+	}
+	k := split(len(leaves))
+	return nodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merklePath computes the RFC 6962 audit path of leaf m
+func merklePath(m int, leaves [][]byte) [][]byte {
+	if len(leaves) == 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if m < k {
+		return append(merklePath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// split returns the largest power of two smaller than n
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+type testLog struct {
+	signer crypto.Signer
+	v      *Verifier
+	leaves [][]byte
+}
+
+func newTestLog(t *testing.T, signer crypto.Signer) *testLog {
+	v, err := NewVerifier(signer.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// other entries of the log
+	var leaves [][]byte
+	for i := 0; i < 6; i++ {
+		leaves = append(leaves, []byte(fmt.Sprintf(`{"entry":%d}`, i)))
+	}
+	return &testLog{signer: signer, v: v, leaves: leaves}
+}
+
+func (l *testLog) sign(t *testing.T, data []byte) []byte {
+	var (
+		sig []byte
+		err error
+	)
+	if _, ok := l.signer.(ed25519.PrivateKey); ok {
+		sig, err = l.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(data)
+		sig, err = l.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return sig
+}
+
+// hashedRekordBody is the body of a hashedrekord entry, formatted with the
+// hex encoded artifact digest
+const hashedRekordBody = `{"kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"%s"}}}}`
+
+// add logs an entry about the blob and returns it. The body is formatted
+// with the hex encoded digest of the blob.
+func (l *testLog) add(t *testing.T, format string, blob []byte) *LogEntry {
+	digest := sha256.Sum256(blob)
+	body := []byte(fmt.Sprintf(format, hex.EncodeToString(digest[:])))
+	index := len(l.leaves)
+	l.leaves = append(l.leaves, body)
+	l.leaves = append(l.leaves, []byte(`{"entry":"after"}`))
+
+	e := &LogEntry{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: 1660000000,
+		LogID:          l.v.logID,
+		LogIndex:       int64(index),
+	}
+	payload, err := cjson.EncodeCanonical(map[string]interface{}{
+		"body":           e.Body,
+		"integratedTime": e.IntegratedTime,
+		"logID":          e.LogID,
+		"logIndex":       e.LogIndex,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	root := merkleRoot(l.leaves)
+	var hashes []string
+	for _, h := range merklePath(index, l.leaves) {
+		hashes = append(hashes, hex.EncodeToString(h))
+	}
+	text := checkpointText("test.log", int64(len(l.leaves)), root)
+	sig := append([]byte{0, 1, 2, 3}, l.sign(t, text)...)
+	checkpoint := fmt.Sprintf("%s\n— test.log %s\n", text, base64.StdEncoding.EncodeToString(sig))
+
+	e.Verification = &Verification{
+		SignedEntryTimestamp: base64.StdEncoding.EncodeToString(l.sign(t, payload)),
+		InclusionProof: &InclusionProof{
+			LogIndex:   int64(index),
+			RootHash:   hex.EncodeToString(root),
+			TreeSize:   int64(len(l.leaves)),
+			Hashes:     hashes,
+			Checkpoint: checkpoint,
+		},
+	}
+	return e
+}
+
+func Test_Verify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blob := []byte(`{"some":"document"}`)
+
+	testCases := []struct {
+		name      string
+		signer    crypto.Signer
+		blob      []byte
+		body      string
+		modify    func(e *LogEntry)
+		expectErr bool
+	}{{
+		name:   "valid ecdsa entry",
+		signer: ecKey,
+		blob:   blob,
+	}, {
+		name:   "valid intoto entry about the envelope",
+		signer: ecKey,
+		blob:   blob,
+		body:   `{"kind":"intoto","apiVersion":"0.0.2","spec":{"content":{"hash":{"algorithm":"sha256","value":"%s"},"payloadHash":{"algorithm":"sha256","value":"00"}}}}`,
+	}, {
+		name:   "valid dsse entry about the payload",
+		signer: ecKey,
+		blob:   blob,
+		body:   `{"kind":"dsse","apiVersion":"0.0.1","spec":{"envelopeHash":{"algorithm":"sha256","value":"00"},"payloadHash":{"algorithm":"sha256","value":"%s"}}}`,
+	}, {
+		name:      "digest outside of the artifact hash",
+		signer:    ecKey,
+		blob:      blob,
+		body:      `{"kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"00"}},"signature":{"content":"%s"}}}`,
+		expectErr: true,
+	}, {
+		name:      "digest of another algorithm",
+		signer:    ecKey,
+		blob:      blob,
+		body:      `{"kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha512","value":"%s"}}}}`,
+		expectErr: true,
+	}, {
+		name:      "unsupported kind",
+		signer:    ecKey,
+		blob:      blob,
+		body:      `{"kind":"custom","spec":{"data":{"hash":{"algorithm":"sha256","value":"%s"}}}}`,
+		expectErr: true,
+	}, {
+		name:   "valid ed25519 entry",
+		signer: edKey,
+		blob:   blob,
+	}, {
+		name:   "valid entry without inclusion proof",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			e.Verification.InclusionProof = nil
+		},
+	}, {
+		name:      "entry about another document",
+		signer:    ecKey,
+		blob:      []byte(`{"other":"document"}`),
+		expectErr: true,
+	}, {
+		name:   "entry from another log",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			v, _ := NewVerifier(otherKey.Public())
+			e.LogID = v.logID
+		},
+		expectErr: true,
+	}, {
+		name:   "missing signed entry timestamp",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			e.Verification.SignedEntryTimestamp = ""
+		},
+		expectErr: true,
+	}, {
+		name:   "tampered integrated time",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			e.IntegratedTime++
+		},
+		expectErr: true,
+	}, {
+		name:   "tampered inclusion proof hash",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			e.Verification.InclusionProof.Hashes[0] = hex.EncodeToString(make([]byte, 32))
+		},
+		expectErr: true,
+	}, {
+		name:   "truncated inclusion proof",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			p := e.Verification.InclusionProof
+			p.Hashes = p.Hashes[:len(p.Hashes)-1]
+		},
+		expectErr: true,
+	}, {
+		name:   "inclusion proof for another index",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			e.Verification.InclusionProof.LogIndex--
+		},
+		expectErr: true,
+	}, {
+		name:   "checkpoint for another tree",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			p := e.Verification.InclusionProof
+			p.Checkpoint = fmt.Sprintf("%s\n— test.log AAAAAAAA\n", checkpointText("test.log", p.TreeSize+1, make([]byte, 32)))
+		},
+		expectErr: true,
+	}, {
+		name:   "checkpoint not signed by the log",
+		signer: ecKey,
+		blob:   blob,
+		modify: func(e *LogEntry) {
+			p := e.Verification.InclusionProof
+			root, _ := hex.DecodeString(p.RootHash)
+			p.Checkpoint = fmt.Sprintf("%s\n— test.log AAAAAAAA\n", checkpointText("test.log", p.TreeSize, root))
+		},
+		expectErr: true,
+	}}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLog(t, tt.signer)
+			body := tt.body
+			if body == "" {
+				body = hashedRekordBody
+			}
+			e := l.add(t, body, blob)
+			if tt.modify != nil {
+				tt.modify(e)
+			}
+			err := l.v.Verify(e, tt.blob)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Verify() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func Test_NewVerifierFromPEM(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	v, err := NewVerifierFromPEM(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id := sha256.Sum256(der)
+	if v.logID != hex.EncodeToString(id[:]) {
+		t.Errorf("unexpected log id %s", v.logID)
+	}
+
+	if _, err := NewVerifierFromPEM([]byte("not a key")); err == nil {
+		t.Errorf("expected error for invalid PEM")
+	}
+}
+
+// checkpointText returns the note text of a checkpoint, as signed by a log
+func checkpointText(origin string, size int64, root []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n%d\n%s\n", origin, size, base64.StdEncoding.EncodeToString(root))
+	return b.Bytes()
+}
+
+func Test_VerifySigner(t *testing.T) {
+	newCert := func(name string) *x509.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name}}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	cert, otherCert := newCert("signer"), newCert("other")
+	certPEM := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	sig := []byte("signature")
+	b64 := base64.StdEncoding.EncodeToString
+
+	testCases := []struct {
+		name      string
+		body      string
+		cert      *x509.Certificate
+		expectErr bool
+	}{{
+		name: "hashedrekord signature",
+		body: fmt.Sprintf(`{"kind":"hashedrekord","spec":{"signature":{"content":"%s"}}}`, b64(sig)),
+		cert: otherCert,
+	}, {
+		name: "hashedrekord certificate",
+		body: fmt.Sprintf(`{"kind":"hashedrekord","spec":{"signature":{"content":"%s","publicKey":{"content":"%s"}}}}`, b64([]byte("other")), certPEM),
+		cert: cert,
+	}, {
+		name: "intoto signature",
+		body: fmt.Sprintf(`{"kind":"intoto","spec":{"content":{"envelope":{"signatures":[{"sig":"%s"}]}}}}`, b64([]byte(b64(sig)))),
+		cert: otherCert,
+	}, {
+		name: "dsse verifier",
+		body: fmt.Sprintf(`{"kind":"dsse","spec":{"signatures":[{"signature":"%s","verifier":"%s"}]}}`, b64([]byte("other")), certPEM),
+		cert: cert,
+	}, {
+		name:      "other signer",
+		body:      fmt.Sprintf(`{"kind":"dsse","spec":{"signatures":[{"signature":"%s","verifier":"%s"}]}}`, b64([]byte("other")), certPEM),
+		cert:      otherCert,
+		expectErr: true,
+	}, {
+		name:      "no signer",
+		body:      `{"kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":"abcd"}}}}`,
+		cert:      cert,
+		expectErr: true,
+	}, {
+		name:      "unsupported kind",
+		body:      `{"kind":"rfc3161","spec":{}}`,
+		cert:      cert,
+		expectErr: true,
+	}}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySigner(&LogEntry{Body: b64([]byte(tt.body))}, sig, tt.cert)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("VerifySigner() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}